@@ -10,27 +10,57 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
 	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
 	"github.com/vocdoni/storage-proofs-eth-go/token"
 	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
 	"github.com/vocdoni/storage-proofs-eth-go/token/mapbased"
 	"github.com/vocdoni/storage-proofs-eth-go/token/minime"
+	"github.com/vocdoni/storage-proofs-eth-go/token/native"
 )
 
 const timeout = 60 * time.Second
 
+// supportedTypes are the token types which proofs ethproof verifies
+var supportedTypes = map[int]bool{
+	token.TokenTypeAuto:     true,
+	token.TokenTypeMapbased: true,
+	token.TokenTypeMinime:   true,
+	token.TokenTypeVyper:    true,
+	token.TokenTypeNative:   true,
+}
+
 func main() {
 	web3 := flag.String("web3", "https://web3.dappnode.net", "web3 RPC endpoint URL")
-	contract := flag.String("contract", "", "ERC20 contract address")
+	contract := flag.String("contract", "", "ERC20 contract address (not used by native)")
 	holder := flag.String("holder", "", "address of the token holder")
-	contractType := flag.String("type", "mapbased", "ERC20 contract type (mapbased, minime)")
-	height := flag.Int64("height", 0, "ethereum height (0 becomes last block)")
+	contractType := flag.String("type", "auto",
+		"ERC20 contract type (auto, mapbased, minime, vyper), or native for ETH balances")
+	block := flag.String("block", source.TagFinalized,
+		"block number, block hash or tag (latest, safe, finalized, earliest)")
+	height := flag.Int64("height", 0, "ethereum height (deprecated, use -block)")
+	registryPath := flag.String("registry", "",
+		"JSON file of known token slots, updated after discovering a slot")
 	flag.Parse()
 
-	var contractAddr common.Address
-	if err := contractAddr.UnmarshalText([]byte(*contract)); err != nil {
+	ref, err := source.ParseBlockRef(*block)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *height > 0 {
+		ref = source.BlockNumber(big.NewInt(*height))
+	}
+
+	ttype, err := token.ParseTokenType(*contractType)
+	if err != nil {
 		log.Fatal(err)
 	}
+	// Reject the types which proofs are not verified below before any RPC
+	// call or registry write
+	if !supportedTypes[ttype] {
+		log.Fatalf("token type %s not supported by ethproof", *contractType)
+	}
 	var holderAddr common.Address
 	if err := holderAddr.UnmarshalText([]byte(*holder)); err != nil {
 		log.Fatal(err)
@@ -42,7 +72,17 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	ts, err := erc20.New(ctx, rpcCli, contractAddr)
+	src := source.NewRPC(rpcCli)
+	if ttype == token.TokenTypeNative {
+		nativeProof(ctx, src, holderAddr, ref)
+		return
+	}
+
+	var contractAddr common.Address
+	if err := contractAddr.UnmarshalText([]byte(*contract)); err != nil {
+		log.Fatal(err)
+	}
+	ts, err := erc20.New(ctx, src, contractAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -52,7 +92,15 @@ func main() {
 	}
 	decimals := int(tokenData.Decimals)
 
-	balance, err := ts.Balance(ctx, holderAddr)
+	// Resolve the block once, so all the reads are done at the same block
+	header, err := ts.GetBlockHeader(ctx, ref)
+	if err != nil {
+		log.Fatalf("cannot get block %v: %v", ref, err)
+	}
+	log.Printf("using block %v (%s)", header.Number, header.Hash().Hex())
+	ref = source.BlockHash(header.Hash(), true)
+
+	balance, err := ts.BalanceAtHash(ctx, holderAddr, header.Hash())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -63,40 +111,44 @@ func main() {
 		return
 	}
 
-	var ttype int
-	switch *contractType {
-	case "mapbased":
-		ttype = token.TokenTypeMapbased
-	case "minime":
-		ttype = token.TokenTypeMinime
-	default:
-		log.Fatalf("token type not supported %s", *contractType)
-	}
-
-	t, err := token.New(ctx, rpcCli, ttype, contractAddr)
-	if err != nil {
-		log.Fatal(err)
+	registry := token.DefaultRegistry()
+	if *registryPath != "" {
+		if registry, err = token.LoadRegistry(*registryPath); err != nil {
+			log.Fatal(err)
+		}
 	}
-	slot, amount, err := t.DiscoverSlot(ctx, holderAddr)
+	t, entry, err := registry.Resolve(ctx, src, ttype, contractAddr, holderAddr, ref)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("storage data -> slot: %d amount: %s", slot, amount.FloatString(decimals))
-
-	var blockNum *big.Int
-	if *height > 0 {
-		blockNum = new(big.Int).SetInt64(*height)
-	} else {
-		blockNumUint64, err := ts.EthCli.BlockNumber(ctx)
-		if err != nil {
+	// The type of an auto detected contract is the registered one
+	if ttype == token.TokenTypeAuto {
+		if ttype, err = entry.TokenType(); err != nil {
 			log.Fatal(err)
 		}
-		blockNum = new(big.Int).SetUint64(blockNumUint64)
+		if !supportedTypes[ttype] {
+			log.Fatalf("token type %s not supported by ethproof", entry.Type)
+		}
 	}
-	sproof, err := t.GetProof(ctx, holderAddr, blockNum, slot)
+	slot := entry.Slot
+	log.Printf("storage data -> type: %s slot: %d", entry.Type, slot)
+
+	sproof, err := t.GetProof(ctx, holderAddr, ref, slot)
 	if err != nil {
 		log.Fatalf("cannot get proof: %v", err)
 	}
+	if impl := sproof.Implementation; impl != nil {
+		log.Printf("%s proxy implementation: %s", impl.Kind, impl.Implementation.Hex())
+	}
+	// The storage proofs are only valid if the storage root is the one of the
+	// contract on the resolved block
+	ok, err := ethstorageproof.VerifyEIP1186WithBlockHash(sproof, header.Hash())
+	if err != nil {
+		log.Fatalf("proof is not valid on block %s: %v", header.Hash().Hex(), err)
+	}
+	if !ok {
+		log.Fatalf("proof is not valid on block %s", header.Hash().Hex())
+	}
 
 	switch ttype {
 	case token.TokenTypeMinime:
@@ -117,14 +169,19 @@ func main() {
 		); err != nil {
 			log.Fatal(err)
 		}
-	case token.TokenTypeMapbased:
+	case token.TokenTypeMapbased, token.TokenTypeVyper:
 		balance, fullBalance := helpers.ValueToBalance(
 			sproof.StorageProof[0].Value,
 			int(tokenData.Decimals),
 		)
-		log.Printf("mapbased balance on block %v: %s", blockNum,
+		log.Printf("mapbased balance on block %v: %s", sproof.Height,
 			balance.FloatString(decimals))
-		if err := mapbased.VerifyProof(
+		layout := helpers.LayoutSolidity
+		if ttype == token.TokenTypeVyper {
+			layout = helpers.LayoutVyper
+		}
+		if err := mapbased.VerifyLayoutProof(
+			layout,
 			holderAddr,
 			sproof.StorageHash,
 			sproof.StorageProof[0],
@@ -138,6 +195,47 @@ func main() {
 		log.Fatal("token type not supported")
 	}
 
+	printProof(sproof)
+}
+
+// nativeProof gets and verifies the proof of the ETH balance of holder at the
+// block referred by ref
+func nativeProof(ctx context.Context, src source.ProofSource, holder common.Address,
+	ref source.BlockRef) {
+	t, err := native.New(ctx, src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Resolve the block apart from the proof, so the proof is verified
+	// against a block hash which does not come from the proof itself
+	header, err := src.BlockHeader(ctx, ref)
+	if err != nil {
+		log.Fatalf("cannot get block %v: %v", ref, err)
+	}
+	log.Printf("using block %v (%s)", header.Number, header.Hash().Hex())
+	sproof, err := t.GetProof(ctx, holder, source.BlockHash(header.Hash(), true), 0)
+	if err != nil {
+		log.Fatalf("cannot get proof: %v", err)
+	}
+	ok, err := ethstorageproof.VerifyEIP1186WithBlockHash(sproof, header.Hash())
+	if err != nil {
+		log.Fatalf("proof is not valid on block %s: %v", header.Hash().Hex(), err)
+	}
+	if !ok {
+		log.Fatalf("proof is not valid on block %s", header.Hash().Hex())
+	}
+	balance := sproof.Balance.ToInt()
+	log.Printf("holder:%v balance:%s ETH", holder,
+		helpers.BalanceToRat(balance, native.Decimals).FloatString(native.Decimals))
+	log.Printf("state root: %x\n", sproof.StateRoot)
+	if err := native.VerifyProof(holder, sproof, balance); err != nil {
+		log.Fatal(err)
+	}
+	printProof(sproof)
+}
+
+// printProof logs the proof as JSON
+func printProof(sproof *ethstorageproof.StorageProof) {
 	sproofBytes, err := json.MarshalIndent(sproof, "", " ")
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,61 @@
+package ethstorageproof
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// VerifyAbsence verifies that the path generated from key, following the
+// nodes in proof, proves key is not part of the trie with root rootHash.
+// Returns an error wrapping ErrValueMismatch if the key exists, or
+// ErrMissingNode/ErrInvalidNode if the proof is not valid.
+func VerifyAbsence(rootHash common.Hash, key []byte, proof [][]byte) error {
+	value, err := proofValue(rootHash, key, proof)
+	if err != nil {
+		return err
+	}
+	if value != nil {
+		return fmt.Errorf("%w: key %x exists", ErrValueMismatch, key)
+	}
+	return nil
+}
+
+// VerifyStorageAbsence verifies that the storage key of proof is not set on
+// the storage trie with root storageHash, which means the storage slot holds
+// the zero value.  The Value of the proof, if any, must be zero.
+func VerifyStorageAbsence(proof *StorageResult, storageHash common.Hash) error {
+	if new(big.Int).SetBytes(proof.Value).Sign() != 0 {
+		return fmt.Errorf("%w: proof value is not zero", ErrValueMismatch)
+	}
+	return VerifyAbsence(storageHash, storageKey(proof.Key), proof.Proof)
+}
+
+// VerifyAccountAbsence verifies that address is not part of the state trie
+// with root stateRoot, following the nodes in accountProof.
+func VerifyAccountAbsence(stateRoot common.Hash, address common.Address,
+	accountProof [][]byte) error {
+	return VerifyAbsence(stateRoot, address.Bytes(), accountProof)
+}
+
+// StorageValue follows the storage proof against storageHash and returns the
+// proven value of the storage key, ignoring the Value field of proof.  A key
+// that is not set on the storage trie holds the zero value and exists is
+// false.  An error is only returned if the proof is not valid, so a zero value
+// can be told apart from a malformed proof.
+func StorageValue(proof *StorageResult, storageHash common.Hash) (*big.Int, bool, error) {
+	res, err := proofValue(storageHash, storageKey(proof.Key), proof.Proof)
+	if err != nil {
+		return nil, false, err
+	}
+	if res == nil {
+		return new(big.Int), false, nil
+	}
+	var value []byte
+	if err := rlp.DecodeBytes(res, &value); err != nil {
+		return nil, false, fmt.Errorf("%w: cannot decode value: %v", ErrInvalidNode, err)
+	}
+	return new(big.Int).SetBytes(value), true, nil
+}
@@ -0,0 +1,103 @@
+package ethstorageproof
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+	qt "github.com/frankban/quicktest"
+)
+
+// testStorageTrie builds a storage trie with the given slot values and returns
+// its root and a function to get storage proofs for it.
+func testStorageTrie(c *qt.C, slots map[common.Hash]*big.Int) (common.Hash,
+	func(key common.Hash) *StorageResult) {
+	tr := trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	for k, v := range slots {
+		value, err := rlp.EncodeToBytes(v.Bytes())
+		c.Assert(err, qt.IsNil)
+		tr.MustUpdate(crypto.Keccak256(k[:]), value)
+	}
+	return tr.Hash(), func(key common.Hash) *StorageResult {
+		db := memorydb.New()
+		c.Assert(tr.Prove(crypto.Keccak256(key[:]), db), qt.IsNil)
+		var proof [][]byte
+		it := db.NewIterator(nil, nil)
+		for it.Next() {
+			proof = append(proof, common.CopyBytes(it.Value()))
+		}
+		it.Release()
+		sr := &StorageResult{Key: key[:], Proof: proof}
+		if v, ok := slots[key]; ok {
+			sr.Value = v.Bytes()
+		}
+		return sr
+	}
+}
+
+// checkErrorIs checks err wraps target
+func checkErrorIs(c *qt.C, err, target error) {
+	c.Helper()
+	c.Check(errors.Is(err, target), qt.IsTrue, qt.Commentf("error %v is not %v", err, target))
+}
+
+func TestVerifyAbsence(t *testing.T) {
+	c := qt.New(t)
+	slots := make(map[common.Hash]*big.Int)
+	for i := int64(1); i <= 64; i++ {
+		slots[common.BigToHash(big.NewInt(i))] = big.NewInt(i * 1000)
+	}
+	root, prove := testStorageTrie(c, slots)
+
+	// Existing key
+	sp := prove(common.BigToHash(big.NewInt(7)))
+	checkErrorIs(c, VerifyStorageAbsence(sp, root), ErrValueMismatch)
+	value, exists, err := StorageValue(sp, root)
+	c.Assert(err, qt.IsNil)
+	c.Assert(exists, qt.IsTrue)
+	c.Assert(value.Int64(), qt.Equals, int64(7000))
+
+	// Non existing key, the value reported by eth_getProof would be 0x0
+	sp = prove(common.BigToHash(big.NewInt(100)))
+	c.Assert(VerifyStorageAbsence(sp, root), qt.IsNil)
+	c.Assert(VerifyAbsence(root, sp.Key, sp.Proof), qt.IsNil)
+	ok, err := VerifyEthStorageProof(sp, root)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+	value, exists, err = StorageValue(sp, root)
+	c.Assert(err, qt.IsNil)
+	c.Assert(exists, qt.IsFalse)
+	c.Assert(value.Sign(), qt.Equals, 0)
+
+	// A proof of absence with a reported value is not valid
+	sp.Value = []byte{1}
+	checkErrorIs(c, VerifyStorageAbsence(sp, root), ErrValueMismatch)
+
+	// A malformed proof is not a zero value
+	sp.Proof = sp.Proof[1:]
+	_, _, err = StorageValue(sp, root)
+	checkErrorIs(c, err, ErrMissingNode)
+
+	// Any key is absent on an empty storage trie
+	checkErrorIs(c, VerifyAbsence(root, sp.Key, nil), ErrMissingNode)
+	c.Assert(VerifyAbsence(types.EmptyRootHash, sp.Key, nil), qt.IsNil)
+}
+
+func TestVerifyAccountAbsence(t *testing.T) {
+	c := qt.New(t)
+	var sp StorageProof
+	c.Assert(json.Unmarshal([]byte(EIP1186Proof), &sp), qt.IsNil)
+	checkErrorIs(c, VerifyAccountAbsence(sp.StateRoot, sp.Address, sp.AccountProof),
+		ErrValueMismatch)
+	checkErrorIs(c, VerifyAccountAbsence(sp.StateRoot, sp.Address, sp.AccountProof[:2]),
+		ErrMissingNode)
+}
@@ -0,0 +1,223 @@
+package ethstorageproof
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// The binary encoding of the proofs is a header followed by the RLP encoding
+// of the proof.  The header is made of the magic bytes, the format version
+// and the kind of object encoded.
+var binaryMagic = []byte("ESP")
+
+const (
+	// BinaryVersion is the current version of the binary encoding
+	BinaryVersion = 1
+
+	binaryKindStorageProof  = 1
+	binaryKindStorageResult = 2
+
+	binaryHeaderLen = 5
+)
+
+// binaryStorageResult is the RLP encoding of a StorageResult
+type binaryStorageResult struct {
+	Key   []byte
+	Value []byte
+	Proof [][]byte
+}
+
+// binaryStorageProof is the RLP encoding of a StorageProof.  Height and
+// Balance are encoded as lists of zero or one items, so nil values are
+// preserved.  Header is empty when the proof has no block header.  BlockHash
+// and Implementation are optional, so encodings without them can still be
+// decoded.
+type binaryStorageProof struct {
+	Height         []*big.Int
+	Address        common.Address
+	Balance        []*big.Int
+	CodeHash       common.Hash
+	Nonce          uint64
+	StateRoot      common.Hash
+	StorageHash    common.Hash
+	AccountProof   [][]byte
+	StorageProof   []binaryStorageResult
+	Header         []byte
+	BlockHash      common.Hash                 `rlp:"optional"`
+	Implementation []binaryImplementationProof `rlp:"optional"`
+}
+
+// binaryImplementationProof is the RLP encoding of an ImplementationProof.
+// Beacon is a list of zero or one items.  BeaconProof is the binary encoding
+// of the beacon proof, empty if there is none.
+type binaryImplementationProof struct {
+	Kind           string
+	Implementation common.Address
+	Beacon         []common.Address
+	Slot           binaryStorageResult
+	BeaconProof    []byte `rlp:"optional"`
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (r StorageResult) MarshalBinary() ([]byte, error) {
+	return encodeBinary(binaryKindStorageResult, binaryStorageResult{
+		Key:   r.Key,
+		Value: r.Value,
+		Proof: r.Proof,
+	})
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (r *StorageResult) UnmarshalBinary(data []byte) error {
+	var br binaryStorageResult
+	if err := decodeBinary(binaryKindStorageResult, data, &br); err != nil {
+		return err
+	}
+	*r = StorageResult{Key: br.Key, Value: br.Value, Proof: br.Proof}
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler
+func (p *StorageProof) MarshalBinary() ([]byte, error) {
+	bp := binaryStorageProof{
+		Address:      p.Address,
+		CodeHash:     p.CodeHash,
+		Nonce:        uint64(p.Nonce),
+		StateRoot:    p.StateRoot,
+		StorageHash:  p.StorageHash,
+		AccountProof: p.AccountProof,
+		StorageProof: make([]binaryStorageResult, len(p.StorageProof)),
+		BlockHash:    p.BlockHash,
+	}
+	if p.Height != nil {
+		bp.Height = []*big.Int{p.Height}
+	}
+	if p.Balance != nil {
+		bp.Balance = []*big.Int{p.Balance.ToInt()}
+	}
+	for i, sr := range p.StorageProof {
+		bp.StorageProof[i] = binaryStorageResult{Key: sr.Key, Value: sr.Value, Proof: sr.Proof}
+	}
+	if p.Header != nil {
+		bp.Header = p.Header.RLP()
+	}
+	if ip := p.Implementation; ip != nil {
+		bip := binaryImplementationProof{
+			Kind:           ip.Kind,
+			Implementation: ip.Implementation,
+			Slot: binaryStorageResult{
+				Key:   ip.Slot.Key,
+				Value: ip.Slot.Value,
+				Proof: ip.Slot.Proof,
+			},
+		}
+		if ip.Beacon != nil {
+			bip.Beacon = []common.Address{*ip.Beacon}
+		}
+		if ip.BeaconProof != nil {
+			beaconProof, err := ip.BeaconProof.MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			bip.BeaconProof = beaconProof
+		}
+		bp.Implementation = []binaryImplementationProof{bip}
+	}
+	return encodeBinary(binaryKindStorageProof, bp)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (p *StorageProof) UnmarshalBinary(data []byte) error {
+	var bp binaryStorageProof
+	if err := decodeBinary(binaryKindStorageProof, data, &bp); err != nil {
+		return err
+	}
+	if len(bp.Height) > 1 || len(bp.Balance) > 1 || len(bp.Implementation) > 1 {
+		return fmt.Errorf("invalid binary storage proof")
+	}
+	sp := StorageProof{
+		Address:      bp.Address,
+		CodeHash:     bp.CodeHash,
+		Nonce:        hexutil.Uint64(bp.Nonce),
+		StateRoot:    bp.StateRoot,
+		StorageHash:  bp.StorageHash,
+		AccountProof: bp.AccountProof,
+		StorageProof: make([]StorageResult, len(bp.StorageProof)),
+		BlockHash:    bp.BlockHash,
+	}
+	if len(bp.Height) == 1 {
+		sp.Height = bp.Height[0]
+	}
+	if len(bp.Balance) == 1 {
+		sp.Balance = (*hexutil.Big)(bp.Balance[0])
+	}
+	for i, sr := range bp.StorageProof {
+		sp.StorageProof[i] = StorageResult{Key: sr.Key, Value: sr.Value, Proof: sr.Proof}
+	}
+	if len(bp.Header) != 0 {
+		header, err := DecodeBlockHeader(bp.Header)
+		if err != nil {
+			return err
+		}
+		sp.Header = header
+	}
+	if len(bp.Implementation) == 1 {
+		bip := bp.Implementation[0]
+		if len(bip.Beacon) > 1 {
+			return fmt.Errorf("invalid binary storage proof")
+		}
+		sp.Implementation = &ImplementationProof{
+			Kind:           bip.Kind,
+			Implementation: bip.Implementation,
+			Slot: StorageResult{
+				Key:   bip.Slot.Key,
+				Value: bip.Slot.Value,
+				Proof: bip.Slot.Proof,
+			},
+		}
+		if len(bip.Beacon) == 1 {
+			sp.Implementation.Beacon = &bip.Beacon[0]
+		}
+		if len(bip.BeaconProof) != 0 {
+			sp.Implementation.BeaconProof = new(StorageProof)
+			if err := sp.Implementation.BeaconProof.UnmarshalBinary(bip.BeaconProof); err != nil {
+				return err
+			}
+		}
+	}
+	*p = sp
+	return nil
+}
+
+// encodeBinary returns the binary header for kind followed by the RLP
+// encoding of v
+func encodeBinary(kind byte, v interface{}) ([]byte, error) {
+	payload, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, binaryHeaderLen+len(payload))
+	data = append(data, binaryMagic...)
+	data = append(data, BinaryVersion, kind)
+	return append(data, payload...), nil
+}
+
+// decodeBinary checks the binary header of data and decodes the RLP payload
+// into v
+func decodeBinary(kind byte, data []byte, v interface{}) error {
+	if len(data) < binaryHeaderLen || !bytes.Equal(data[:len(binaryMagic)], binaryMagic) {
+		return fmt.Errorf("invalid binary encoding: bad magic")
+	}
+	if version := data[len(binaryMagic)]; version != BinaryVersion {
+		return fmt.Errorf("unsupported binary encoding version %d", version)
+	}
+	if k := data[len(binaryMagic)+1]; k != kind {
+		return fmt.Errorf("unexpected binary encoding kind %d", k)
+	}
+	return rlp.DecodeBytes(data[binaryHeaderLen:], v)
+}
@@ -0,0 +1,87 @@
+package ethstorageproof
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestBinaryEncoding(t *testing.T) {
+	c := qt.New(t)
+	var sp StorageProof
+	c.Assert(json.Unmarshal([]byte(EIP1186Proof), &sp), qt.IsNil)
+	raw, err := rlp.EncodeToBytes(testHeader(ForkCancun, sp.StateRoot, sp.Height.Int64()))
+	c.Assert(err, qt.IsNil)
+	sp.Header, err = DecodeBlockHeader(raw)
+	c.Assert(err, qt.IsNil)
+	sp.BlockHash = sp.Header.Hash()
+
+	data, err := sp.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	jsonData, err := json.Marshal(&sp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(len(data) < len(jsonData)/2, qt.IsTrue)
+
+	var sp2 StorageProof
+	c.Assert(sp2.UnmarshalBinary(data), qt.IsNil)
+	c.Assert(sp2.Height.Cmp(sp.Height), qt.Equals, 0)
+	c.Assert(sp2.Balance.ToInt().Cmp(sp.Balance.ToInt()), qt.Equals, 0)
+	c.Assert(sp2.Header.Hash(), qt.Equals, sp.Header.Hash())
+	c.Assert(sp2.BlockHash, qt.Equals, sp.BlockHash)
+	c.Assert(sp2.StorageProof, qt.DeepEquals, sp.StorageProof)
+	ok, err := VerifyEIP1186WithBlockHash(&sp2, sp.Header.Hash())
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+
+	// Optional fields are preserved
+	sp.Height, sp.Header, sp.BlockHash = nil, nil, common.Hash{}
+	data, err = sp.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	c.Assert(sp2.UnmarshalBinary(data), qt.IsNil)
+	c.Assert(sp2.Height, qt.IsNil)
+	c.Assert(sp2.Header, qt.IsNil)
+	c.Assert(sp2.BlockHash, qt.Equals, common.Hash{})
+	c.Assert(sp2.Implementation, qt.IsNil)
+
+	// Implementation proofs
+	beacon := common.HexToAddress("0x01")
+	beaconProof := sp
+	sp.Implementation = &ImplementationProof{
+		Kind:           ProxyEIP1967Beacon,
+		Implementation: common.HexToAddress("0x02"),
+		Beacon:         &beacon,
+		Slot:           sp.StorageProof[0],
+		BeaconProof:    &beaconProof,
+	}
+	data, err = sp.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	c.Assert(sp2.UnmarshalBinary(data), qt.IsNil)
+	// The beacon proof is encoded as a whole proof
+	beaconData, err := sp.Implementation.BeaconProof.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	beaconData2, err := sp2.Implementation.BeaconProof.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	c.Assert(beaconData2, qt.DeepEquals, beaconData)
+	sp.Implementation.BeaconProof, sp2.Implementation.BeaconProof = nil, nil
+	c.Assert(sp2.Implementation, qt.DeepEquals, sp.Implementation)
+	sp.Implementation.Beacon = nil
+	data, err = sp.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	c.Assert(sp2.UnmarshalBinary(data), qt.IsNil)
+	c.Assert(sp2.Implementation, qt.DeepEquals, sp.Implementation)
+
+	// Storage results
+	data, err = sp.StorageProof[0].MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	var sr StorageResult
+	c.Assert(sr.UnmarshalBinary(data), qt.IsNil)
+	c.Assert(sr, qt.DeepEquals, sp.StorageProof[0])
+	c.Assert(sp2.UnmarshalBinary(data), qt.ErrorMatches, "unexpected binary encoding kind.*")
+
+	data[len(binaryMagic)] = BinaryVersion + 1
+	c.Assert(sr.UnmarshalBinary(data), qt.ErrorMatches, "unsupported binary encoding version.*")
+	c.Assert(sr.UnmarshalBinary(data[1:]), qt.ErrorMatches, ".*bad magic")
+}
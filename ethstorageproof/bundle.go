@@ -0,0 +1,91 @@
+package ethstorageproof
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ProofBundle is a compact representation of a list of storage proofs.  The
+// trie nodes are stored once in a node table and each entry references the
+// nodes of its proof by hash.  It can be converted to and from a list of
+// StorageResult without losing information.
+type ProofBundle struct {
+	Nodes   SliceData     `json:"nodes"`
+	Entries []BundleEntry `json:"entries"`
+}
+
+// BundleEntry is a storage proof of a ProofBundle.  Proof contains the hashes
+// of the proof nodes, in the same order as the original proof.
+type BundleEntry struct {
+	Key   QuantityBytes `json:"key"`
+	Value QuantityBytes `json:"value"`
+	Proof []common.Hash `json:"proof"`
+}
+
+// NewProofBundle builds a ProofBundle from a list of storage proofs.
+func NewProofBundle(proofs []StorageResult) *ProofBundle {
+	b := &ProofBundle{Entries: make([]BundleEntry, len(proofs))}
+	seen := make(map[common.Hash]bool)
+	for i, p := range proofs {
+		entry := BundleEntry{
+			Key:   p.Key,
+			Value: p.Value,
+			Proof: make([]common.Hash, len(p.Proof)),
+		}
+		for j, node := range p.Proof {
+			h := crypto.Keccak256Hash(node)
+			entry.Proof[j] = h
+			if !seen[h] {
+				seen[h] = true
+				b.Nodes = append(b.Nodes, node)
+			}
+		}
+		b.Entries[i] = entry
+	}
+	return b
+}
+
+// StorageResults expands the ProofBundle into the original list of storage
+// proofs.  Returns an error wrapping ErrMissingNode if an entry references a
+// node that is not in the node table.
+func (b *ProofBundle) StorageResults() ([]StorageResult, error) {
+	nodes := make(map[common.Hash][]byte, len(b.Nodes))
+	for _, node := range b.Nodes {
+		nodes[crypto.Keccak256Hash(node)] = node
+	}
+	results := make([]StorageResult, len(b.Entries))
+	for i, entry := range b.Entries {
+		results[i] = StorageResult{
+			Key:   entry.Key,
+			Value: entry.Value,
+			Proof: make(SliceData, len(entry.Proof)),
+		}
+		for j, h := range entry.Proof {
+			node, ok := nodes[h]
+			if !ok {
+				return nil, fmt.Errorf("%w: entry %d references unknown node %x",
+					ErrMissingNode, i, h)
+			}
+			results[i].Proof[j] = node
+		}
+	}
+	return results, nil
+}
+
+// MultiProof returns the MultiProof of all the entries of the bundle against
+// the storage root.
+func (b *ProofBundle) MultiProof(root common.Hash) *MultiProof {
+	mp := &MultiProof{
+		Root:   root,
+		Keys:   make(SliceData, len(b.Entries)),
+		Values: make([]QuantityBytes, len(b.Entries)),
+		Nodes:  b.Nodes,
+	}
+	for i, entry := range b.Entries {
+		mp.Keys[i] = entry.Key
+		mp.Values[i] = entry.Value
+	}
+	return mp
+}
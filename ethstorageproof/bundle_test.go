@@ -0,0 +1,49 @@
+package ethstorageproof
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestProofBundle(t *testing.T) {
+	c := qt.New(t)
+	slots := make(map[common.Hash]*big.Int)
+	for i := int64(1); i <= 256; i++ {
+		slots[common.BigToHash(big.NewInt(i))] = big.NewInt(i)
+	}
+	root, prove := testStorageTrie(c, slots)
+
+	var proofs []StorageResult
+	for i := int64(1); i <= 300; i += 2 {
+		proofs = append(proofs, *prove(common.BigToHash(big.NewInt(i))))
+	}
+	bundle := NewProofBundle(proofs)
+	results, err := bundle.StorageResults()
+	c.Assert(err, qt.IsNil)
+	c.Assert(results, qt.DeepEquals, proofs)
+	c.Assert(VerifyMultiProof(bundle.MultiProof(root)), qt.IsNil)
+
+	bundleJSON, err := json.Marshal(bundle)
+	c.Assert(err, qt.IsNil)
+	proofsJSON, err := json.Marshal(proofs)
+	c.Assert(err, qt.IsNil)
+	c.Assert(len(bundleJSON) < len(proofsJSON), qt.IsTrue)
+
+	var bundle2 ProofBundle
+	c.Assert(json.Unmarshal(bundleJSON, &bundle2), qt.IsNil)
+	results, err = bundle2.StorageResults()
+	c.Assert(err, qt.IsNil)
+	for i := range results {
+		ok, err := VerifyEthStorageProof(&results[i], root)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ok, qt.IsTrue)
+	}
+
+	bundle2.Nodes = bundle2.Nodes[1:]
+	_, err = bundle2.StorageResults()
+	checkErrorIs(c, err, ErrMissingNode)
+}
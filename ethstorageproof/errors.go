@@ -0,0 +1,27 @@
+package ethstorageproof
+
+import "errors"
+
+// Errors returned by the proof verification functions of this module.  They
+// are usually wrapped with more context, use errors.Is to check them.
+var (
+	// ErrValueMismatch is returned when a proven value is not the expected one.
+	ErrValueMismatch = errors.New("value mismatch")
+	// ErrMissingNode is returned when a node required to follow the key path
+	// is not part of the proof.
+	ErrMissingNode = errors.New("missing proof node")
+	// ErrInvalidNode is returned when a node of the proof cannot be decoded.
+	ErrInvalidNode = errors.New("invalid proof node")
+	// ErrBadKey is returned when a proof key is malformed or does not match
+	// the expected storage slot.
+	ErrBadKey = errors.New("bad proof key")
+	// ErrRootMismatch is returned when a root or hash committing to the proof
+	// (block hash, state root or storage root) is not the expected one.
+	ErrRootMismatch = errors.New("root mismatch")
+	// ErrCheckpointRange is returned when the checkpoints of a proof do not
+	// enclose the target block.
+	ErrCheckpointRange = errors.New("checkpoint range violation")
+	// ErrKeyOffsetOverflow is returned when a checkpoint key is too far from
+	// the position of the holder array.
+	ErrKeyOffsetOverflow = errors.New("key offset overflow")
+)
@@ -4,26 +4,181 @@ package ethstorageproof
 
 import (
 	"bytes"
+	"fmt"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
 )
 
+// ProofLayer identifies the part of an EIP1186 proof a verification refers to.
+type ProofLayer int
+
+const (
+	// LayerAccount is the account proof against the StateRoot.
+	LayerAccount ProofLayer = iota
+	// LayerStorage is a storage proof against the account storage root.
+	LayerStorage
+	// LayerHeader is the block header against a trusted block hash.
+	LayerHeader
+	// LayerImplementation is the proxy implementation slot proof against
+	// the account storage root, and the beacon proof of beacon proxies.
+	LayerImplementation
+)
+
+// String implements fmt.Stringer
+func (l ProofLayer) String() string {
+	switch l {
+	case LayerAccount:
+		return "account"
+	case LayerStorage:
+		return "storage"
+	case LayerHeader:
+		return "header"
+	case LayerImplementation:
+		return "implementation"
+	default:
+		return fmt.Sprintf("layer(%d)", int(l))
+	}
+}
+
+// ProofError is the error returned by VerifyEIP1186.  It reports which layer
+// of the proof failed and, for storage proofs, the index of the failing one.
+type ProofError struct {
+	Layer ProofLayer
+	// Index is the position of the failing proof in StorageProof.  Only
+	// meaningful when Layer is LayerStorage.
+	Index int
+	Err   error
+}
+
+// Error implements the error interface
+func (e *ProofError) Error() string {
+	if e.Layer == LayerStorage {
+		return fmt.Sprintf("%s proof %d: %v", e.Layer, e.Index, e.Err)
+	}
+	return fmt.Sprintf("%s proof: %v", e.Layer, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ProofError) Unwrap() error {
+	return e.Err
+}
+
+// account is the RLP structure of an Ethereum account stored in the state trie.
+type account struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash
+	CodeHash []byte
+}
+
 // VerifyEIP1186 verifies the whole Ethereum proof obtained with eth_getProof
-// method against a StateRoot.  It verifies Account proof against StateRoot and
-// all Storage proofs against StorageHash.
+// method against a StateRoot.  It verifies the Account proof against
+// StateRoot, takes the storage root from the proven account and verifies all
+// Storage proofs against it, including the proxy Implementation proof if
+// set.  On failure the returned error is a *ProofError describing the layer
+// that failed.
 func VerifyEIP1186(proof *StorageProof) (bool, error) {
-	for _, sp := range proof.StorageProof {
-		sp := sp
-		if ok, err := VerifyEthStorageProof(&sp, proof.StorageHash); !ok {
-			return false, err
+	storageRoot, err := verifyAccount(proof)
+	if err != nil {
+		return false, &ProofError{Layer: LayerAccount, Err: err}
+	}
+	if err := verifyStorageProofs(storageRoot, proof.StorageProof); err != nil {
+		return false, err
+	}
+	if proof.Implementation != nil {
+		if err := VerifyImplementationProof(proof.Implementation, proof.StateRoot,
+			storageRoot); err != nil {
+			return false, &ProofError{Layer: LayerImplementation, Err: err}
 		}
 	}
 	return true, nil
 }
 
+// verifyStorageProofs verifies all the storage proofs against storageRoot
+// loading their nodes into a single proof database, so nodes shared by
+// several proofs are only hashed once.  Returns a *ProofError on failure.
+func verifyStorageProofs(storageRoot common.Hash, proofs []StorageResult) error {
+	nodes := make([][][]byte, len(proofs))
+	for i := range proofs {
+		nodes[i] = proofs[i].Proof
+	}
+	proofDB := NewProofDB(nodes...)
+	for i := range proofs {
+		if err := verifyStorageValue(proofDB, storageRoot, proofs[i].Key,
+			proofs[i].Value); err != nil {
+			return &ProofError{Layer: LayerStorage, Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// verifyStorageValue verifies the storage key holds value on the storage trie
+// with root storageRoot, which nodes are in proofDB.  An empty value is
+// verified as a proof of non existence.
+func verifyStorageValue(proofDB *MemDB, storageRoot common.Hash, key, value []byte) error {
+	var err error
+	var leaf []byte
+	if len(value) != 0 {
+		leaf, err = rlp.EncodeToBytes(value)
+		if err != nil {
+			return err
+		}
+	}
+	res, err := dbValue(proofDB, storageRoot, storageKey(key))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(leaf, res) {
+		return fmt.Errorf("%w: (%x != %x)", ErrValueMismatch, res, leaf)
+	}
+	return nil
+}
+
+// verifyAccount verifies the account proof against the StateRoot and checks
+// the proven account matches the fields of the proof.  Returns the storage
+// root of the proven account.
+func verifyAccount(proof *StorageProof) (common.Hash, error) {
+	value, err := proofValue(proof.StateRoot, proof.Address.Bytes(), proof.AccountProof)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if value == nil {
+		// A non existing account is reported by eth_getProof as an empty
+		// account, so it can only hold empty storage.
+		if proof.Nonce != 0 || proof.Balance == nil || proof.Balance.ToInt().Sign() != 0 {
+			return common.Hash{}, fmt.Errorf("%w: account %x not found",
+				ErrValueMismatch, proof.Address)
+		}
+		return types.EmptyRootHash, nil
+	}
+	var acc account
+	if err := rlp.DecodeBytes(value, &acc); err != nil {
+		return common.Hash{}, fmt.Errorf("cannot decode account: %w", err)
+	}
+	if acc.Nonce != uint64(proof.Nonce) {
+		return common.Hash{}, fmt.Errorf("%w: nonce (%d != %d)",
+			ErrValueMismatch, acc.Nonce, proof.Nonce)
+	}
+	if proof.Balance == nil || acc.Balance.Cmp(proof.Balance.ToInt()) != 0 {
+		return common.Hash{}, fmt.Errorf("%w: balance (%v != %v)",
+			ErrValueMismatch, acc.Balance, proof.Balance)
+	}
+	if !bytes.Equal(acc.CodeHash, proof.CodeHash.Bytes()) {
+		return common.Hash{}, fmt.Errorf("%w: code hash (%x != %x)",
+			ErrValueMismatch, acc.CodeHash, proof.CodeHash)
+	}
+	if acc.Root != proof.StorageHash {
+		return common.Hash{}, fmt.Errorf("%w: storage hash (%x != %x)",
+			ErrRootMismatch, acc.Root, proof.StorageHash)
+	}
+	return acc.Root, nil
+}
+
 // VerifyEthAccountProof verifies an Ethereum account proof against the StateRoot.
 // It does not verify the storage proof(s).
 func VerifyEthAccountProof(proof *StorageProof) (bool, error) {
@@ -49,27 +204,81 @@ func VerifyEthStorageProof(proof *StorageResult, storageHash common.Hash) (bool,
 			return false, err
 		}
 	}
-	return VerifyProof(storageHash, proof.Key, value, proof.Proof)
+	return VerifyProof(storageHash, storageKey(proof.Key), value, proof.Proof)
+}
+
+// storageKey returns the storage key as a 32 bytes word.  Keys are encoded as
+// QUANTITY, so their leading zeroes can be trimmed.
+func storageKey(key []byte) []byte {
+	return common.LeftPadBytes(key, common.HashLength)
+}
+
+// VerifyProofDB is like VerifyProof, but follows the nodes of proofDB, which
+// can be shared by concurrent verifications of different keys.
+func VerifyProofDB(proofDB *MemDB, rootHash common.Hash, key []byte,
+	value []byte) (bool, error) {
+	res, err := dbValue(proofDB, rootHash, key)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(value, res) {
+		return false, fmt.Errorf("%w: (%x != %x)", ErrValueMismatch, res, value)
+	}
+	return true, nil
 }
 
 // VerifyProof verifies that the path generated from key, following the nodes
 // in proof leads to a leaf with value, where the hashes are correct up to the
-// rootHash.
+// rootHash.  If the proof is not valid, the returned error wraps one of
+// ErrValueMismatch, ErrMissingNode or ErrInvalidNode.
 // WARNING: When the value is not found, `eth_getProof` will return "0x0" at
 // the StorageProof `value` field.  In order to verify the proof of non
 // existence, you must set `value` to nil, *not* the RLP encoding of 0 or null
-// (which would be 0x80).
+// (which would be 0x80).  VerifyAbsence is the preferred way to do so.
 func VerifyProof(rootHash common.Hash, key []byte, value []byte, proof [][]byte) (bool, error) {
-	proofDB := NewMemDB()
-	for _, node := range proof {
-		key := crypto.Keccak256(node)
-		proofDB.Put(key, node)
+	return VerifyProofDB(NewProofDB(proof), rootHash, key, value)
+}
+
+// missTracker is an ethdb.KeyValueReader recording whether a key was
+// requested but not found.
+type missTracker struct {
+	*MemDB
+	missed bool
+}
+
+// Get returns the value of the key, recording if it is not found
+func (m *missTracker) Get(key []byte) ([]byte, error) {
+	value, err := m.MemDB.Get(key)
+	if err != nil {
+		m.missed = true
+	}
+	return value, err
+}
+
+// proofValue follows the path generated from key through the nodes in proof
+// and returns the value found at the leaf, or nil if the key is not in the
+// trie.
+func proofValue(rootHash common.Hash, key []byte, proof [][]byte) ([]byte, error) {
+	return dbValue(NewProofDB(proof), rootHash, key)
+}
+
+// dbValue follows the path generated from key through the nodes in proofDB
+// and returns the value found at the leaf, or nil if the key is not in the
+// trie.
+func dbValue(proofDB *MemDB, rootHash common.Hash, key []byte) ([]byte, error) {
+	// The empty trie does not contain any key
+	if rootHash == types.EmptyRootHash {
+		return nil, nil
 	}
 	path := crypto.Keccak256(key)
 
-	res, err := trie.VerifyProof(rootHash, path, proofDB)
+	db := &missTracker{MemDB: proofDB}
+	res, err := trie.VerifyProof(rootHash, path, db)
 	if err != nil {
-		return false, err
+		if db.missed {
+			return nil, fmt.Errorf("%w: %v", ErrMissingNode, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNode, err)
 	}
-	return bytes.Equal(value, res), nil
+	return res, nil
 }
@@ -3,6 +3,7 @@ package ethstorageproof
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"strings"
 	"testing"
 
@@ -164,10 +165,19 @@ func TestVerify(t *testing.T) {
 			}
 		}
 
-		if vp, err := VerifyProof(crypto.Keccak256Hash(tt.proof[0]), tt.key,
-			value, tt.proof); vp != tt.verify {
+		vp, err := VerifyProof(crypto.Keccak256Hash(tt.proof[0]), tt.key, value, tt.proof)
+		if vp != tt.verify {
 			t.Errorf("testcase %d: want %v, got %v (err: %v)\n", i, tt.verify, !tt.verify, err)
 		}
+		if !tt.verify && !errors.Is(err, ErrValueMismatch) {
+			t.Errorf("testcase %d: expected ErrValueMismatch, got %v", i, err)
+		}
+		if len(tt.proof) > 1 {
+			_, err := VerifyProof(crypto.Keccak256Hash(tt.proof[0]), tt.key, value, tt.proof[:1])
+			if !errors.Is(err, ErrMissingNode) {
+				t.Errorf("testcase %d: expected ErrMissingNode, got %v", i, err)
+			}
+		}
 	}
 }
 
@@ -255,9 +265,26 @@ func Test_eipProof(t *testing.T) {
 	if ok, err := VerifyEthStorageProof(&sp.StorageProof[0], sp.StorageHash); !ok {
 		t.Errorf("proof must be valid but it is invalid: (%v)", err)
 	}
+	key := sp.StorageProof[0].Key
 	sp.StorageProof[0].Key = toBytes(t,
 		"0x49c4c8b2db715e9f7e1d3306b9f6860a389635dfb3943db23f1005544a50fbb2")
-	if ok, _ := VerifyEIP1186(&sp); ok {
+	ok, err := VerifyEIP1186(&sp)
+	if ok {
 		t.Errorf("proof must be invalid but it is valid")
 	}
+	var perr *ProofError
+	if !errors.As(err, &perr) || perr.Layer != LayerStorage || perr.Index != 0 {
+		t.Errorf("expected storage layer error, got %v", err)
+	}
+	sp.StorageProof[0].Key = key
+
+	// A storage hash not matching the proven account must be rejected
+	sp.StorageHash = crypto.Keccak256Hash([]byte("forged"))
+	ok, err = VerifyEIP1186(&sp)
+	if ok {
+		t.Errorf("proof with forged storage hash must be invalid but it is valid")
+	}
+	if !errors.As(err, &perr) || perr.Layer != LayerAccount {
+		t.Errorf("expected account layer error, got %v", err)
+	}
 }
@@ -0,0 +1,329 @@
+package ethstorageproof
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// HeaderFork identifies the set of fields of an Ethereum block header.
+type HeaderFork int
+
+const (
+	// ForkLegacy headers have the 15 fields in use before London.
+	ForkLegacy HeaderFork = iota
+	// ForkLondon headers add baseFeePerGas (EIP-1559).
+	ForkLondon
+	// ForkShanghai headers add withdrawalsRoot (EIP-4895).
+	ForkShanghai
+	// ForkCancun headers add blobGasUsed, excessBlobGas (EIP-4844) and
+	// parentBeaconBlockRoot (EIP-4788).
+	ForkCancun
+	// ForkPrague headers add requestsHash (EIP-7685).
+	ForkPrague
+)
+
+// headerFieldsByFork is the number of RLP list items of a header for each fork
+var headerFieldsByFork = map[int]HeaderFork{
+	15: ForkLegacy,
+	16: ForkLondon,
+	17: ForkShanghai,
+	20: ForkCancun,
+	21: ForkPrague,
+}
+
+// Position of the decoded fields in the RLP list of a header
+const (
+	headerParentHashIdx = 0
+	headerRootIdx       = 3
+	headerNumberIdx     = 8
+	headerTimeIdx       = 11
+)
+
+// String implements fmt.Stringer
+func (f HeaderFork) String() string {
+	switch f {
+	case ForkLegacy:
+		return "legacy"
+	case ForkLondon:
+		return "london"
+	case ForkShanghai:
+		return "shanghai"
+	case ForkCancun:
+		return "cancun"
+	case ForkPrague:
+		return "prague"
+	default:
+		return fmt.Sprintf("fork(%d)", int(f))
+	}
+}
+
+// BlockHeader is an RLP encoded Ethereum block header.  Only the fields
+// needed to bind a StateRoot to a block hash are decoded, the raw encoding is
+// kept so the block hash can be recomputed for any of the supported forks.
+// It marshals/unmarshals as a JSON string in hex with 0x prefix.
+type BlockHeader struct {
+	ParentHash common.Hash
+	Root       common.Hash
+	Number     *big.Int
+	Time       uint64
+	Fork       HeaderFork
+	raw        []byte
+}
+
+// DecodeBlockHeader decodes an RLP encoded block header.
+func DecodeBlockHeader(raw []byte) (*BlockHeader, error) {
+	var fields []rlp.RawValue
+	if err := rlp.DecodeBytes(raw, &fields); err != nil {
+		return nil, fmt.Errorf("cannot decode header: %w", err)
+	}
+	fork, ok := headerFieldsByFork[len(fields)]
+	if !ok {
+		return nil, fmt.Errorf("unknown header format with %d fields", len(fields))
+	}
+	h := &BlockHeader{
+		Fork:   fork,
+		Number: new(big.Int),
+		raw:    common.CopyBytes(raw),
+	}
+	if err := rlp.DecodeBytes(fields[headerParentHashIdx], &h.ParentHash); err != nil {
+		return nil, fmt.Errorf("cannot decode parent hash: %w", err)
+	}
+	if err := rlp.DecodeBytes(fields[headerRootIdx], &h.Root); err != nil {
+		return nil, fmt.Errorf("cannot decode state root: %w", err)
+	}
+	if err := rlp.DecodeBytes(fields[headerNumberIdx], h.Number); err != nil {
+		return nil, fmt.Errorf("cannot decode number: %w", err)
+	}
+	if err := rlp.DecodeBytes(fields[headerTimeIdx], &h.Time); err != nil {
+		return nil, fmt.Errorf("cannot decode time: %w", err)
+	}
+	return h, nil
+}
+
+// Hash returns the block hash, the keccak256 hash of the RLP encoded header.
+func (h *BlockHeader) Hash() common.Hash {
+	return crypto.Keccak256Hash(h.raw)
+}
+
+// RLP returns the RLP encoding of the header.
+func (h *BlockHeader) RLP() []byte {
+	return common.CopyBytes(h.raw)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (h *BlockHeader) MarshalText() ([]byte, error) {
+	return hexutil.Bytes(h.raw).MarshalText()
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (h *BlockHeader) UnmarshalText(input []byte) error {
+	var raw hexutil.Bytes
+	if err := raw.UnmarshalText(input); err != nil {
+		return err
+	}
+	dh, err := DecodeBlockHeader(raw)
+	if err != nil {
+		return err
+	}
+	*h = *dh
+	return nil
+}
+
+// jsonHeader is a block header as returned by eth_getBlockByNumber and
+// eth_getBlockByHash.
+type jsonHeader struct {
+	Hash             common.Hash      `json:"hash"`
+	ParentHash       common.Hash      `json:"parentHash"`
+	UncleHash        common.Hash      `json:"sha3Uncles"`
+	Coinbase         common.Address   `json:"miner"`
+	Root             common.Hash      `json:"stateRoot"`
+	TxHash           common.Hash      `json:"transactionsRoot"`
+	ReceiptHash      common.Hash      `json:"receiptsRoot"`
+	Bloom            types.Bloom      `json:"logsBloom"`
+	Difficulty       *hexutil.Big     `json:"difficulty"`
+	Number           *hexutil.Big     `json:"number"`
+	GasLimit         hexutil.Uint64   `json:"gasLimit"`
+	GasUsed          hexutil.Uint64   `json:"gasUsed"`
+	Time             hexutil.Uint64   `json:"timestamp"`
+	Extra            hexutil.Bytes    `json:"extraData"`
+	MixDigest        common.Hash      `json:"mixHash"`
+	Nonce            types.BlockNonce `json:"nonce"`
+	BaseFee          *hexutil.Big     `json:"baseFeePerGas"`
+	WithdrawalsHash  *common.Hash     `json:"withdrawalsRoot"`
+	BlobGasUsed      *hexutil.Uint64  `json:"blobGasUsed"`
+	ExcessBlobGas    *hexutil.Uint64  `json:"excessBlobGas"`
+	ParentBeaconRoot *common.Hash     `json:"parentBeaconBlockRoot"`
+	RequestsHash     *common.Hash     `json:"requestsHash"`
+}
+
+// rlpHeader is the consensus encoding of a block header, including the
+// fields of forks not yet known by go-ethereum types.Header.
+type rlpHeader struct {
+	ParentHash       common.Hash
+	UncleHash        common.Hash
+	Coinbase         common.Address
+	Root             common.Hash
+	TxHash           common.Hash
+	ReceiptHash      common.Hash
+	Bloom            types.Bloom
+	Difficulty       *big.Int
+	Number           *big.Int
+	GasLimit         uint64
+	GasUsed          uint64
+	Time             uint64
+	Extra            []byte
+	MixDigest        common.Hash
+	Nonce            types.BlockNonce
+	BaseFee          *big.Int     `rlp:"optional"`
+	WithdrawalsHash  *common.Hash `rlp:"optional"`
+	BlobGasUsed      *uint64      `rlp:"optional"`
+	ExcessBlobGas    *uint64      `rlp:"optional"`
+	ParentBeaconRoot *common.Hash `rlp:"optional"`
+	RequestsHash     *common.Hash `rlp:"optional"`
+}
+
+// HeaderFromJSON builds a BlockHeader from the JSON object returned by the
+// eth_getBlockByNumber or eth_getBlockByHash web3 methods.  The recomputed
+// block hash must match the `hash` field of the object.
+func HeaderFromJSON(data []byte) (*BlockHeader, error) {
+	var jh jsonHeader
+	if err := json.Unmarshal(data, &jh); err != nil {
+		return nil, err
+	}
+	if jh.Difficulty == nil || jh.Number == nil {
+		return nil, fmt.Errorf("header is missing required fields")
+	}
+	rh := rlpHeader{
+		ParentHash:       jh.ParentHash,
+		UncleHash:        jh.UncleHash,
+		Coinbase:         jh.Coinbase,
+		Root:             jh.Root,
+		TxHash:           jh.TxHash,
+		ReceiptHash:      jh.ReceiptHash,
+		Bloom:            jh.Bloom,
+		Difficulty:       jh.Difficulty.ToInt(),
+		Number:           jh.Number.ToInt(),
+		GasLimit:         uint64(jh.GasLimit),
+		GasUsed:          uint64(jh.GasUsed),
+		Time:             uint64(jh.Time),
+		Extra:            jh.Extra,
+		MixDigest:        jh.MixDigest,
+		Nonce:            jh.Nonce,
+		WithdrawalsHash:  jh.WithdrawalsHash,
+		ParentBeaconRoot: jh.ParentBeaconRoot,
+		RequestsHash:     jh.RequestsHash,
+	}
+	if jh.BaseFee != nil {
+		rh.BaseFee = jh.BaseFee.ToInt()
+	}
+	if jh.BlobGasUsed != nil {
+		rh.BlobGasUsed = (*uint64)(jh.BlobGasUsed)
+	}
+	if jh.ExcessBlobGas != nil {
+		rh.ExcessBlobGas = (*uint64)(jh.ExcessBlobGas)
+	}
+	raw, err := rlp.EncodeToBytes(&rh)
+	if err != nil {
+		return nil, err
+	}
+	h, err := DecodeBlockHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+	if h.Hash() != jh.Hash {
+		return nil, fmt.Errorf("header hash mismatch (%x != %x)", h.Hash(), jh.Hash)
+	}
+	return h, nil
+}
+
+// VerifyBlockHeader verifies the Header of the proof hashes to blockHash and
+// contains the StateRoot (and Height and BlockHash, if set) of the proof.
+func VerifyBlockHeader(proof *StorageProof, blockHash common.Hash) error {
+	if proof.Header == nil {
+		return fmt.Errorf("proof has no block header")
+	}
+	if h := proof.Header.Hash(); h != blockHash {
+		return fmt.Errorf("%w: block hash (%x != %x)", ErrRootMismatch, h, blockHash)
+	}
+	if proof.BlockHash != (common.Hash{}) && proof.BlockHash != blockHash {
+		return fmt.Errorf("%w: proof block hash (%x != %x)",
+			ErrRootMismatch, proof.BlockHash, blockHash)
+	}
+	if proof.Header.Root != proof.StateRoot {
+		return fmt.Errorf("%w: state root (%x != %x)",
+			ErrRootMismatch, proof.Header.Root, proof.StateRoot)
+	}
+	if proof.Height != nil && proof.Header.Number.Cmp(proof.Height) != 0 {
+		return fmt.Errorf("%w: height (%v != %v)",
+			ErrValueMismatch, proof.Header.Number, proof.Height)
+	}
+	return nil
+}
+
+// VerifyEIP1186WithBlockHash verifies the whole Ethereum proof obtained with
+// eth_getProof against a trusted block hash instead of a trusted StateRoot.
+// The block Header included in the proof is checked against blockHash and its
+// state root is then used to verify the proof as in VerifyEIP1186.
+func VerifyEIP1186WithBlockHash(proof *StorageProof, blockHash common.Hash) (bool, error) {
+	if err := VerifyBlockHeader(proof, blockHash); err != nil {
+		return false, &ProofError{Layer: LayerHeader, Err: err}
+	}
+	return VerifyEIP1186(proof)
+}
+
+// VerifyHeaderChain verifies that headers are a chain of consecutive blocks
+// linked by their parent hash and ending at the block with hash trustedHash.
+// headers must be sorted by ascending block number, the first one being the
+// block to prove and the last one the trusted checkpoint.  Returns the first
+// header, which can be trusted if no error is returned.
+func VerifyHeaderChain(headers []*BlockHeader, trustedHash common.Hash) (*BlockHeader, error) {
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("empty header chain")
+	}
+	last := headers[len(headers)-1]
+	if h := last.Hash(); h != trustedHash {
+		return nil, fmt.Errorf("%w: checkpoint hash (%x != %x)", ErrRootMismatch, h, trustedHash)
+	}
+	for i := len(headers) - 1; i > 0; i-- {
+		child, parent := headers[i], headers[i-1]
+		if child.ParentHash != parent.Hash() {
+			return nil, fmt.Errorf("%w: header %d is not the parent of header %d",
+				ErrRootMismatch, i-1, i)
+		}
+		if new(big.Int).Sub(child.Number, parent.Number).Cmp(big.NewInt(1)) != 0 {
+			return nil, fmt.Errorf("header %d number %v does not follow %v",
+				i, child.Number, parent.Number)
+		}
+	}
+	return headers[0], nil
+}
+
+// VerifyEIP1186WithHeaderChain verifies the whole Ethereum proof obtained
+// with eth_getProof against a trusted block hash of a later block.  The
+// headers link the block of the proof to the trusted block (see
+// VerifyHeaderChain), the first one must match the Height and StateRoot of
+// the proof, which is then verified as in VerifyEIP1186.
+func VerifyEIP1186WithHeaderChain(proof *StorageProof, headers []*BlockHeader,
+	trustedHash common.Hash) (bool, error) {
+	header, err := VerifyHeaderChain(headers, trustedHash)
+	if err != nil {
+		return false, &ProofError{Layer: LayerHeader, Err: err}
+	}
+	if proof.Height == nil {
+		return false, &ProofError{Layer: LayerHeader, Err: fmt.Errorf("proof has no height")}
+	}
+	if proof.Header != nil && proof.Header.Hash() != header.Hash() {
+		return false, &ProofError{Layer: LayerHeader, Err: fmt.Errorf(
+			"%w: proof header is not the first header of the chain", ErrRootMismatch)}
+	}
+	tmp := *proof
+	tmp.Header = header
+	return VerifyEIP1186WithBlockHash(&tmp, header.Hash())
+}
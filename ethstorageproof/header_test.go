@@ -0,0 +1,203 @@
+package ethstorageproof
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	qt "github.com/frankban/quicktest"
+)
+
+func testHeader(fork HeaderFork, root common.Hash, number int64) *types.Header {
+	h := &types.Header{
+		ParentHash: crypto.Keccak256Hash([]byte("parent")),
+		Root:       root,
+		Difficulty: big.NewInt(0),
+		Number:     big.NewInt(number),
+		GasLimit:   30_000_000,
+		Time:       1700000000,
+		Extra:      []byte("vocdoni"),
+	}
+	if fork >= ForkLondon {
+		h.BaseFee = big.NewInt(7)
+	}
+	if fork >= ForkShanghai {
+		h.WithdrawalsHash = &types.EmptyWithdrawalsHash
+	}
+	if fork >= ForkCancun {
+		zero := uint64(0)
+		h.BlobGasUsed = &zero
+		h.ExcessBlobGas = &zero
+		h.ParentBeaconRoot = &common.Hash{}
+	}
+	return h
+}
+
+func TestBlockHeader(t *testing.T) {
+	c := qt.New(t)
+	root := crypto.Keccak256Hash([]byte("root"))
+	for _, fork := range []HeaderFork{ForkLegacy, ForkLondon, ForkShanghai, ForkCancun} {
+		gh := testHeader(fork, root, 100)
+		raw, err := rlp.EncodeToBytes(gh)
+		c.Assert(err, qt.IsNil)
+
+		h, err := DecodeBlockHeader(raw)
+		c.Assert(err, qt.IsNil)
+		c.Check(h.Fork, qt.Equals, fork)
+		c.Check(h.Hash(), qt.Equals, gh.Hash())
+		c.Check(h.Root, qt.Equals, root)
+		c.Check(h.ParentHash, qt.Equals, gh.ParentHash)
+		c.Check(h.Number.Int64(), qt.Equals, int64(100))
+		c.Check(h.Time, qt.Equals, gh.Time)
+
+		// The header as returned by the web3 API must encode to the same hash
+		data, err := json.Marshal(gh)
+		c.Assert(err, qt.IsNil)
+		jh, err := HeaderFromJSON(data)
+		c.Assert(err, qt.IsNil)
+		c.Check(jh.Hash(), qt.Equals, gh.Hash())
+		c.Check(jh.RLP(), qt.DeepEquals, raw)
+	}
+
+	// Prague headers are not known by go-ethereum types.Header
+	requestsHash := crypto.Keccak256Hash([]byte("requests"))
+	gh := testHeader(ForkCancun, root, 100)
+	rh := rlpHeader{
+		ParentHash:       gh.ParentHash,
+		UncleHash:        gh.UncleHash,
+		Root:             gh.Root,
+		Difficulty:       gh.Difficulty,
+		Number:           gh.Number,
+		GasLimit:         gh.GasLimit,
+		Time:             gh.Time,
+		Extra:            gh.Extra,
+		BaseFee:          gh.BaseFee,
+		WithdrawalsHash:  gh.WithdrawalsHash,
+		BlobGasUsed:      gh.BlobGasUsed,
+		ExcessBlobGas:    gh.ExcessBlobGas,
+		ParentBeaconRoot: gh.ParentBeaconRoot,
+		RequestsHash:     &requestsHash,
+	}
+	raw, err := rlp.EncodeToBytes(&rh)
+	c.Assert(err, qt.IsNil)
+	h, err := DecodeBlockHeader(raw)
+	c.Assert(err, qt.IsNil)
+	c.Check(h.Fork, qt.Equals, ForkPrague)
+	c.Check(h.Hash(), qt.Equals, crypto.Keccak256Hash(raw))
+
+	data, err := json.Marshal(gh)
+	c.Assert(err, qt.IsNil)
+	var fields map[string]interface{}
+	c.Assert(json.Unmarshal(data, &fields), qt.IsNil)
+	fields["requestsHash"] = requestsHash
+	data, err = json.Marshal(fields)
+	c.Assert(err, qt.IsNil)
+	// the hash returned by the node would include requestsHash
+	_, err = HeaderFromJSON(data)
+	c.Assert(err, qt.ErrorMatches, "header hash mismatch.*")
+	fields["hash"] = h.Hash()
+	data, err = json.Marshal(fields)
+	c.Assert(err, qt.IsNil)
+	jh, err := HeaderFromJSON(data)
+	c.Assert(err, qt.IsNil)
+	c.Check(jh.Fork, qt.Equals, ForkPrague)
+	c.Check(jh.Hash(), qt.Equals, h.Hash())
+
+	// JSON round trip
+	data, err = json.Marshal(h)
+	c.Assert(err, qt.IsNil)
+	var h2 BlockHeader
+	c.Assert(json.Unmarshal(data, &h2), qt.IsNil)
+	c.Check(h2.Hash(), qt.Equals, h.Hash())
+	c.Check(h2.Fork, qt.Equals, ForkPrague)
+}
+
+func TestVerifyEIP1186WithBlockHash(t *testing.T) {
+	c := qt.New(t)
+	var sp StorageProof
+	c.Assert(json.Unmarshal([]byte(EIP1186Proof), &sp), qt.IsNil)
+
+	gh := testHeader(ForkLondon, sp.StateRoot, sp.Height.Int64())
+	raw, err := rlp.EncodeToBytes(gh)
+	c.Assert(err, qt.IsNil)
+	sp.Header, err = DecodeBlockHeader(raw)
+	c.Assert(err, qt.IsNil)
+
+	ok, err := VerifyEIP1186WithBlockHash(&sp, gh.Hash())
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+
+	// The block hash recorded in the proof must be the trusted one
+	sp.BlockHash = gh.Hash()
+	c.Assert(VerifyBlockHeader(&sp, gh.Hash()), qt.IsNil)
+	sp.BlockHash = gh.ParentHash
+	checkErrorIs(c, VerifyBlockHeader(&sp, gh.Hash()), ErrRootMismatch)
+	sp.BlockHash = common.Hash{}
+
+	var perr *ProofError
+	ok, err = VerifyEIP1186WithBlockHash(&sp, common.Hash{})
+	c.Assert(ok, qt.IsFalse)
+	c.Assert(errors.As(err, &perr), qt.IsTrue)
+	c.Assert(perr.Layer, qt.Equals, LayerHeader)
+
+	// The state root of the proof must be the one committed in the header
+	sp.StateRoot = common.Hash{}
+	ok, err = VerifyEIP1186WithBlockHash(&sp, gh.Hash())
+	c.Assert(ok, qt.IsFalse)
+	c.Assert(errors.As(err, &perr), qt.IsTrue)
+	c.Assert(perr.Layer, qt.Equals, LayerHeader)
+}
+
+func TestVerifyEIP1186WithHeaderChain(t *testing.T) {
+	c := qt.New(t)
+	var sp StorageProof
+	c.Assert(json.Unmarshal([]byte(EIP1186Proof), &sp), qt.IsNil)
+
+	// Build a chain of headers from the proof block up to a checkpoint
+	var headers []*BlockHeader
+	parent := common.Hash{}
+	for i := int64(0); i < 10; i++ {
+		gh := testHeader(ForkLondon, crypto.Keccak256Hash(big.NewInt(i).Bytes()),
+			sp.Height.Int64()+i)
+		if i == 0 {
+			gh.Root = sp.StateRoot
+		} else {
+			gh.ParentHash = parent
+		}
+		raw, err := rlp.EncodeToBytes(gh)
+		c.Assert(err, qt.IsNil)
+		h, err := DecodeBlockHeader(raw)
+		c.Assert(err, qt.IsNil)
+		headers = append(headers, h)
+		parent = h.Hash()
+	}
+	checkpoint := parent
+
+	first, err := VerifyHeaderChain(headers, checkpoint)
+	c.Assert(err, qt.IsNil)
+	c.Assert(first.Root, qt.Equals, sp.StateRoot)
+
+	ok, err := VerifyEIP1186WithHeaderChain(&sp, headers, checkpoint)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+
+	// Unknown checkpoint
+	_, err = VerifyEIP1186WithHeaderChain(&sp, headers, headers[8].Hash())
+	checkErrorIs(c, err, ErrRootMismatch)
+
+	// Broken chain
+	broken := append([]*BlockHeader{}, headers[:4]...)
+	broken = append(broken, headers[5:]...)
+	_, err = VerifyHeaderChain(broken, checkpoint)
+	checkErrorIs(c, err, ErrRootMismatch)
+
+	// The height of the proof must match the first header
+	sp.Height = new(big.Int).Add(sp.Height, big.NewInt(1))
+	_, err = VerifyEIP1186WithHeaderChain(&sp, headers, checkpoint)
+	checkErrorIs(c, err, ErrValueMismatch)
+}
@@ -0,0 +1,95 @@
+package ethstorageproof
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+var (
+	_ ethdb.KeyValueReader = (*MemDB)(nil)
+	_ ethdb.KeyValueWriter = (*MemDB)(nil)
+)
+
+// MemDB is an ethdb.KeyValueReader and ethdb.KeyValueWriter implementation
+// which assumes that all keys are common.Hash.  It is safe for concurrent
+// use, so a proof database can be shared by several goroutines verifying
+// different keys against the same set of nodes.
+type MemDB struct {
+	mu  sync.RWMutex
+	kvs map[common.Hash][]byte
+}
+
+// NewMemDB creates a new empty MemDB
+func NewMemDB() *MemDB {
+	return &MemDB{
+		kvs: make(map[common.Hash][]byte),
+	}
+}
+
+// NewProofDB creates a MemDB with all the nodes of the proofs, indexed by
+// their hash.  Nodes repeated across proofs are stored once.
+func NewProofDB(proofs ...[][]byte) *MemDB {
+	proofDB := NewMemDB()
+	seen := make(map[string]bool)
+	for _, proof := range proofs {
+		for _, node := range proof {
+			if seen[string(node)] {
+				continue
+			}
+			seen[string(node)] = true
+			proofDB.kvs[crypto.Keccak256Hash(node)] = node
+		}
+	}
+	return proofDB
+}
+
+// Has returns true if the MemBD contains the key
+func (m *MemDB) Has(key []byte) (bool, error) {
+	h := common.BytesToHash(key)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.kvs[h]
+	return ok, nil
+}
+
+// Get returns the value of the key.  If the key is not found, the returned
+// error wraps ErrMissingNode.
+func (m *MemDB) Get(key []byte) ([]byte, error) {
+	h := common.BytesToHash(key)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.kvs[h]
+	if !ok {
+		return nil, fmt.Errorf("%w: key %x not found", ErrMissingNode, key)
+	}
+	return value, nil
+}
+
+// Put sets or updates the value at key
+func (m *MemDB) Put(key []byte, value []byte) error {
+	h := common.BytesToHash(key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kvs[h] = value
+	return nil
+}
+
+// Delete removes the key.  Deleting a key that is not found is not an error.
+func (m *MemDB) Delete(key []byte) error {
+	h := common.BytesToHash(key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.kvs, h)
+	return nil
+}
+
+// Len returns the number of keys stored
+func (m *MemDB) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.kvs)
+}
@@ -0,0 +1,69 @@
+package ethstorageproof
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestMemDB(t *testing.T) {
+	c := qt.New(t)
+	db := NewMemDB()
+	key := common.HexToHash("0x01").Bytes()
+	c.Assert(db.Put(key, []byte("value")), qt.IsNil)
+	ok, err := db.Has(key)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+	value, err := db.Get(key)
+	c.Assert(err, qt.IsNil)
+	c.Assert(value, qt.DeepEquals, []byte("value"))
+
+	c.Assert(db.Delete(key), qt.IsNil)
+	ok, err = db.Has(key)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsFalse)
+	_, err = db.Get(key)
+	checkErrorIs(c, err, ErrMissingNode)
+	c.Assert(db.Delete(key), qt.IsNil)
+	c.Assert(db.Len(), qt.Equals, 0)
+}
+
+func TestMemDBConcurrentVerify(t *testing.T) {
+	c := qt.New(t)
+	slots := make(map[common.Hash]*big.Int)
+	for i := int64(1); i <= 128; i++ {
+		slots[common.BigToHash(big.NewInt(i))] = big.NewInt(i)
+	}
+	root, prove := testStorageTrie(c, slots)
+	var proofs [][][]byte
+	for i := int64(1); i <= 128; i++ {
+		proofs = append(proofs, prove(common.BigToHash(big.NewInt(i))).Proof)
+	}
+	proofDB := NewProofDB(proofs...)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(slots))
+	for k, v := range slots {
+		wg.Add(1)
+		go func(key common.Hash, v *big.Int) {
+			defer wg.Done()
+			value, err := rlp.EncodeToBytes(v.Bytes())
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := VerifyProofDB(proofDB, root, key[:], value); err != nil {
+				errs <- err
+			}
+		}(k, v)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Error(err)
+	}
+}
@@ -0,0 +1,62 @@
+package ethstorageproof
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// MultiProof proves a set of storage keys and values against a single storage
+// root.  The trie nodes of all the proofs are deduplicated, so the nodes
+// shared by the keys (usually all the upper levels of the trie) are stored
+// and hashed only once.
+type MultiProof struct {
+	Root   common.Hash     `json:"root"`
+	Keys   SliceData       `json:"keys"`
+	Values []QuantityBytes `json:"values"`
+	Nodes  SliceData       `json:"nodes"`
+}
+
+// NewMultiProof builds a MultiProof from a list of storage proofs against the
+// same storage root, such as the StorageProof list of an EIP1186 proof.
+func NewMultiProof(root common.Hash, proofs []StorageResult) *MultiProof {
+	mp := &MultiProof{
+		Root:   root,
+		Keys:   make(SliceData, len(proofs)),
+		Values: make([]QuantityBytes, len(proofs)),
+	}
+	seen := make(map[common.Hash]bool)
+	for i, p := range proofs {
+		mp.Keys[i] = p.Key
+		mp.Values[i] = p.Value
+		for _, node := range p.Proof {
+			h := crypto.Keccak256Hash(node)
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			mp.Nodes = append(mp.Nodes, node)
+		}
+	}
+	return mp
+}
+
+// VerifyMultiProof verifies every key of the MultiProof holds its value on
+// the storage trie with root mp.Root.  All nodes are loaded into a single
+// proof database before walking the path of each key.  An empty value is
+// verified as a proof of non existence.  On failure the returned error is a
+// *ProofError with the index of the first key that failed.
+func VerifyMultiProof(mp *MultiProof) error {
+	if len(mp.Keys) != len(mp.Values) {
+		return fmt.Errorf("keys and values length mismatch (%d != %d)",
+			len(mp.Keys), len(mp.Values))
+	}
+	proofDB := NewProofDB(mp.Nodes)
+	for i := range mp.Keys {
+		if err := verifyStorageValue(proofDB, mp.Root, mp.Keys[i], mp.Values[i]); err != nil {
+			return &ProofError{Layer: LayerStorage, Index: i, Err: err}
+		}
+	}
+	return nil
+}
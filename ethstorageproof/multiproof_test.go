@@ -0,0 +1,47 @@
+package ethstorageproof
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestMultiProof(t *testing.T) {
+	c := qt.New(t)
+	slots := make(map[common.Hash]*big.Int)
+	for i := int64(1); i <= 256; i++ {
+		slots[common.BigToHash(big.NewInt(i))] = big.NewInt(i)
+	}
+	root, prove := testStorageTrie(c, slots)
+
+	var proofs []StorageResult
+	nodes := 0
+	// include some non existing keys
+	for i := int64(1); i <= 300; i += 3 {
+		sp := prove(common.BigToHash(big.NewInt(i)))
+		nodes += len(sp.Proof)
+		proofs = append(proofs, *sp)
+	}
+	mp := NewMultiProof(root, proofs)
+	c.Assert(VerifyMultiProof(mp), qt.IsNil)
+	c.Assert(len(mp.Nodes) < nodes, qt.IsTrue)
+
+	// JSON round trip
+	data, err := json.Marshal(mp)
+	c.Assert(err, qt.IsNil)
+	var mp2 MultiProof
+	c.Assert(json.Unmarshal(data, &mp2), qt.IsNil)
+	c.Assert(VerifyMultiProof(&mp2), qt.IsNil)
+
+	// A wrong value must be reported with its index
+	mp2.Values[5] = big.NewInt(1000).Bytes()
+	err = VerifyMultiProof(&mp2)
+	var perr *ProofError
+	c.Assert(errors.As(err, &perr), qt.IsTrue)
+	c.Assert(perr.Index, qt.Equals, 5)
+	checkErrorIs(c, err, ErrValueMismatch)
+}
@@ -0,0 +1,142 @@
+package ethstorageproof
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Proxy kinds, identifying the standard slot a proxy stores its
+// implementation (or beacon) address at.
+const (
+	// ProxyEIP1967 is an EIP-1967 proxy, such as the OpenZeppelin
+	// transparent and UUPS proxies.
+	ProxyEIP1967 = "eip1967"
+	// ProxyEIP1967Beacon is an EIP-1967 beacon proxy, which stores the
+	// address of the beacon returning the implementation.
+	ProxyEIP1967Beacon = "eip1967-beacon"
+	// ProxyEIP1822 is an EIP-1822 universal upgradeable proxy.
+	ProxyEIP1822 = "eip1822"
+	// ProxyOpenZeppelinLegacy is a proxy of the legacy OpenZeppelin (zos)
+	// upgrades library.
+	ProxyOpenZeppelinLegacy = "openzeppelin-legacy"
+)
+
+// ProxySlots holds the storage slot of each proxy kind
+var ProxySlots = map[string]common.Hash{
+	// bytes32(uint256(keccak256("eip1967.proxy.implementation")) - 1)
+	ProxyEIP1967: common.HexToHash(
+		"0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc"),
+	// bytes32(uint256(keccak256("eip1967.proxy.beacon")) - 1)
+	ProxyEIP1967Beacon: common.HexToHash(
+		"0xa3f0ad74e5423aebfd80d3ef4346578335a9a72aeaee59ff6cb3582b35133d50"),
+	// keccak256("PROXIABLE")
+	ProxyEIP1822: common.HexToHash(
+		"0xc5f16f0fcc639fa48a6947836d9850f504798523bf8c9a3a87d5876cf622bcf7"),
+	// keccak256("org.zeppelinos.proxy.implementation")
+	ProxyOpenZeppelinLegacy: common.HexToHash(
+		"0x7050c9e0f4ca769c69bd3a8ef740bc37934f8e2c036e5a723fd8ee048ed3f8c3"),
+}
+
+// BeaconImplementationSlot is the storage slot of the implementation address
+// of a beacon, as the OpenZeppelin UpgradeableBeacon stores it after the
+// owner of Ownable
+var BeaconImplementationSlot = common.BigToHash(big.NewInt(1))
+
+// ProxyKinds lists the proxy kinds in the order they are detected
+var ProxyKinds = []string{
+	ProxyEIP1967,
+	ProxyEIP1967Beacon,
+	ProxyEIP1822,
+	ProxyOpenZeppelinLegacy,
+}
+
+// ImplementationProof proves the storage slot of a proxy contract holding the
+// address of its implementation contract, or of its beacon for beacon
+// proxies.
+type ImplementationProof struct {
+	// Kind is the proxy kind, which defines the storage slot
+	Kind string `json:"kind"`
+	// Implementation is the implementation contract address.  For beacon
+	// proxies it is the address held by the beacon, proven by BeaconProof.
+	Implementation common.Address `json:"implementation"`
+	// Beacon is the beacon contract address of beacon proxies
+	Beacon *common.Address `json:"beacon,omitempty"`
+	// Slot is the storage proof of the proxy slot
+	Slot StorageResult `json:"slot"`
+	// BeaconProof is the proof of the beacon account and its
+	// BeaconImplementationSlot, on the block of the proxy proof, for beacon
+	// proxies
+	BeaconProof *StorageProof `json:"beaconProof,omitempty"`
+}
+
+// ProvenAddress returns the address held by the proven slot: the beacon for
+// beacon proxies, otherwise the implementation.
+func (p *ImplementationProof) ProvenAddress() common.Address {
+	if p.Beacon != nil {
+		return *p.Beacon
+	}
+	return p.Implementation
+}
+
+// VerifyImplementationProof verifies the implementation proof against the
+// storage root of the proxy account: the key is the slot of the proxy kind
+// and it holds the proven address.  The beacon proof of beacon proxies is
+// verified against the state root of the block.
+func VerifyImplementationProof(p *ImplementationProof, stateRoot,
+	storageHash common.Hash) error {
+	slot, ok := ProxySlots[p.Kind]
+	if !ok {
+		return fmt.Errorf("unknown proxy kind %q", p.Kind)
+	}
+	if (p.Kind == ProxyEIP1967Beacon) != (p.Beacon != nil) ||
+		(p.Beacon != nil) != (p.BeaconProof != nil) {
+		return fmt.Errorf("beacon address and proof are only expected for beacon proxies")
+	}
+	if common.BytesToHash(p.Slot.Key) != slot || len(p.Slot.Key) > common.HashLength {
+		return fmt.Errorf("%w: key %x is not the %s slot", ErrBadKey, p.Slot.Key, p.Kind)
+	}
+	address := p.ProvenAddress()
+	if new(big.Int).SetBytes(p.Slot.Value).Cmp(new(big.Int).SetBytes(address[:])) != 0 {
+		return fmt.Errorf("%w: slot holds %x instead of %s", ErrValueMismatch,
+			p.Slot.Value, address.Hex())
+	}
+	if _, err := VerifyEthStorageProof(&p.Slot, storageHash); err != nil {
+		return err
+	}
+	if p.BeaconProof != nil {
+		return verifyBeaconProof(p.BeaconProof, *p.Beacon, p.Implementation, stateRoot)
+	}
+	return nil
+}
+
+// verifyBeaconProof verifies that the proof of beacon against stateRoot
+// proves its BeaconImplementationSlot holds implementation
+func verifyBeaconProof(proof *StorageProof, beacon, implementation common.Address,
+	stateRoot common.Hash) error {
+	if proof.Address != beacon || proof.StateRoot != stateRoot {
+		return fmt.Errorf("%w: beacon proof is not of %s on the proven block",
+			ErrRootMismatch, beacon.Hex())
+	}
+	if len(proof.StorageProof) != 1 {
+		return fmt.Errorf("invalid length of beacon proofs %d", len(proof.StorageProof))
+	}
+	sr := proof.StorageProof[0]
+	if common.BytesToHash(sr.Key) != BeaconImplementationSlot ||
+		len(sr.Key) > common.HashLength {
+		return fmt.Errorf("%w: key %x is not the beacon implementation slot", ErrBadKey, sr.Key)
+	}
+	if new(big.Int).SetBytes(sr.Value).Cmp(new(big.Int).SetBytes(implementation[:])) != 0 {
+		return fmt.Errorf("%w: beacon slot holds %x instead of %s", ErrValueMismatch,
+			sr.Value, implementation.Hex())
+	}
+	storageRoot, err := verifyAccount(proof)
+	if err != nil {
+		return fmt.Errorf("beacon account: %w", err)
+	}
+	if _, err := VerifyEthStorageProof(&sr, storageRoot); err != nil {
+		return fmt.Errorf("beacon slot: %w", err)
+	}
+	return nil
+}
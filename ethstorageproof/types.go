@@ -94,6 +94,12 @@ func (s *SliceData) UnmarshalJSON(data []byte) error {
 // NOTE: QUANTITY is supposed to follow this spec:
 // https://infura.io/docs/ethereum#section/Value-encoding/Quantity but
 // go-ethereum sometimes gives the string without the `0x` prefix
+//
+// Height, StateRoot, BlockHash and Header are not part of the `eth_getProof`
+// response, they are filled with the block the proof was obtained for.  All
+// the reads used to build a proof are done against the block with BlockHash.
+// Implementation is set when the account is a proxy contract, proving the
+// slot holding its implementation address.
 type StorageProof struct {
 	Height       *big.Int        `json:"height"`
 	Address      common.Address  `json:"address"`
@@ -104,6 +110,10 @@ type StorageProof struct {
 	StorageHash  common.Hash     `json:"storageHash"`
 	AccountProof SliceData       `json:"accountProof"`
 	StorageProof []StorageResult `json:"storageProof"`
+	BlockHash    common.Hash     `json:"blockHash"`
+	Header       *BlockHeader    `json:"header,omitempty"`
+	// Implementation proves the implementation of a proxy contract
+	Implementation *ImplementationProof `json:"implementation,omitempty"`
 }
 
 // StorageResult is an object from StorageProof that contains a proof of
@@ -113,39 +123,3 @@ type StorageResult struct {
 	Value QuantityBytes `json:"value"`
 	Proof SliceData     `json:"proof"`
 }
-
-// MemDB is an ethdb.KeyValueReader implementation which is not thread safe and
-// assumes that all keys are common.Hash.
-type MemDB struct {
-	kvs map[common.Hash][]byte
-}
-
-// NewMemDB creates a new empty MemDB
-func NewMemDB() *MemDB {
-	return &MemDB{
-		kvs: make(map[common.Hash][]byte),
-	}
-}
-
-// Has returns true if the MemBD contains the key
-func (m *MemDB) Has(key []byte) (bool, error) {
-	h := common.BytesToHash(key)
-	_, ok := m.kvs[h]
-	return ok, nil
-}
-
-// Get returns the value of the key, or nil if it's not found
-func (m *MemDB) Get(key []byte) ([]byte, error) {
-	h := common.BytesToHash(key)
-	value, ok := m.kvs[h]
-	if !ok {
-		return nil, fmt.Errorf("key not found")
-	}
-	return value, nil
-}
-
-// Put sets or updates the value at key
-func (m *MemDB) Put(key []byte, value []byte) {
-	h := common.BytesToHash(key)
-	m.kvs[h] = value
-}
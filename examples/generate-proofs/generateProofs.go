@@ -13,9 +13,9 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
 	"github.com/vocdoni/storage-proofs-eth-go/token"
 )
 
@@ -25,6 +25,7 @@ func main() {
 	web3 := flag.String("web3", "https://web3.dappnode.net", "web3 RPC endpoint URL")
 	contract := flag.String("contract", "", "ERC20 contract address")
 	holderFile := flag.String("holderFile", "", "text file with holder addresses (separated by line)")
+	bundle := flag.Bool("bundle", false, "write the proofs as a bundle with deduplicated nodes")
 	flag.Parse()
 	var contractAddr common.Address
 	if err := contractAddr.UnmarshalText([]byte(*contract)); err != nil {
@@ -40,14 +41,18 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	getProofs(ctx, rpcCli, contractAddr, strings.Split(string(data), "\n"))
+	getProofs(ctx, rpcCli, contractAddr, strings.Split(string(data), "\n"), *bundle)
 }
 
 type EthProofs struct {
 	BlockNum      *big.Int      `json:"height"`
 	IndexSlot     int           `json:"indexSlot"`
 	StorageRoot   string        `json:"storageRoot"`
-	StorageProofs []HolderProof `json:"storageProofs"`
+	StorageProofs []HolderProof `json:"storageProofs,omitempty"`
+	// Holders and Bundle replace StorageProofs when writing a bundle, the
+	// bundle entries follow the order of Holders.
+	Holders []string                     `json:"holders,omitempty"`
+	Bundle  *ethstorageproof.ProofBundle `json:"bundle,omitempty"`
 }
 
 type HolderProof struct {
@@ -55,28 +60,28 @@ type HolderProof struct {
 	StorageProof ethstorageproof.StorageResult `json:"storageProof"`
 }
 
-func getProofs(ctx context.Context, rpcCli *rpc.Client, contract common.Address, holders []string) {
-	t, err := token.New(ctx, rpcCli, token.TokenTypeMapbased, contract)
+func getProofs(ctx context.Context, rpcCli *rpc.Client, contract common.Address,
+	holders []string, bundle bool) {
+	t, err := token.New(ctx, source.NewRPC(rpcCli), token.TokenTypeMapbased, contract)
 	if err != nil {
 		log.Fatal(err)
 	}
-	slot, _, err := t.DiscoverSlot(ctx, common.HexToAddress(holders[0]))
+	slot, _, err := t.DiscoverSlot(ctx, common.HexToAddress(holders[0]), source.Finalized)
 	if err != nil {
 		log.Fatal(err)
 	}
 	proofs := EthProofs{}
 	proofs.IndexSlot = slot
 
-	sproof, err := t.GetProof(ctx, common.HexToAddress(holders[0]), nil, slot)
+	// The census is taken at the finalized block, all the proofs are then
+	// requested for the same block hash
+	sproof, err := t.GetProof(ctx, common.HexToAddress(holders[0]), source.Finalized, slot)
 	if err != nil {
 		log.Fatalf("Error fetching storageRoot: %v", err)
 	}
 	proofs.StorageRoot = sproof.StorageHash.Hex()
-	blockNumUint64, err := ethclient.NewClient(rpcCli).BlockNumber(ctx)
-	if err != nil {
-		log.Fatal(err)
-	}
-	proofs.BlockNum = new(big.Int).SetUint64(blockNumUint64)
+	proofs.BlockNum = sproof.Height
+	ref := source.BlockHash(sproof.BlockHash, true)
 	wg := sync.WaitGroup{}
 	lock := sync.RWMutex{}
 	for _, h := range holders {
@@ -86,7 +91,7 @@ func getProofs(ctx context.Context, rpcCli *rpc.Client, contract common.Address,
 		wg.Add(1)
 		go func() {
 			holderAddr := common.HexToAddress(h)
-			sproof, err := t.GetProof(ctx, holderAddr, proofs.BlockNum, slot)
+			sproof, err := t.GetProof(ctx, holderAddr, ref, slot)
 			if err != nil {
 				log.Printf("error fetching %s: %v", holderAddr.Hex(), err)
 			} else {
@@ -104,6 +109,15 @@ func getProofs(ctx context.Context, rpcCli *rpc.Client, contract common.Address,
 		time.Sleep(time.Millisecond * 10)
 	}
 	wg.Wait()
+	if bundle {
+		results := make([]ethstorageproof.StorageResult, len(proofs.StorageProofs))
+		for i, hp := range proofs.StorageProofs {
+			proofs.Holders = append(proofs.Holders, hp.Address)
+			results[i] = hp.StorageProof
+		}
+		proofs.Bundle = ethstorageproof.NewProofBundle(results)
+		proofs.StorageProofs = nil
+	}
 	p, err := json.Marshal(proofs)
 	if err != nil {
 		log.Fatal(err)
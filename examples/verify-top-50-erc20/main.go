@@ -10,6 +10,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
 	"github.com/vocdoni/storage-proofs-eth-go/token"
 	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
 )
@@ -21,6 +22,8 @@ func main() {
 	contract := flag.String("contract", "", "ERC20 contract address")
 	holder := flag.String("holder", "", "address of the token holder")
 	contractType := flag.String("type", "mapbased", "ERC20 contract type (mapbased, minime)")
+	registryPath := flag.String("registry", "registry.json",
+		"JSON file of known token slots, updated after discovering a slot")
 	flag.Parse()
 	var contractAddr common.Address
 	if err := contractAddr.UnmarshalText([]byte(*contract)); err != nil {
@@ -37,7 +40,8 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	ts, err := erc20.New(ctx, rpcCli, contractAddr)
+	src := source.NewRPC(rpcCli)
+	ts, err := erc20.New(ctx, src, contractAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -59,32 +63,29 @@ func main() {
 		return
 	}
 
-	var ttype int
-	switch *contractType {
-	case "mapbased":
-		ttype = token.TokenTypeMapbased
-	case "minime":
-		ttype = token.TokenTypeMinime
-	default:
-		log.Fatalf("token type not supported %s", *contractType)
+	ttype, err := token.ParseTokenType(*contractType)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	t, err := token.New(ctx, rpcCli, ttype, contractAddr)
+	// The discovered slots are captured in the registry, so following runs
+	// do not need to discover them again
+	registry, err := token.LoadRegistry(*registryPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	slot, amount, err := t.DiscoverSlot(ctx, holderAddr)
+	t, entry, err := registry.Resolve(ctx, src, ttype, contractAddr, holderAddr, source.Latest)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("storage data -> slot: %d amount: %v", slot, amount)
+	slot := entry.Slot
+	log.Printf("storage data -> type: %s slot: %d", entry.Type, slot)
 
-	blockNumUint64, err := ts.EthCli.BlockNumber(ctx)
+	blockNumUint64, err := src.BlockNumber(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
 	blockNum := new(big.Int).SetUint64(blockNumUint64)
-	sproof, err := t.GetProof(ctx, holderAddr, blockNum, slot)
+	sproof, err := t.GetProof(ctx, holderAddr, source.BlockNumber(blockNum), slot)
 	if err != nil {
 		log.Fatalf("cannot get proof: %v", err)
 	}
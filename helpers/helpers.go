@@ -1,13 +1,112 @@
 package helpers
 
 import (
+	"errors"
+	"fmt"
 	"math/big"
+	"strconv"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
+// MapLayout is the order in which a compiler hashes the key and the index
+// slot of a map to compute the storage slot of a value.
+type MapLayout int
+
+const (
+	// LayoutSolidity hashes keccak256(key . slot), as Solidity does.
+	LayoutSolidity MapLayout = iota
+	// LayoutVyper hashes keccak256(slot . key), with the slot and the key
+	// left padded to 32 bytes, as the versions of Vyper supported by
+	// CompilerLayout do for HashMap storage variables.
+	LayoutVyper
+)
+
+// ErrUnknownLayout is returned by CompilerLayout for the compilers and the
+// versions which map layout is not known
+var ErrUnknownLayout = errors.New("unknown map layout")
+
+// CompilerLayout returns the map layout of the contracts compiled with
+// compiler ("solidity", "solc" or "vyper") at version, such as "0.8.21",
+// "v0.4.24+commit.e67f0147" or "0.2.0b1".
+//
+// All the Solidity versions hash the key before the index slot.  Vyper, from
+// 0.1.0 betas through 0.4.x, computes the slot of a HashMap value with
+// sha3_64(slot, key), which hashes the index slot before the key.  Later
+// Vyper versions return ErrUnknownLayout until their layout is checked.
+func CompilerLayout(compiler, version string) (MapLayout, error) {
+	major, minor, err := parseVersion(version)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s %s: %v", ErrUnknownLayout, compiler, version, err)
+	}
+	switch strings.ToLower(compiler) {
+	case "solidity", "solc":
+		return LayoutSolidity, nil
+	case "vyper":
+		if major == 0 && minor >= 1 && minor <= 4 {
+			return LayoutVyper, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s %s", ErrUnknownLayout, compiler, version)
+}
+
+// parseVersion returns the major and the minor numbers of a compiler version
+// such as "v0.4.24+commit.e67f0147" or "0.2.0b1".
+func parseVersion(version string) (int, int, error) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("invalid version")
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid major version: %w", err)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid minor version: %w", err)
+	}
+	return major, minor, nil
+}
+
+// MapLayouts lists the known map layouts
+var MapLayouts = []MapLayout{LayoutSolidity, LayoutVyper}
+
+// String implements fmt.Stringer
+func (l MapLayout) String() string {
+	switch l {
+	case LayoutSolidity:
+		return "solidity"
+	case LayoutVyper:
+		return "vyper"
+	default:
+		return fmt.Sprintf("layout(%d)", int(l))
+	}
+}
+
+// MapSlot returns the storage key slot for a holder on the map at index slot
+// position, following the layout.
+func (l MapLayout) MapSlot(holder common.Address, position int) [32]byte {
+	if l == LayoutVyper {
+		return GetVyperMapSlot(holder, position)
+	}
+	return GetMapSlot(holder, position)
+}
+
+// SlotIndex returns the index slot of the map for which key is the storage
+// key slot of holder following the layout, searching the index slots up to
+// maxIndex.  Returns -1 if key is not the slot of holder on any of them.
+func (l MapLayout) SlotIndex(holder common.Address, key common.Hash, maxIndex int) int {
+	for i := 0; i <= maxIndex; i++ {
+		if l.MapSlot(holder, i) == key {
+			return i
+		}
+	}
+	return -1
+}
+
 // GetMapSlot returns the storage key slot for a holder.
 // Position is the index slot (storage index of amount balances map).
 func GetMapSlot(holder common.Address, position int) [32]byte {
@@ -37,6 +136,44 @@ func BalanceToRat(b *big.Int, decimals int) *big.Rat {
 	)
 }
 
+// GetUintMapSlot returns the storage key slot for a uint256 key, such as a
+// token id, of a map.
+// Position is the index slot (storage index of the map).
+func GetUintMapSlot(key *big.Int, position int) [32]byte {
+	return crypto.Keccak256Hash(
+		common.LeftPadBytes(key.Bytes(), 32),
+		common.LeftPadBytes(big.NewInt(int64(position)).Bytes(), 32),
+	)
+}
+
+// GetNestedMapSlot returns the storage key slot for a holder on the inner map
+// of id, of a `uint256 => address => uint256` map, such as the balances of
+// an ERC1155 token.
+// Position is the index slot (storage index of the outer map).
+func GetNestedMapSlot(id *big.Int, holder common.Address, position int) [32]byte {
+	inner := GetUintMapSlot(id, position)
+	return crypto.Keccak256Hash(common.LeftPadBytes(holder[:], 32), inner[:])
+}
+
+// GetAddressNestedMapSlot returns the storage key slot for a uint key on the
+// inner map of holder, of an `address => uint => value` map, such as the
+// checkpoints of a COMP token.
+// Position is the index slot (storage index of the outer map).
+func GetAddressNestedMapSlot(holder common.Address, key *big.Int, position int) [32]byte {
+	inner := GetMapSlot(holder, position)
+	return crypto.Keccak256Hash(common.LeftPadBytes(key.Bytes(), 32), inner[:])
+}
+
+// GetVyperMapSlot returns the storage key slot for a holder on a map of a
+// Vyper contract, which hashes the index slot before the key.
+// Position is the index slot (storage index of amount balances map).
+func GetVyperMapSlot(holder common.Address, position int) [32]byte {
+	return crypto.Keccak256Hash(
+		common.LeftPadBytes(big.NewInt(int64(position)).Bytes(), 32),
+		common.LeftPadBytes(holder[:], 32),
+	)
+}
+
 func HashFromPosition(position [32]byte) [32]byte {
 	return crypto.Keccak256Hash(position[:])
 }
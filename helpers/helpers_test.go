@@ -1,7 +1,9 @@
 package helpers
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -48,6 +50,75 @@ func TestGetMapSlot(t *testing.T) {
 		"0x4a985c9a291a06b2854315c3a75ca2c1065ef62e859e2534b655d306748c16d4")
 }
 
+func TestGetUintMapSlot(t *testing.T) {
+	c := qt.New(t)
+
+	address := common.HexToAddress("0xbd9c69654b8f3e5978dfd138b00cb0be29f28ccf")
+	key := new(big.Int).SetBytes(address[:])
+	c.Check(GetUintMapSlot(key, 1), qt.Equals, GetMapSlot(address, 1))
+	c.Check(common.Hash(GetUintMapSlot(big.NewInt(1), 2)).Hex(), qt.Equals,
+		"0xe90b7bceb6e7df5418fb78d8ee546e97c83a08bbccc01a0644d599ccd2a7c2e0")
+}
+
+func TestGetNestedMapSlot(t *testing.T) {
+	c := qt.New(t)
+
+	address := common.HexToAddress("0xbd9c69654b8f3e5978dfd138b00cb0be29f28ccf")
+	c.Check(common.Hash(GetNestedMapSlot(big.NewInt(1), address, 2)).Hex(), qt.Equals,
+		"0x42dd59b32ccb76f5e491f300d2975b8b1ca6f76e22b8fb64395b38fbabda54a4")
+}
+
+func TestGetAddressNestedMapSlot(t *testing.T) {
+	c := qt.New(t)
+
+	address := common.HexToAddress("0xbd9c69654b8f3e5978dfd138b00cb0be29f28ccf")
+	c.Check(common.Hash(GetAddressNestedMapSlot(address, big.NewInt(1), 3)).Hex(), qt.Equals,
+		"0xe8358bbedd4152669b6d0519614705f4da87a2c7bdc677fb6a4c877c4921b4ee")
+}
+
+func TestGetVyperMapSlot(t *testing.T) {
+	c := qt.New(t)
+
+	address := common.HexToAddress("0xbd9c69654b8f3e5978dfd138b00cb0be29f28ccf")
+	mapSlot := GetVyperMapSlot(address, 1)
+	c.Check(common.Hash(mapSlot).Hex(), qt.Equals,
+		"0xc97ab4f6afd3fba2e822746e6a87baea10e8823db5b89f197609dfe37f8ae817")
+	c.Check(LayoutVyper.MapSlot(address, 1), qt.Equals, mapSlot)
+	c.Check(LayoutSolidity.MapSlot(address, 1), qt.Equals, GetMapSlot(address, 1))
+}
+
+func TestCompilerLayout(t *testing.T) {
+	c := qt.New(t)
+
+	for _, tc := range []struct {
+		compiler, version string
+		layout            MapLayout
+	}{
+		{"solidity", "0.4.24", LayoutSolidity},
+		{"solc", "v0.8.21+commit.d9974bed", LayoutSolidity},
+		{"Solidity", "0.5.0", LayoutSolidity},
+		{"vyper", "0.1.0b17", LayoutVyper},
+		{"vyper", "0.2.16", LayoutVyper},
+		{"vyper", "0.3.10", LayoutVyper},
+		{"vyper", "0.4.0rc6", LayoutVyper},
+	} {
+		layout, err := CompilerLayout(tc.compiler, tc.version)
+		c.Assert(err, qt.IsNil, qt.Commentf("%s %s", tc.compiler, tc.version))
+		c.Check(layout, qt.Equals, tc.layout, qt.Commentf("%s %s", tc.compiler, tc.version))
+	}
+	for _, tc := range []struct{ compiler, version string }{
+		{"vyper", "0.5.0"},
+		{"vyper", "1.0.0"},
+		{"vyper", "0.0.1"},
+		{"fe", "0.26.0"},
+		{"solidity", "latest"},
+	} {
+		_, err := CompilerLayout(tc.compiler, tc.version)
+		c.Check(errors.Is(err, ErrUnknownLayout), qt.IsTrue,
+			qt.Commentf("%s %s: %v", tc.compiler, tc.version, err))
+	}
+}
+
 func TestGetArraySlot(t *testing.T) {
 	c := qt.New(t)
 
@@ -55,3 +126,16 @@ func TestGetArraySlot(t *testing.T) {
 	c.Check(common.Hash(arraySlot).Hex(), qt.Equals,
 		"0xc2575a0e9e593c00f959f8c92f12db2869c3395a3b0502d05e2516446f71f85b")
 }
+
+func TestMapSlotIndex(t *testing.T) {
+	c := qt.New(t)
+
+	address := common.HexToAddress("0xbd9c69654b8f3e5978dfd138b00cb0be29f28ccf")
+	key := common.HexToHash("0x4a985c9a291a06b2854315c3a75ca2c1065ef62e859e2534b655d306748c16d4")
+	c.Check(LayoutSolidity.SlotIndex(address, key, 10), qt.Equals, 1)
+	c.Check(LayoutSolidity.SlotIndex(address, key, 0), qt.Equals, -1)
+	c.Check(LayoutSolidity.SlotIndex(common.Address{}, key, 10), qt.Equals, -1)
+	c.Check(LayoutVyper.SlotIndex(address, key, 10), qt.Equals, -1)
+	key = GetVyperMapSlot(address, 3)
+	c.Check(LayoutVyper.SlotIndex(address, key, 10), qt.Equals, 3)
+}
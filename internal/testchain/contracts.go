@@ -0,0 +1,149 @@
+package testchain
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// ERC20BalancesSlot is the index slot of the balances map of
+	// ierc20/ERC20.sol
+	ERC20BalancesSlot = 0
+	// StandardTokenBalancesSlot is the index slot of the balances map of the
+	// ConsenSys StandardToken, after its total supply
+	StandardTokenBalancesSlot = 1
+	// MinimeBalancesSlot is the index slot of the checkpoints map of
+	// MiniMeToken.sol
+	MinimeBalancesSlot = 8
+)
+
+// implementationSlot is the EIP-1967 slot holding the implementation address
+// of a proxy
+var implementationSlot = common.HexToHash(
+	"0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc")
+
+// contractFiles holds the ABI and the creation code of the Solidity test
+// contracts, compiled by contracts/build.js
+//
+//go:embed contracts/*.abi contracts/*.bin
+var contractFiles embed.FS
+
+// contract is a compiled Solidity test contract
+type contract struct {
+	abi  abi.ABI
+	code []byte
+}
+
+// The Solidity test contracts
+var (
+	// testERC20 is ierc20/ERC20.sol with mint and burn methods of its owner
+	testERC20 = loadContract("TestERC20")
+	// testERC20AtSlot holds the variants of testERC20 which balances map is
+	// at another index slot
+	testERC20AtSlot = map[int64]*contract{
+		3:  loadContract("TestERC20Slot3"),
+		40: loadContract("TestERC20Slot40"),
+		60: loadContract("TestERC20Slot60"),
+	}
+	// testMiniMe is MiniMeToken.sol without parent token
+	testMiniMe = loadContract("TestMiniMe")
+	// testStandardToken is the ConsenSys StandardToken, without the ERC20
+	// metadata methods, with mint and burn methods of its owner
+	testStandardToken = loadContract("TestStandardToken")
+	testProxy         = loadContract("TestProxy")
+	testBeacon        = loadContract("TestBeacon")
+	testBeaconProxy   = loadContract("TestBeaconProxy")
+)
+
+// loadContract loads the ABI and the creation code of a test contract
+func loadContract(name string) *contract {
+	abiJSON, err := contractFiles.ReadFile("contracts/" + name + ".abi")
+	if err != nil {
+		panic(err)
+	}
+	parsed, err := abi.JSON(bytes.NewReader(abiJSON))
+	if err != nil {
+		panic(fmt.Sprintf("invalid ABI of %s: %v", name, err))
+	}
+	bin, err := contractFiles.ReadFile("contracts/" + name + ".bin")
+	if err != nil {
+		panic(err)
+	}
+	return &contract{abi: parsed, code: common.FromHex(string(bytes.TrimSpace(bin)))}
+}
+
+// pack returns the call data of method with args
+func (ct *contract) pack(method string, args ...interface{}) []byte {
+	data, err := ct.abi.Pack(method, args...)
+	if err != nil {
+		panic(fmt.Sprintf("cannot pack %s: %v", method, err))
+	}
+	return data
+}
+
+// create deploys the contract with the constructor args
+func (c *Chain) create(ct *contract, args ...interface{}) common.Address {
+	addr := c.send(nil, nil, append(common.CopyBytes(ct.code), ct.pack("", args...)...))
+	c.created[addr] = true
+	return addr
+}
+
+// call sends a transaction calling method of the contract at addr
+func (c *Chain) call(ct *contract, addr common.Address, method string, args ...interface{}) {
+	c.send(&addr, nil, ct.pack(method, args...))
+}
+
+// view calls method of the contract at addr on the last sealed block, and
+// returns its first output
+func (c *Chain) view(ct *contract, addr common.Address, method string,
+	args ...interface{}) interface{} {
+	output, err := c.eth.CallContract(context.Background(), ethereum.CallMsg{
+		From: deployer,
+		To:   &addr,
+		Data: ct.pack(method, args...),
+	}, nil)
+	if err != nil {
+		panic(fmt.Sprintf("cannot call %s: %v", method, err))
+	}
+	values, err := ct.abi.Unpack(method, output)
+	if err != nil {
+		panic(fmt.Sprintf("cannot unpack %s: %v", method, err))
+	}
+	return values[0]
+}
+
+// tokenBalance returns the balance of holder of the token at addr on the
+// block being built
+func (c *Chain) tokenBalance(ct *contract, addr, holder common.Address) *big.Int {
+	if balance, ok := c.tokenBalances[addr][holder]; ok {
+		return balance
+	}
+	if c.created[addr] {
+		return new(big.Int)
+	}
+	return c.view(ct, addr, "balanceOf", holder).(*big.Int)
+}
+
+// setTokenBalance sets the balance of holder of the token at addr, calling
+// mint with the amount to add or burn with the amount to remove.
+func (c *Chain) setTokenBalance(ct *contract, addr, holder common.Address, balance *big.Int,
+	mint, burn string) {
+	previous := c.tokenBalance(ct, addr, holder)
+	switch amount := new(big.Int).Sub(balance, previous); amount.Sign() {
+	case 1:
+		c.call(ct, addr, mint, holder, amount)
+	case -1:
+		c.call(ct, addr, burn, holder, amount.Neg(amount))
+	}
+	if c.tokenBalances[addr] == nil {
+		c.tokenBalances[addr] = make(map[common.Address]*big.Int)
+	}
+	c.tokenBalances[addr][holder] = balance
+}
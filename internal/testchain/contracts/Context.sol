@@ -0,0 +1,17 @@
+// SPDX-License-Identifier: MIT
+
+/*
+ * @dev ierc20/Context.sol for Solidity 0.8, which no longer converts
+ * msg.sender to address payable.  It replaces the bundled one when building
+ * the test contracts, and has no state, so the storage layout of
+ * ierc20/ERC20.sol is unchanged.
+ */
+abstract contract Context {
+    function _msgSender() internal view virtual returns (address) {
+        return msg.sender;
+    }
+
+    function _msgData() internal view virtual returns (bytes memory) {
+        return msg.data;
+    }
+}
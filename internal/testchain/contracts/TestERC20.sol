@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.0;
+
+import "ierc20/ERC20.sol";
+
+/*
+ * @dev The bundled ierc20/ERC20.sol token, which owner mints and burns the
+ * balances.  Its balances map is at the index slot 0.
+ */
+contract TestERC20 is ERC20 {
+    address private _owner;
+
+    constructor(string memory name_, string memory symbol_, uint8 decimals_)
+        ERC20(name_, symbol_) {
+        _owner = msg.sender;
+        _setupDecimals(decimals_);
+    }
+
+    /*
+     * @dev Sets up the state of a proxy delegating to the token, which
+     * constructor did not run on the storage of the proxy.
+     */
+    function initialize(uint8 decimals_) external {
+        require(_owner == address(0), "TestERC20: already initialized");
+        _owner = msg.sender;
+        _setupDecimals(decimals_);
+    }
+
+    function mint(address account, uint256 amount) external {
+        require(msg.sender == _owner, "TestERC20: caller is not the owner");
+        _mint(account, amount);
+    }
+
+    function burn(address account, uint256 amount) external {
+        require(msg.sender == _owner, "TestERC20: caller is not the owner");
+        _burn(account, amount);
+    }
+}
+
+/*
+ * @dev The Gap contracts shift the storage of the contracts inheriting them,
+ * as the state variables of a token declared before the ones of ERC20.sol.
+ */
+abstract contract Gap3 {
+    uint256[3] private _gap;
+}
+
+abstract contract Gap40 {
+    uint256[40] private _gap;
+}
+
+abstract contract Gap60 {
+    uint256[60] private _gap;
+}
+
+/*
+ * @dev TestERC20 with its balances map at the index slot 3.
+ */
+contract TestERC20Slot3 is Gap3, TestERC20 {
+    constructor(string memory name_, string memory symbol_, uint8 decimals_)
+        TestERC20(name_, symbol_, decimals_) {}
+}
+
+/*
+ * @dev TestERC20 with its balances map at the index slot 40.
+ */
+contract TestERC20Slot40 is Gap40, TestERC20 {
+    constructor(string memory name_, string memory symbol_, uint8 decimals_)
+        TestERC20(name_, symbol_, decimals_) {}
+}
+
+/*
+ * @dev TestERC20 with its balances map at the index slot 60.
+ */
+contract TestERC20Slot60 is Gap60, TestERC20 {
+    constructor(string memory name_, string memory symbol_, uint8 decimals_)
+        TestERC20(name_, symbol_, decimals_) {}
+}
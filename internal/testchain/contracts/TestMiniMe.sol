@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: GPL-3.0
+pragma solidity ^0.8.0;
+
+import "MiniMeToken.sol";
+
+/*
+ * @dev A MiniMeToken without parent, which controller generates and destroys
+ * the balances.  Its checkpoints map is at the index slot 8.
+ */
+contract TestMiniMe is MiniMeToken {
+    constructor(string memory _tokenName, uint8 _decimalUnits, string memory _tokenSymbol)
+        MiniMeToken(address(0), payable(address(0)), 0, _tokenName, _decimalUnits,
+            _tokenSymbol, true) {}
+
+    /*
+     * @dev Sets up the state of a proxy delegating to the token, which
+     * constructor did not run on the storage of the proxy.
+     */
+    function initialize(string memory _tokenName, uint8 _decimalUnits,
+        string memory _tokenSymbol) external {
+        require(controller == address(0), "TestMiniMe: already initialized");
+        controller = payable(msg.sender);
+        name = _tokenName;
+        decimals = _decimalUnits;
+        symbol = _tokenSymbol;
+        creationBlock = block.number;
+        transfersEnabled = true;
+    }
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MIT
+pragma solidity ^0.8.0;
+
+/*
+ * @dev Delegates the current call to `implementation`, returning or
+ * reverting with its output, as the OpenZeppelin Proxy contract.
+ */
+abstract contract Delegator {
+    function _delegate(address implementation) internal {
+        assembly {
+            calldatacopy(0, 0, calldatasize())
+            let result := delegatecall(gas(), implementation, 0, calldatasize(), 0, 0)
+            returndatacopy(0, 0, returndatasize())
+            switch result
+            case 0 { revert(0, returndatasize()) }
+            default { return(0, returndatasize()) }
+        }
+    }
+
+    /*
+     * @dev Runs the initialization call `data` of `implementation` on the
+     * storage of the proxy.
+     */
+    function _initialize(address implementation, bytes memory data) internal {
+        if (data.length > 0) {
+            (bool success, bytes memory output) = implementation.delegatecall(data);
+            if (!success) {
+                assembly { revert(add(output, 32), mload(output)) }
+            }
+        }
+    }
+}
+
+/*
+ * @dev A proxy which delegates all the calls to the implementation address
+ * stored at `slot`: the EIP-1967 implementation slot, the EIP-1822 PROXIABLE
+ * slot or the legacy OpenZeppelin one.
+ */
+contract TestProxy is Delegator {
+    bytes32 private immutable _slot;
+
+    constructor(bytes32 slot, address implementation, bytes memory data) {
+        _slot = slot;
+        assembly { sstore(slot, implementation) }
+        _initialize(implementation, data);
+    }
+
+    fallback() external payable {
+        bytes32 slot = _slot;
+        address implementation;
+        assembly { implementation := sload(slot) }
+        _delegate(implementation);
+    }
+}
+
+/*
+ * @dev A beacon with the storage layout of the OpenZeppelin
+ * UpgradeableBeacon: its owner at the slot 0 and its implementation at the
+ * slot 1.
+ */
+contract TestBeacon {
+    address private _owner;
+    address private _implementation;
+
+    event Upgraded(address indexed implementation);
+
+    constructor(address implementation_) {
+        _owner = msg.sender;
+        _implementation = implementation_;
+        emit Upgraded(implementation_);
+    }
+
+    function implementation() public view returns (address) {
+        return _implementation;
+    }
+
+    function upgradeTo(address newImplementation) external {
+        require(msg.sender == _owner, "TestBeacon: caller is not the owner");
+        _implementation = newImplementation;
+        emit Upgraded(newImplementation);
+    }
+}
+
+/*
+ * @dev An EIP-1967 beacon proxy, which delegates all the calls to the
+ * implementation returned by the beacon stored at the EIP-1967 beacon slot.
+ */
+contract TestBeaconProxy is Delegator {
+    bytes32 private constant _BEACON_SLOT =
+        0xa3f0ad74e5423aebfd80d3ef4346578335a9a72aeaee59ff6cb3582b35133d50;
+
+    constructor(address beacon, bytes memory data) {
+        assembly { sstore(_BEACON_SLOT, beacon) }
+        _initialize(TestBeacon(beacon).implementation(), data);
+    }
+
+    fallback() external payable {
+        address beacon;
+        assembly { beacon := sload(_BEACON_SLOT) }
+        _delegate(TestBeacon(beacon).implementation());
+    }
+}
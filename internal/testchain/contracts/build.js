@@ -0,0 +1,57 @@
+// Compiles the test contracts with a soljson release of the Solidity
+// compiler, writing the ABI and the creation code of each of them to
+// <contract>.abi and <contract>.bin:
+//
+//	node build.js soljson-v0.8.21+commit.d9974bed.js
+//
+// The soljson releases are published at https://binaries.soliditylang.org.
+// ierc20/ERC20.sol is compiled with Context.sol in place of the bundled one.
+const fs = require('fs');
+const path = require('path');
+
+const contracts = [
+  'TestERC20', 'TestERC20Slot3', 'TestERC20Slot40', 'TestERC20Slot60',
+  'TestMiniMe', 'TestStandardToken',
+  'TestProxy', 'TestBeacon', 'TestBeaconProxy',
+];
+
+const dir = __dirname;
+const ierc20 = path.join(dir, '..', '..', '..', 'ierc20');
+const read = (file) => ({ content: fs.readFileSync(file, 'utf8') });
+
+const sources = {
+  'ierc20/ERC20.sol': read(path.join(ierc20, 'ERC20.sol')),
+  'ierc20/IERC20.sol': read(path.join(ierc20, 'IERC20.sol')),
+  'ierc20/SafeMath.sol': read(path.join(ierc20, 'SafeMath.sol')),
+  'ierc20/Context.sol': read(path.join(dir, 'Context.sol')),
+};
+for (const file of fs.readdirSync(dir)) {
+  if (file.endsWith('.sol') && file !== 'Context.sol') {
+    sources[file] = read(path.join(dir, file));
+  }
+}
+
+const input = {
+  language: 'Solidity',
+  sources,
+  settings: {
+    optimizer: { enabled: true, runs: 200 },
+    outputSelection: { '*': { '*': ['abi', 'evm.bytecode.object'] } },
+  },
+};
+
+const soljson = require(path.resolve(process.argv[2]));
+const compile = soljson.cwrap('solidity_compile', 'string', ['string', 'number', 'number']);
+const output = JSON.parse(compile(JSON.stringify(input), 0, 0));
+const errors = (output.errors || []).filter((e) => e.severity === 'error');
+if (errors.length > 0) {
+  errors.forEach((e) => console.error(e.formattedMessage));
+  process.exit(1);
+}
+
+for (const name of contracts) {
+  const file = Object.keys(output.contracts).find((f) => output.contracts[f][name]);
+  const contract = output.contracts[file][name];
+  fs.writeFileSync(path.join(dir, name + '.abi'), JSON.stringify(contract.abi) + '\n');
+  fs.writeFileSync(path.join(dir, name + '.bin'), contract.evm.bytecode.object + '\n');
+}
@@ -0,0 +1,364 @@
+package testchain
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+)
+
+const (
+	// ERC20TotalSupplySlot is the slot of the total supply of the ERC20
+	// contracts, as in ierc20/ERC20.sol.
+	ERC20TotalSupplySlot = 2
+	// ERC20VotesCheckpointsSlot is the index slot of the checkpoints map of
+	// the ERC20Votes contract, as in the OpenZeppelin ERC20Votes.sol.
+	ERC20VotesCheckpointsSlot = 8
+	// CompBalancesSlot is the index slot of the balances map of the COMP
+	// token, as in Comp.sol
+	CompBalancesSlot = 1
+	// CompCheckpointsSlot is the index slot of the checkpoints map of the
+	// COMP token, as in Comp.sol
+	CompCheckpointsSlot = 3
+	// CompNumCheckpointsSlot is the index slot of the numCheckpoints map of
+	// the COMP token, as in Comp.sol
+	CompNumCheckpointsSlot = 4
+	// ERC721OwnersSlot is the index slot of the owners map of the ERC721
+	// contract, as in the OpenZeppelin ERC721.sol.
+	ERC721OwnersSlot = 2
+	// ERC721BalancesSlot is the index slot of the balances map of the ERC721
+	// contract, as in the OpenZeppelin ERC721.sol.
+	ERC721BalancesSlot = 3
+	// ERC721TotalSupplySlot is the slot of the number of minted tokens of
+	// the ERC721 contract.
+	ERC721TotalSupplySlot = 6
+	// ERC1155BalancesSlot is the index slot of the balances map of the
+	// ERC1155 contract, as in the OpenZeppelin ERC1155.sol.
+	ERC1155BalancesSlot = 0
+)
+
+// compTotalSupply is the total supply of the COMP token, 10M COMP
+var compTotalSupply = new(big.Int).Mul(big.NewInt(10_000_000), big.NewInt(1e18))
+
+// selectors of the ERC20 methods implemented by the test contracts
+var (
+	selName        = selector("name()")
+	selSymbol      = selector("symbol()")
+	selDecimals    = selector("decimals()")
+	selTotalSupply = selector("totalSupply()")
+	selBalanceOf   = selector("balanceOf(address)")
+	selOwnerOf     = selector("ownerOf(uint256)")
+	selGetVotes    = selector("getVotes(address)")
+	selClockMode   = selector("CLOCK_MODE()")
+	// selGetCurrentVotes is the COMP getCurrentVotes method
+	selGetCurrentVotes = selector("getCurrentVotes(address)")
+	// selBalanceOfID is the ERC1155 balanceOf method
+	selBalanceOfID = selector("balanceOf(address,uint256)")
+)
+
+func selector(signature string) []byte {
+	return crypto.Keccak256([]byte(signature))[:4]
+}
+
+// program is a minimal EVM assembler with support for jump labels
+type program struct {
+	code   []byte
+	labels map[string]int
+	// jumps holds the position of the PUSH2 arguments of the labels
+	jumps map[int]string
+}
+
+// newProgram starts a contract which accepts the admin calls of the deployer
+// before running the rest of the program, see admin.
+func newProgram() *program {
+	p := &program{labels: make(map[string]int), jumps: make(map[int]string)}
+	p.op(vm.CALLER).push(deployer[:]).op(vm.EQ).jumpIf("admin")
+	return p
+}
+
+// adminStore is the operation of the admin calls storing a word, the first
+// byte of their call data.  The operations 1 to 4 emit an event with that
+// number of topics.
+const adminStore = 0
+
+// admin implements the admin calls of the deployer, which modify the state
+// of the contract as its transactions would.  A store call has the slot at 1
+// and the word at 33, an event call has the topics from 1 on and the event
+// data after them.
+func (p *program) admin() *program {
+	p.label("admin").pushInt(0).op(vm.CALLDATALOAD).pushInt(0xf8).op(vm.SHR)
+	p.op(vm.DUP1, vm.ISZERO).jumpIf("adminStore")
+	for topics := 1; topics <= 4; topics++ {
+		p.op(vm.DUP1).pushInt(int64(topics)).op(vm.EQ).jumpIf(fmt.Sprintf("adminLog%d", topics))
+	}
+	p.pushInt(0).op(vm.DUP1, vm.REVERT)
+	p.label("adminStore").pushInt(33).op(vm.CALLDATALOAD)
+	p.pushInt(1).op(vm.CALLDATALOAD, vm.SSTORE, vm.STOP)
+	for topics := 1; topics <= 4; topics++ {
+		offset := int64(1 + 32*topics)
+		p.label(fmt.Sprintf("adminLog%d", topics)).op(vm.POP)
+		// stack: [data size]
+		p.op(vm.CALLDATASIZE).pushInt(offset).op(vm.SWAP1, vm.SUB)
+		p.op(vm.DUP1).pushInt(offset).pushInt(0).op(vm.CALLDATACOPY)
+		for i := topics - 1; i >= 0; i-- {
+			p.pushInt(int64(1 + 32*i)).op(vm.CALLDATALOAD)
+		}
+		// stack: [data size, topic n-1, ..., topic 0]
+		p.op(vm.DUP1 + vm.OpCode(topics)).pushInt(0)
+		p.op(vm.LOG0+vm.OpCode(topics), vm.STOP)
+	}
+	return p
+}
+
+// storeData returns the call data of the admin call storing value on key
+func storeData(key, value common.Hash) []byte {
+	return append(append([]byte{adminStore}, key[:]...), value[:]...)
+}
+
+// logData returns the call data of the admin call emitting an event with
+// topics and data
+func logData(topics []common.Hash, data []byte) []byte {
+	if len(topics) == 0 || len(topics) > 4 {
+		panic(fmt.Sprintf("invalid number of topics %d", len(topics)))
+	}
+	input := []byte{byte(len(topics))}
+	for _, topic := range topics {
+		input = append(input, topic[:]...)
+	}
+	return append(input, data...)
+}
+
+// initCode returns the creation code of a contract with the runtime code
+// code, which copies and returns it.
+func initCode(code []byte) []byte {
+	// PUSH2 len, DUP1, PUSH1 12, PUSH1 0, CODECOPY, PUSH1 0, RETURN
+	init := []byte{byte(vm.PUSH2), byte(len(code) >> 8), byte(len(code)), byte(vm.DUP1),
+		byte(vm.PUSH1), 12, byte(vm.PUSH1), 0, byte(vm.CODECOPY), byte(vm.PUSH1), 0,
+		byte(vm.RETURN)}
+	return append(init, code...)
+}
+
+func (p *program) op(ops ...vm.OpCode) *program {
+	for _, op := range ops {
+		p.code = append(p.code, byte(op))
+	}
+	return p
+}
+
+// push pushes value using the smallest PUSH instruction
+func (p *program) push(value []byte) *program {
+	value = common.TrimLeftZeroes(value)
+	if len(value) == 0 {
+		value = []byte{0}
+	}
+	p.code = append(p.code, byte(vm.PUSH1)+byte(len(value)-1))
+	p.code = append(p.code, value...)
+	return p
+}
+
+func (p *program) pushInt(value int64) *program {
+	return p.push(big.NewInt(value).Bytes())
+}
+
+// pushLabel pushes the position of a label, which can be defined later
+func (p *program) pushLabel(label string) *program {
+	p.code = append(p.code, byte(vm.PUSH2))
+	p.jumps[len(p.code)] = label
+	p.code = append(p.code, 0, 0)
+	return p
+}
+
+// label defines a jump destination
+func (p *program) label(label string) *program {
+	p.labels[label] = len(p.code)
+	return p.op(vm.JUMPDEST)
+}
+
+// jumpIf jumps to label if the top of the stack is not zero
+func (p *program) jumpIf(label string) *program {
+	return p.pushLabel(label).op(vm.JUMPI)
+}
+
+// dispatch jumps to label if the selector on the top of the stack is sel,
+// keeping the selector on the stack.
+func (p *program) dispatch(sel []byte, label string) *program {
+	return p.op(vm.DUP1).push(sel).op(vm.EQ).jumpIf(label)
+}
+
+// returnWord returns the word at the top of the stack
+func (p *program) returnWord() *program {
+	return p.pushInt(0).op(vm.MSTORE).pushInt(32).pushInt(0).op(vm.RETURN)
+}
+
+// returnString returns s ABI encoded as a string.  s must fit in a word.
+func (p *program) returnString(s string) *program {
+	if len(s) > 32 {
+		panic(fmt.Sprintf("string too long: %q", s))
+	}
+	word := common.RightPadBytes([]byte(s), 32)
+	p.pushInt(32).pushInt(0).op(vm.MSTORE)
+	p.pushInt(int64(len(s))).pushInt(32).op(vm.MSTORE)
+	// PUSH32 to keep the trailing zeroes of the right aligned string
+	p.code = append(p.code, byte(vm.PUSH32))
+	p.code = append(p.code, word...)
+	p.pushInt(64).op(vm.MSTORE)
+	return p.pushInt(96).pushInt(0).op(vm.RETURN)
+}
+
+// mapSlot computes the storage slot of the map at index slot for the address
+// argument of the call, with the Solidity layout.
+func (p *program) mapSlot(slot int64) *program {
+	return p.layoutMapSlot(slot, helpers.LayoutSolidity)
+}
+
+// layoutMapSlot computes the storage slot of the map at index slot for the
+// address argument of the call, hashing the key and the slot in the order of
+// layout.
+func (p *program) layoutMapSlot(slot int64, layout helpers.MapLayout) *program {
+	keyOffset, slotOffset := int64(0), int64(32)
+	if layout == helpers.LayoutVyper {
+		keyOffset, slotOffset = 32, 0
+	}
+	p.pushInt(4).op(vm.CALLDATALOAD).pushInt(keyOffset).op(vm.MSTORE)
+	p.pushInt(slot).pushInt(slotOffset).op(vm.MSTORE)
+	return p.pushInt(64).pushInt(0).op(vm.KECCAK256)
+}
+
+// bytecode adds the admin calls, resolves the labels and returns the code
+func (p *program) bytecode() []byte {
+	if _, ok := p.labels["admin"]; !ok {
+		p.admin()
+	}
+	code := common.CopyBytes(p.code)
+	for pos, label := range p.jumps {
+		dest, ok := p.labels[label]
+		if !ok {
+			panic(fmt.Sprintf("undefined label %s", label))
+		}
+		code[pos], code[pos+1] = byte(dest>>8), byte(dest)
+	}
+	return code
+}
+
+// selector pushes the selector of the call
+func (p *program) selector() *program {
+	return p.pushInt(0).op(vm.CALLDATALOAD).pushInt(0xe0).op(vm.SHR)
+}
+
+// metadata dispatches the ERC20 metadata methods and reverts any other call,
+// the caller must define the balanceOf and totalSupply labels.
+func (p *program) metadata(name, symbol string, decimals int) *program {
+	p.dispatch(selBalanceOf, "balanceOf")
+	p.dispatch(selTotalSupply, "totalSupply")
+	p.dispatch(selDecimals, "decimals")
+	p.dispatch(selName, "name")
+	p.dispatch(selSymbol, "symbol")
+	p.pushInt(0).op(vm.DUP1, vm.REVERT)
+	p.label("decimals").pushInt(int64(decimals)).returnWord()
+	p.label("name").returnString(name)
+	p.label("symbol").returnString(symbol)
+	return p
+}
+
+// vyperERC20Code returns the runtime code of a read only ERC20 token which
+// keeps the balances map at balancesSlot with the layout of Vyper, and the
+// total supply in the same storage slot as ierc20/ERC20.sol.
+func vyperERC20Code(name, symbol string, decimals uint8, balancesSlot int64) []byte {
+	p := newProgram().selector().metadata(name, symbol, int(decimals))
+	p.label("balanceOf").layoutMapSlot(balancesSlot, helpers.LayoutVyper).op(vm.SLOAD)
+	p.returnWord()
+	p.label("totalSupply").pushInt(ERC20TotalSupplySlot).op(vm.SLOAD).returnWord()
+	return p.bytecode()
+}
+
+// erc721Code returns the runtime code of a read only ERC721 token, which
+// keeps the owners and the balances maps at the same index slots as the
+// OpenZeppelin ERC721 contract.  ownerOf reverts for token ids without owner.
+func erc721Code(name, symbol string) []byte {
+	p := newProgram().selector()
+	p.dispatch(selOwnerOf, "ownerOf")
+	p.metadata(name, symbol, 0)
+	p.label("ownerOf").mapSlot(ERC721OwnersSlot).op(vm.SLOAD)
+	p.op(vm.DUP1).jumpIf("owned")
+	p.pushInt(0).op(vm.DUP1, vm.REVERT)
+	p.label("owned").returnWord()
+	p.label("balanceOf").mapSlot(ERC721BalancesSlot).op(vm.SLOAD).returnWord()
+	p.label("totalSupply").pushInt(ERC721TotalSupplySlot).op(vm.SLOAD).returnWord()
+	return p.bytecode()
+}
+
+// erc1155Code returns the runtime code of a read only ERC1155 token, which
+// keeps the nested balances map at the same index slot as the OpenZeppelin
+// ERC1155 contract.
+func erc1155Code() []byte {
+	p := newProgram().selector()
+	p.dispatch(selBalanceOfID, "balanceOf")
+	p.pushInt(0).op(vm.DUP1, vm.REVERT)
+	// keccak256(account . keccak256(id . slot))
+	p.label("balanceOf")
+	p.pushInt(36).op(vm.CALLDATALOAD).pushInt(0).op(vm.MSTORE)
+	p.pushInt(ERC1155BalancesSlot).pushInt(32).op(vm.MSTORE)
+	p.pushInt(64).pushInt(0).op(vm.KECCAK256).pushInt(32).op(vm.MSTORE)
+	p.pushInt(4).op(vm.CALLDATALOAD).pushInt(0).op(vm.MSTORE)
+	p.pushInt(64).pushInt(0).op(vm.KECCAK256, vm.SLOAD).returnWord()
+	return p.bytecode()
+}
+
+// erc20VotesCode returns the runtime code of a read only OpenZeppelin
+// ERC20Votes token, which keeps the checkpoints of the votes of each
+// delegate at ERC20VotesCheckpointsSlot, with the key (block number or
+// timestamp) in the lower keyBytes.  CLOCK_MODE returns clockMode, or
+// reverts if it is empty.
+func erc20VotesCode(name, symbol string, keyBytes int, clockMode string) []byte {
+	p := newProgram().selector()
+	p.dispatch(selGetVotes, "getVotes")
+	if clockMode != "" {
+		p.dispatch(selClockMode, "clockMode")
+	}
+	p.metadata(name, symbol, 18)
+	p.label("balanceOf").mapSlot(ERC20BalancesSlot).op(vm.SLOAD).returnWord()
+	p.label("totalSupply").pushInt(ERC20TotalSupplySlot).op(vm.SLOAD).returnWord()
+	p.label("getVotes").mapSlot(ERC20VotesCheckpointsSlot)
+	// stack: [array slot]
+	p.op(vm.DUP1, vm.SLOAD)
+	// stack: [array slot, length]
+	p.op(vm.DUP1, vm.ISZERO).jumpIf("noVotes")
+	p.op(vm.SWAP1).pushInt(0).op(vm.MSTORE)
+	p.pushInt(32).pushInt(0).op(vm.KECCAK256, vm.ADD)
+	p.pushInt(1).op(vm.SWAP1, vm.SUB, vm.SLOAD)
+	p.pushInt(int64(keyBytes * 8)).op(vm.SHR).returnWord()
+	p.label("noVotes").op(vm.POP, vm.POP).pushInt(0).returnWord()
+	if clockMode != "" {
+		p.label("clockMode").returnString(clockMode)
+	}
+	return p.bytecode()
+}
+
+// compCode returns the runtime code of a read only COMP token, which keeps
+// the checkpoints of the votes of each delegate on a map of maps at
+// CompCheckpointsSlot, indexed by the numCheckpoints map at
+// CompNumCheckpointsSlot.  Each checkpoint packs the uint32 fromBlock in the
+// lower bits and the uint96 votes above it.
+func compCode(name, symbol string) []byte {
+	p := newProgram().selector()
+	p.dispatch(selGetCurrentVotes, "getCurrentVotes")
+	p.metadata(name, symbol, 18)
+	p.label("balanceOf").mapSlot(CompBalancesSlot).op(vm.SLOAD).returnWord()
+	// The total supply of COMP is a constant
+	p.label("totalSupply").push(compTotalSupply.Bytes()).returnWord()
+	p.label("getCurrentVotes").mapSlot(CompNumCheckpointsSlot).op(vm.SLOAD)
+	// stack: [numCheckpoints]
+	p.op(vm.DUP1, vm.ISZERO).jumpIf("noVotes")
+	p.pushInt(1).op(vm.SWAP1, vm.SUB).mapSlot(CompCheckpointsSlot)
+	// stack: [numCheckpoints-1, checkpoints map slot]
+	p.pushInt(32).op(vm.MSTORE).pushInt(0).op(vm.MSTORE)
+	p.pushInt(64).pushInt(0).op(vm.KECCAK256, vm.SLOAD)
+	// the votes are the 96 bits above the fromBlock
+	p.pushInt(32).op(vm.SHR).push(bytes.Repeat([]byte{0xff}, 12)).op(vm.AND).returnWord()
+	p.label("noVotes").op(vm.POP).pushInt(0).returnWord()
+	return p.bytecode()
+}
@@ -0,0 +1,115 @@
+package testchain
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// sampleTokenABI is the part of the ABI of the sample token of ethereum.org
+// used to deploy it and transfer its tokens
+const sampleTokenABI = `[
+	{"inputs": [{"name": "initialSupply", "type": "uint256"},
+		{"name": "tokenName", "type": "string"}, {"name": "decimalUnits", "type": "uint8"},
+		{"name": "tokenSymbol", "type": "string"}], "type": "constructor"},
+	{"constant": false, "inputs": [{"name": "_to", "type": "address"},
+		{"name": "_value", "type": "uint256"}], "name": "transfer", "outputs": [],
+		"type": "function"}
+]`
+
+// sampleTokenCode is the creation code of the sample token of ethereum.org,
+// a Solidity contract compiled for the geth abigen tests.  Its state is
+// changed with its own transfer method.
+var sampleTokenCode = common.FromHex("" +
+	"60606040526040516107fd3803806107fd83398101604052805160805160a05160c0519293918201" +
+	"92909101600160a060020a0333166000908152600360209081526040822086905581548551838052" +
+	"601f6002600019610100600186161502019093169290920482018390047f290decd9548b62a8d603" +
+	"45a988386fc84ba6bc95484008f6362f93160ef3e56390810193919290918801908390106100e857" +
+	"805160ff19168380011785555b506101189291505b8082111561017157600081556001016100b456" +
+	"5b50506002805460ff19168317905550505050610658806101a56000396000f35b82800160010185" +
+	"5582156100ac579182015b828111156100ac5782518260005055916020019190600101906100fa56" +
+	"5b505080600160005090805190602001908280546001816001161561010002031660029004906000" +
+	"52602060002090601f016020900481019282601f1061017557805160ff19168380011785555b5061" +
+	"00c89291506100b4565b5090565b82800160010185558215610165579182015b8281111561016557" +
+	"825182600050559160200191906001019061018756606060405236156100775760e060020a600035" +
+	"046306fdde03811461007f57806323b872dd146100dc578063313ce5671461010e57806370a08231" +
+	"1461011a57806395d89b4114610132578063a9059cbb1461018e578063cae9ca51146101bd578063" +
+	"dc3080f21461031c578063dd62ed3e14610341575b610365610002565b6103676000805460206002" +
+	"6001831615610100026000190190921691909104601f810182900490910260809081016040526060" +
+	"828152929190828280156104eb5780601f106104c0576101008083540402835291602001916104eb" +
+	"565b6103d5600435602435604435600160a060020a03831660009081526003602052604081205482" +
+	"9010156104f357610002565b6103e760025460ff1681565b6103d560043560036020526000908152" +
+	"604090205481565b610367600180546020600282841615610100026000190190921691909104601f" +
+	"810182900490910260809081016040526060828152929190828280156104eb5780601f106104c057" +
+	"6101008083540402835291602001916104eb565b610365600435602435600160a060020a03331660" +
+	"0090815260036020526040902054819010156103f157610002565b60806020604435600481810135" +
+	"601f8101849004909302840160405260608381526103d59482359460248035956064949391019190" +
+	"81908382808284375094965050505050505060006000836004600050600033600160a060020a0316" +
+	"8152602001908152602001600020600050600087600160a060020a03168152602001908152602001" +
+	"6000206000508190555084905080600160a060020a0316638f4ffcb1338630876040518560e06002" +
+	"0a0281526004018085600160a060020a0316815260200184815260200183600160a060020a031681" +
+	"52602001806020018281038252838181518152602001915080519060200190808383829060006004" +
+	"602084601f0104600f02600301f150905090810190601f1680156102f25780820380516001836020" +
+	"036101000a031916815260200191505b50955050505050506000604051808303816000876161da5a" +
+	"03f11561000257505050509392505050565b60056020908152600435600090815260408082209092" +
+	"52602435815220546103d59081565b60046020818152903560009081526040808220909252602435" +
+	"815220546103d59081565b005b604051808060200182810382528381815181526020019150805190" +
+	"60200190808383829060006004602084601f0104600f02600301f150905090810190601f16801561" +
+	"03c75780820380516001836020036101000a031916815260200191505b5092505050604051809103" +
+	"90f35b60408051918252519081900360200190f35b6060908152602090f35b600160a060020a0382" +
+	"1660009081526040902054808201101561041357610002565b806003600050600033600160a06002" +
+	"0a031681526020019081526020016000206000828282505403925050819055508060036000506000" +
+	"84600160a060020a0316815260200190815260200160002060008282825054019250508190555081" +
+	"600160a060020a031633600160a060020a03167fddf252ad1be2c89b69c2b068fc378daa952ba7f1" +
+	"63c4a11628f55a4df523b3ef836040518082815260200191505060405180910390a35050565b8201" +
+	"91906000526020600020905b8154815290600101906020018083116104ce57829003601f16820191" +
+	"5b505050505081565b600160a060020a03831681526040812054808301101561051257610002565b" +
+	"600160a060020a038085168083526004602090815260408085203394909416808652938252808520" +
+	"5492855260058252808520938552929052908220548301111561055c57610002565b816003600050" +
+	"600086600160a060020a031681526020019081526020016000206000828282505403925050819055" +
+	"50816003600050600085600160a060020a0316815260200190815260200160002060008282825054" +
+	"0192505081905550816005600050600086600160a060020a03168152602001908152602001600020" +
+	"600050600033600160a060020a031681526020019081526020016000206000828282505401925050" +
+	"8190555082600160a060020a031633600160a060020a03167fddf252ad1be2c89b69c2b068fc378d" +
+	"aa952ba7f163c4a11628f55a4df523b3ef846040518082815260200191505060405180910390a393" +
+	"9250505056")
+
+// SampleTokenBalancesSlot is the index slot of the balanceOf map of the
+// sample token.  It has no totalSupply method.
+const SampleTokenBalancesSlot = 3
+
+// sampleToken is the parsed ABI of the sample token
+var sampleToken = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(sampleTokenABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// DeploySampleToken deploys the sample token of ethereum.org, which mints
+// supply to the deployer.
+func (c *Chain) DeploySampleToken(name, symbol string, decimals uint8,
+	supply *big.Int) common.Address {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	args, err := sampleToken.Pack("", supply, name, decimals, symbol)
+	if err != nil {
+		panic(err)
+	}
+	return c.send(nil, nil, append(common.CopyBytes(sampleTokenCode), args...))
+}
+
+// TransferSampleToken transfers amount of a token deployed with
+// DeploySampleToken from the deployer to holder.
+func (c *Chain) TransferSampleToken(token, holder common.Address, amount *big.Int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	data, err := sampleToken.Pack("transfer", holder, amount)
+	if err != nil {
+		panic(err)
+	}
+	c.send(&token, nil, data)
+}
@@ -0,0 +1,707 @@
+// Package testchain provides a simulated Ethereum chain, run by the geth
+// simulated backend and served over web3 RPC, so the token packages can be
+// tested end to end without network access.
+//
+// The ERC20 and MiniMe tokens, and the proxies, are Solidity contracts
+// compiled from the sources at contracts: ierc20/ERC20.sol, a port of
+// MiniMeToken.sol and the ConsenSys StandardToken.  Their state is modified
+// by calling their own methods, such as mint and generateTokens.
+//
+// The other token contracts are minimal EVM programs with the storage layout
+// of the contracts they mimic.  Their state is modified by the admin calls of
+// the deployer account, which the contracts accept as raw storage writes and
+// events.
+//
+// All the state changes are transactions of the deployer, included in the
+// next block sealed by Commit.
+package testchain
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+)
+
+var (
+	// deployerKey is the key of the account which deploys the contracts and
+	// sends all the transactions
+	deployerKey, _ = crypto.HexToECDSA(
+		"b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	deployer = crypto.PubkeyToAddress(deployerKey.PublicKey)
+	// chainID is the chain id of the simulated backend
+	chainID = params.AllDevChainProtocolChanges.ChainID
+	// genesisBalance is the ether of the funded accounts on the genesis block
+	genesisBalance = new(big.Int).Exp(big.NewInt(10), big.NewInt(30), nil)
+	// transferTopic is the topic of the Transfer(address,address,uint256)
+	// event
+	transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	// delegateVotesChangedTopic is the topic of the ERC20Votes
+	// DelegateVotesChanged(address,uint256,uint256) event
+	delegateVotesChangedTopic = crypto.Keccak256Hash(
+		[]byte("DelegateVotesChanged(address,uint256,uint256)"))
+	// transferSingleTopic is the topic of the ERC1155
+	// TransferSingle(address,address,address,uint256,uint256) event
+	transferSingleTopic = crypto.Keccak256Hash(
+		[]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	// transferBatchTopic is the topic of the ERC1155
+	// TransferBatch(address,address,address,uint256[],uint256[]) event
+	transferBatchTopic = crypto.Keccak256Hash(
+		[]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+)
+
+const (
+	// genesisTime is the timestamp of the genesis block.  As it is in the
+	// future, the simulated beacon seals each block one second after its
+	// parent, so the timestamp of a block is known before sealing it.
+	genesisTime = 4_000_000_000
+	// deployGas and callGas are the gas limits of the contract creations
+	// and of the other transactions
+	deployGas = 5_000_000
+	callGas   = 200_000
+)
+
+// Clock modes returned by the CLOCK_MODE method of ERC20Votes tokens
+const (
+	ClockModeBlockNumber = "mode=blocknumber&from=default"
+	ClockModeTimestamp   = "mode=timestamp"
+)
+
+// votesToken is the checkpoints format of an ERC20Votes token
+type votesToken struct {
+	keyBytes  int
+	timestamp bool
+}
+
+// Chain is a simulated chain.  The state modifications are sent as
+// transactions, included in the next block sealed by Commit.
+type Chain struct {
+	lock    sync.Mutex
+	backend *simulated.Backend
+	client  *rpc.Client
+	eth     *ethclient.Client
+	// dir holds the IPC endpoint of the node
+	dir string
+	// nonce is the nonce of the next transaction of the deployer
+	nonce uint64
+	// sent holds the transactions of the block being built
+	sent []common.Hash
+	// pending holds the storage written by the transactions of the block
+	// being built
+	pending map[common.Address]map[common.Hash]common.Hash
+	// balances holds the ether balances set on the block being built
+	balances map[common.Address]*big.Int
+	// created holds the contracts created on the block being built
+	created map[common.Address]bool
+	// contracts holds the Solidity contract of the tokens, and of the
+	// proxies delegating to them
+	contracts map[common.Address]*contract
+	// initData holds the call setting up the state of a proxy delegating to
+	// a Solidity token
+	initData map[common.Address][]byte
+	// tokenBalances holds the balances of the Solidity tokens set on the
+	// block being built
+	tokenBalances map[common.Address]map[common.Address]*big.Int
+	// balancesSlots holds the index slot of the balances map of the Vyper
+	// tokens, and of the proxies delegating to them
+	balancesSlots map[common.Address]int64
+	// layouts holds the map layout of the Vyper tokens, and of the proxies
+	// delegating to them
+	layouts map[common.Address]helpers.MapLayout
+	// votesTokens holds the checkpoints format of the ERC20Votes tokens
+	votesTokens map[common.Address]votesToken
+}
+
+// New creates a chain with a genesis block which funds the deployer
+func New() *Chain {
+	dir, err := os.MkdirTemp("", "testchain")
+	if err != nil {
+		panic(err)
+	}
+	endpoint := filepath.Join(dir, "geth.ipc")
+	// eth_createAccessList charges the calls without sender, made from the
+	// zero address, at the suggested gas price, so it is funded as on
+	// mainnet
+	alloc := types.GenesisAlloc{
+		deployer:         {Balance: genesisBalance},
+		common.Address{}: {Balance: genesisBalance},
+	}
+	backend := simulated.NewBackend(alloc, func(nc *node.Config, ec *ethconfig.Config) {
+		nc.IPCPath = endpoint
+		ec.Genesis.Timestamp = genesisTime
+	})
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		panic(fmt.Sprintf("cannot connect to the simulated backend: %v", err))
+	}
+	return &Chain{backend: backend, client: client, eth: ethclient.NewClient(client),
+		dir: dir, pending: make(map[common.Address]map[common.Hash]common.Hash),
+		balances:      make(map[common.Address]*big.Int),
+		created:       make(map[common.Address]bool),
+		contracts:     make(map[common.Address]*contract),
+		initData:      make(map[common.Address][]byte),
+		tokenBalances: make(map[common.Address]map[common.Address]*big.Int),
+		balancesSlots: make(map[common.Address]int64),
+		layouts:       make(map[common.Address]helpers.MapLayout),
+		votesTokens:   make(map[common.Address]votesToken)}
+}
+
+// Client returns a web3 RPC client connected to the chain
+func (c *Chain) Client() *rpc.Client {
+	return c.client
+}
+
+// Close stops the simulated backend
+func (c *Chain) Close() {
+	c.client.Close()
+	if err := c.backend.Close(); err != nil {
+		panic(err)
+	}
+	os.RemoveAll(c.dir)
+}
+
+// Commit seals a new block with the pending transactions and returns its
+// header.  It panics if any of them is not included or fails.
+func (c *Chain) Commit() *types.Header {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.backend.Commit()
+	for _, hash := range c.sent {
+		receipt, err := c.eth.TransactionReceipt(context.Background(), hash)
+		if err != nil {
+			panic(fmt.Sprintf("transaction %x not included: %v", hash, err))
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			panic(fmt.Sprintf("transaction %x failed", hash))
+		}
+	}
+	c.sent = nil
+	c.pending = make(map[common.Address]map[common.Hash]common.Hash)
+	c.balances = make(map[common.Address]*big.Int)
+	c.created = make(map[common.Address]bool)
+	c.tokenBalances = make(map[common.Address]map[common.Address]*big.Int)
+	return c.header(nil)
+}
+
+// Head returns the header of the last sealed block
+func (c *Chain) Head() *types.Header {
+	return c.header(nil)
+}
+
+// Finalized returns the header of the finalized block.  The simulated
+// beacon finalizes the blocks every 32 blocks, so it is the genesis block
+// until the block 32 is sealed.
+func (c *Chain) Finalized() *types.Header {
+	return c.header(big.NewInt(int64(rpc.FinalizedBlockNumber)))
+}
+
+// Header returns the header of the block number, or nil if it does not exist
+func (c *Chain) Header(number uint64) *types.Header {
+	return c.header(new(big.Int).SetUint64(number))
+}
+
+// header returns the header of the block number, the last sealed block if
+// number is nil, or nil if it does not exist
+func (c *Chain) header(number *big.Int) *types.Header {
+	h, err := c.eth.HeaderByNumber(context.Background(), number)
+	if err != nil {
+		return nil
+	}
+	return h
+}
+
+// pendingTime returns the timestamp of the block being built
+func (c *Chain) pendingTime() uint64 {
+	return c.header(nil).Time + 1
+}
+
+// pendingNumber returns the number of the block being built
+func (c *Chain) pendingNumber() *big.Int {
+	return new(big.Int).Add(c.header(nil).Number, big.NewInt(1))
+}
+
+// send sends a transaction of the deployer to the block being built, and
+// returns the address of the created contract if to is nil.
+func (c *Chain) send(to *common.Address, value *big.Int, data []byte) common.Address {
+	gas := uint64(callGas)
+	if to == nil {
+		gas = deployGas
+	}
+	tx, err := types.SignNewTx(deployerKey, types.LatestSignerForChainID(chainID),
+		&types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     c.nonce,
+			GasTipCap: big.NewInt(params.GWei),
+			GasFeeCap: big.NewInt(100 * params.GWei),
+			Gas:       gas,
+			To:        to,
+			Value:     value,
+			Data:      data,
+		})
+	if err != nil {
+		panic(err)
+	}
+	if err := c.eth.SendTransaction(context.Background(), tx); err != nil {
+		panic(fmt.Sprintf("cannot send transaction: %v", err))
+	}
+	c.sent = append(c.sent, tx.Hash())
+	c.nonce++
+	return crypto.CreateAddress(deployer, tx.Nonce())
+}
+
+// deploy creates a new contract with code
+func (c *Chain) deploy(code []byte) common.Address {
+	return c.send(nil, nil, initCode(code))
+}
+
+// getState returns the storage slot key of a contract on the block being
+// built
+func (c *Chain) getState(account common.Address, key common.Hash) common.Hash {
+	if value, ok := c.pending[account][key]; ok {
+		return value
+	}
+	value, err := c.eth.StorageAt(context.Background(), account, key, nil)
+	if err != nil {
+		panic(fmt.Sprintf("cannot read storage: %v", err))
+	}
+	return common.BytesToHash(value)
+}
+
+// setState sets the storage slot key of a contract deployed on the chain
+func (c *Chain) setState(account common.Address, key, value common.Hash) {
+	if c.pending[account] == nil {
+		c.pending[account] = make(map[common.Hash]common.Hash)
+	}
+	c.pending[account][key] = value
+	c.send(&account, nil, storeData(key, value))
+}
+
+// emit emits an event of a contract deployed on the chain
+func (c *Chain) emit(account common.Address, topics []common.Hash, data []byte) {
+	c.send(&account, nil, logData(topics, data))
+}
+
+// SetBalance sets the ether balance of an account without code.  The
+// deployer sends the ether, so the balance can only be increased.
+func (c *Chain) SetBalance(account common.Address, balance *big.Int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	current, ok := c.balances[account]
+	if !ok {
+		var err error
+		current, err = c.eth.BalanceAt(context.Background(), account, nil)
+		if err != nil {
+			panic(fmt.Sprintf("cannot read balance: %v", err))
+		}
+	}
+	value := new(big.Int).Sub(balance, current)
+	if value.Sign() < 0 {
+		panic(fmt.Sprintf("cannot decrease the balance of %s", account.Hex()))
+	}
+	c.balances[account] = balance
+	c.send(&account, value, nil)
+}
+
+// SetStorage sets a storage slot of a contract deployed on the chain as a
+// minimal EVM program
+func (c *Chain) SetStorage(account common.Address, key, value common.Hash) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.setState(account, key, value)
+}
+
+// DeployERC20 deploys the ierc20/ERC20.sol token, which balances map is at
+// ERC20BalancesSlot.
+func (c *Chain) DeployERC20(name, symbol string, decimals uint8) common.Address {
+	return c.DeployERC20AtSlot(name, symbol, decimals, ERC20BalancesSlot)
+}
+
+// DeployERC20AtSlot deploys the ierc20/ERC20.sol token with its balances map
+// at balancesSlot, as if it was declared after other state variables.  The
+// balances map can be at the index slots 0, 3, 40 and 60.
+func (c *Chain) DeployERC20AtSlot(name, symbol string, decimals uint8,
+	balancesSlot int64) common.Address {
+	ct := testERC20
+	if balancesSlot != ERC20BalancesSlot {
+		var ok bool
+		if ct, ok = testERC20AtSlot[balancesSlot]; !ok {
+			panic(fmt.Sprintf("no ERC20 token with its balances map at slot %d",
+				balancesSlot))
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	addr := c.create(ct, name, symbol, decimals)
+	c.contracts[addr] = ct
+	c.initData[addr] = ct.pack("initialize", decimals)
+	return addr
+}
+
+// DeployERC20WithLayout deploys a map based ERC20 token which balances map is
+// at balancesSlot and follows layout.  The helpers.LayoutVyper tokens are
+// minimal EVM programs reading their balances as a Vyper token does.
+func (c *Chain) DeployERC20WithLayout(name, symbol string, decimals uint8,
+	balancesSlot int64, layout helpers.MapLayout) common.Address {
+	if layout == helpers.LayoutSolidity {
+		return c.DeployERC20AtSlot(name, symbol, decimals, balancesSlot)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	addr := c.deploy(vyperERC20Code(name, symbol, decimals, balancesSlot))
+	c.balancesSlots[addr] = balancesSlot
+	c.layouts[addr] = layout
+	return addr
+}
+
+// DeployStandardToken deploys the ConsenSys StandardToken, which does not
+// implement the optional name, symbol and decimals methods, and which
+// balances map is at StandardTokenBalancesSlot.
+func (c *Chain) DeployStandardToken() common.Address {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	addr := c.create(testStandardToken)
+	c.contracts[addr] = testStandardToken
+	return addr
+}
+
+// DeployProxy deploys an EIP-1967 proxy delegating to implementation.  The
+// token balances of the proxy are set with the same methods as for the
+// implementation.
+func (c *Chain) DeployProxy(implementation common.Address) common.Address {
+	return c.DeployProxyAtSlot(implementationSlot, implementation)
+}
+
+// DeployProxyAtSlot deploys a proxy delegating to the implementation address
+// stored at slot, such as the EIP-1822 or the legacy OpenZeppelin slots.
+func (c *Chain) DeployProxyAtSlot(slot common.Hash,
+	implementation common.Address) common.Address {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	addr := c.create(testProxy, slot, implementation, c.initDataOf(implementation))
+	c.inherit(addr, implementation)
+	return addr
+}
+
+// DeployBeaconProxy deploys an EIP-1967 beacon proxy and its beacon, which
+// returns implementation.  Returns the proxy and the beacon addresses.
+func (c *Chain) DeployBeaconProxy(
+	implementation common.Address) (common.Address, common.Address) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	beacon := c.create(testBeacon, implementation)
+	addr := c.create(testBeaconProxy, beacon, c.initDataOf(implementation))
+	c.inherit(addr, implementation)
+	return addr, beacon
+}
+
+// initDataOf returns the call setting up the state of a proxy delegating to
+// implementation, which is empty for the minimal EVM programs.
+func (c *Chain) initDataOf(implementation common.Address) []byte {
+	if data, ok := c.initData[implementation]; ok {
+		return data
+	}
+	return []byte{}
+}
+
+// inherit sets the token contract, or the balances slot, of a proxy to the
+// one of its implementation
+func (c *Chain) inherit(proxy, implementation common.Address) {
+	if ct, ok := c.contracts[implementation]; ok {
+		c.contracts[proxy] = ct
+	}
+	if slot, ok := c.balancesSlots[implementation]; ok {
+		c.balancesSlots[proxy] = slot
+		c.layouts[proxy] = c.layouts[implementation]
+	}
+}
+
+// SetERC20Balance sets the balance of holder on an ERC20 token deployed with
+// one of the DeployERC20 methods or DeployStandardToken, or on a proxy
+// delegating to it.  The Solidity tokens mint or burn the difference with
+// the previous balance, the Vyper ones write it and emit the mint or burn
+// Transfer event.
+func (c *Chain) SetERC20Balance(token, holder common.Address, balance *big.Int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if ct, ok := c.contracts[token]; ok {
+		c.setTokenBalance(ct, token, holder, balance, "mint", "burn")
+		return
+	}
+	slot := common.Hash(c.layouts[token].MapSlot(holder, int(c.balancesSlots[token])))
+	supplySlot := common.BigToHash(big.NewInt(ERC20TotalSupplySlot))
+	previous := c.getState(token, slot).Big()
+	supply := c.getState(token, supplySlot).Big()
+	supply.Sub(supply, previous)
+	supply.Add(supply, balance)
+	c.emitTransfer(token, holder, previous, balance)
+	c.setState(token, slot, common.BigToHash(balance))
+	c.setState(token, supplySlot, common.BigToHash(supply))
+}
+
+// DeployERC721 deploys an ERC721 token with the storage layout of the
+// OpenZeppelin ERC721.sol, which owners and balances maps are at
+// ERC721OwnersSlot and ERC721BalancesSlot.
+func (c *Chain) DeployERC721(name, symbol string) common.Address {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.deploy(erc721Code(name, symbol))
+}
+
+// SetERC721Owner sets the owner of tokenID on an ERC721 token deployed with
+// DeployERC721, updating the balances and emitting a Transfer event.  The
+// token is burned if owner is the zero address.
+func (c *Chain) SetERC721Owner(token common.Address, tokenID *big.Int, owner common.Address) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ownerSlot := common.Hash(helpers.GetUintMapSlot(tokenID, ERC721OwnersSlot))
+	previous := common.BytesToAddress(c.getState(token, ownerSlot).Bytes())
+	if previous == owner {
+		return
+	}
+	supplySlot := common.BigToHash(big.NewInt(ERC721TotalSupplySlot))
+	supply := c.getState(token, supplySlot).Big()
+	for _, h := range []struct {
+		holder common.Address
+		delta  int64
+	}{{previous, -1}, {owner, 1}} {
+		if h.holder == (common.Address{}) {
+			supply.Sub(supply, big.NewInt(h.delta))
+			continue
+		}
+		slot := common.Hash(helpers.GetMapSlot(h.holder, ERC721BalancesSlot))
+		balance := c.getState(token, slot).Big()
+		c.setState(token, slot, common.BigToHash(balance.Add(balance,
+			big.NewInt(h.delta))))
+	}
+	c.setState(token, supplySlot, common.BigToHash(supply))
+	c.setState(token, ownerSlot, common.BytesToHash(owner[:]))
+	c.emit(token, []common.Hash{
+		transferTopic,
+		common.BytesToHash(previous[:]),
+		common.BytesToHash(owner[:]),
+		common.BigToHash(tokenID),
+	}, nil)
+}
+
+// DeployERC1155 deploys an ERC1155 token with the storage layout of the
+// OpenZeppelin ERC1155.sol, which balances map is at ERC1155BalancesSlot.
+func (c *Chain) DeployERC1155() common.Address {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.deploy(erc1155Code())
+}
+
+// SetERC1155Balance sets the balance of the token id of holder on an ERC1155
+// token deployed with DeployERC1155, emitting a mint or burn TransferSingle
+// event.
+func (c *Chain) SetERC1155Balance(token common.Address, id *big.Int, holder common.Address,
+	balance *big.Int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	slot := common.Hash(helpers.GetNestedMapSlot(id, holder, ERC1155BalancesSlot))
+	previous := c.getState(token, slot).Big()
+	from, to := common.Address{}, holder
+	amount := new(big.Int).Sub(balance, previous)
+	if amount.Sign() < 0 {
+		from, to = holder, common.Address{}
+		amount.Neg(amount)
+	}
+	c.emit(token, []common.Hash{
+		transferSingleTopic,
+		common.BytesToHash(deployer[:]),
+		common.BytesToHash(from[:]),
+		common.BytesToHash(to[:]),
+	}, append(common.BigToHash(id).Bytes(), common.BigToHash(amount).Bytes()...))
+	c.setState(token, slot, common.BigToHash(balance))
+}
+
+// MintERC1155Batch mints amounts of the token ids to holder on an ERC1155
+// token deployed with DeployERC1155, emitting a TransferBatch event.
+func (c *Chain) MintERC1155Batch(token, holder common.Address, ids, amounts []*big.Int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// The data is the ABI encoding of the ids and amounts arrays
+	n := int64(len(ids))
+	data := append(common.BigToHash(big.NewInt(64)).Bytes(),
+		common.BigToHash(big.NewInt(96+32*n)).Bytes()...)
+	for _, values := range [][]*big.Int{ids, amounts} {
+		data = append(data, common.BigToHash(big.NewInt(n)).Bytes()...)
+		for _, value := range values {
+			data = append(data, common.BigToHash(value).Bytes()...)
+		}
+	}
+	c.emit(token, []common.Hash{
+		transferBatchTopic,
+		common.BytesToHash(deployer[:]),
+		{},
+		common.BytesToHash(holder[:]),
+	}, data)
+	for i, id := range ids {
+		slot := common.Hash(helpers.GetNestedMapSlot(id, holder, ERC1155BalancesSlot))
+		balance := new(big.Int).Add(c.getState(token, slot).Big(), amounts[i])
+		c.setState(token, slot, common.BigToHash(balance))
+	}
+}
+
+// DeployERC20Votes deploys an OpenZeppelin ERC20Votes token, which
+// checkpoints keep the key in the lower keyBytes: 4 for the uint32 block
+// numbers of OpenZeppelin 4, 6 for the uint48 timepoints of OpenZeppelin 5.
+// The checkpoints keys are timestamps if clockMode is ClockModeTimestamp.  If
+// clockMode is empty the token does not implement CLOCK_MODE.
+func (c *Chain) DeployERC20Votes(name, symbol string, keyBytes int,
+	clockMode string) common.Address {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	addr := c.deploy(erc20VotesCode(name, symbol, keyBytes, clockMode))
+	c.votesTokens[addr] = votesToken{keyBytes: keyBytes,
+		timestamp: clockMode == ClockModeTimestamp}
+	return addr
+}
+
+// SetERC20Votes sets the votes of delegate on an ERC20Votes token deployed
+// with DeployERC20Votes from the next block on, adding a checkpoint as
+// ERC20Votes.sol does, and emitting a DelegateVotesChanged event.
+func (c *Chain) SetERC20Votes(token, delegate common.Address, votes *big.Int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	vt := c.votesTokens[token]
+	key := c.pendingNumber()
+	if vt.timestamp {
+		key = new(big.Int).SetUint64(c.pendingTime())
+	}
+	previous := c.updateCheckpoints(token,
+		helpers.GetMapSlot(delegate, ERC20VotesCheckpointsSlot), vt.keyBytes, key, votes)
+	c.emit(token, []common.Hash{delegateVotesChangedTopic, common.BytesToHash(delegate[:])},
+		append(common.BigToHash(previous).Bytes(), common.BigToHash(votes).Bytes()...))
+}
+
+// DeployComp deploys a COMP token, which keeps the checkpoints of the votes
+// of each delegate on a map of maps at CompCheckpointsSlot, counted by the
+// numCheckpoints map at CompNumCheckpointsSlot.
+func (c *Chain) DeployComp(name, symbol string) common.Address {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.deploy(compCode(name, symbol))
+}
+
+// SetCompVotes sets the votes of delegate on a COMP token deployed with
+// DeployComp from the next block on, adding a checkpoint as Comp.sol does, and
+// emitting a DelegateVotesChanged event.
+func (c *Chain) SetCompVotes(token, delegate common.Address, votes *big.Int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	numSlot := common.Hash(helpers.GetMapSlot(delegate, CompNumCheckpointsSlot))
+	n := c.getState(token, numSlot).Big().Int64()
+	block := c.pendingNumber()
+	previous := new(big.Int)
+	if n > 0 {
+		last := c.getState(token, helpers.GetAddressNestedMapSlot(delegate,
+			big.NewInt(n-1), CompCheckpointsSlot))
+		previous.SetBytes(last[16:28])
+		// A checkpoint of the same block is overwritten
+		if new(big.Int).SetBytes(last[28:]).Cmp(block) == 0 {
+			n--
+		}
+	}
+	var checkpoint common.Hash
+	votes.FillBytes(checkpoint[16:28])
+	block.FillBytes(checkpoint[28:])
+	c.setState(token, helpers.GetAddressNestedMapSlot(delegate, big.NewInt(n),
+		CompCheckpointsSlot), checkpoint)
+	c.setState(token, numSlot, common.BigToHash(big.NewInt(n+1)))
+	c.emit(token, []common.Hash{delegateVotesChangedTopic, common.BytesToHash(delegate[:])},
+		append(common.BigToHash(previous).Bytes(), common.BigToHash(votes).Bytes()...))
+}
+
+// DeployMinime deploys a MiniMeToken.sol token without parent, which
+// checkpoints map is at MinimeBalancesSlot.
+func (c *Chain) DeployMinime(name, symbol string, decimals uint8) common.Address {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	addr := c.create(testMiniMe, name, decimals, symbol)
+	c.contracts[addr] = testMiniMe
+	c.initData[addr] = testMiniMe.pack("initialize", name, decimals, symbol)
+	return addr
+}
+
+// SetMinimeBalance sets the balance of holder on a MiniMe token deployed
+// with DeployMinime, or on a proxy delegating to it, from the next block on.
+// The token generates or destroys the difference with the previous balance,
+// adding a checkpoint.
+func (c *Chain) SetMinimeBalance(token, holder common.Address, balance *big.Int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.setTokenBalance(testMiniMe, token, holder, balance, "generateTokens", "destroyTokens")
+}
+
+// emitTransfer adds the Transfer event minting (or burning) the difference
+// between the previous and the new balance of holder
+func (c *Chain) emitTransfer(token, holder common.Address, previous, balance *big.Int) {
+	from, to := common.Address{}, holder
+	amount := new(big.Int).Sub(balance, previous)
+	if amount.Sign() < 0 {
+		from, to = holder, common.Address{}
+		amount.Neg(amount)
+	}
+	c.emit(token, []common.Hash{
+		transferTopic,
+		common.BytesToHash(from[:]),
+		common.BytesToHash(to[:]),
+	}, common.BigToHash(amount).Bytes())
+}
+
+// lastCheckpoint returns the value of the last checkpoint of the array
+// at arraySlot, which checkpoints keep their key in the lower keyBytes.
+func (c *Chain) lastCheckpoint(token common.Address, arraySlot common.Hash,
+	keyBytes int) *big.Int {
+	length := c.getState(token, arraySlot).Big().Int64()
+	if length == 0 {
+		return new(big.Int)
+	}
+	value := c.getState(token, checkpointSlot(arraySlot, length-1))
+	return new(big.Int).SetBytes(value[:common.HashLength-keyBytes])
+}
+
+// updateCheckpoints sets value from key (a block number or a timestamp) on
+// the checkpoint array at arraySlot, and returns the previous value.  The
+// checkpoints are packed in a single slot, with the key in the lower
+// keyBytes and the value in the upper bytes.
+func (c *Chain) updateCheckpoints(token common.Address, arraySlot common.Hash, keyBytes int,
+	key, value *big.Int) *big.Int {
+	previous := c.lastCheckpoint(token, arraySlot, keyBytes)
+	length := c.getState(token, arraySlot).Big().Int64()
+	split := common.HashLength - keyBytes
+	// A checkpoint of the same key is overwritten
+	if length > 0 {
+		last := c.getState(token, checkpointSlot(arraySlot, length-1))
+		if new(big.Int).SetBytes(last[split:]).Cmp(key) == 0 {
+			length--
+		}
+	}
+	var checkpoint common.Hash
+	value.FillBytes(checkpoint[:split])
+	key.FillBytes(checkpoint[split:])
+	c.setState(token, checkpointSlot(arraySlot, length), checkpoint)
+	c.setState(token, arraySlot, common.BigToHash(big.NewInt(length+1)))
+	return previous
+}
+
+// checkpointSlot returns the storage slot of the element index of the array
+// at arraySlot
+func checkpointSlot(arraySlot common.Hash, index int64) common.Hash {
+	base := new(big.Int).SetBytes(crypto.Keccak256(arraySlot[:]))
+	return common.BigToHash(base.Add(base, big.NewInt(index)))
+}
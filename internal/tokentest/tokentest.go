@@ -0,0 +1,149 @@
+// Package tokentest provides the table driven scripts and proof checks shared
+// by the tests of the token packages: Play sets the state of the token on
+// the blocks of the test chain, and Checker checks the proofs of the
+// resulting balances, and that they do not prove other ones.
+package tokentest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/testchain"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+)
+
+var (
+	// Alice is the first holder of the test scripts
+	Alice = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	// Bob is the second holder of the test scripts
+	Bob = common.HexToAddress("0x1000000000000000000000000000000000000002")
+)
+
+// Other returns Bob for Alice, and Alice for any other holder
+func Other(holder common.Address) common.Address {
+	if holder == Alice {
+		return Bob
+	}
+	return Alice
+}
+
+// Step is a change of the token state on a block
+type Step struct {
+	// Block is the number of the block on which the change is done
+	Block uint64
+	// Holder is the holder, owner or delegate changed
+	Holder common.Address
+	// ID is the token id, for the tokens which have them, such as the one
+	// transferred to Holder
+	ID int64
+	// Value is the new balance or votes of Holder
+	Value int64
+}
+
+// Play applies the steps with set on their blocks, committing the blocks up
+// to the one of the last step.  The steps must be sorted by block, from the
+// pending block on.
+func Play(chain *testchain.Chain, steps []Step, set func(s Step)) {
+	for _, s := range steps {
+		for chain.Head().Number.Uint64()+1 < s.Block {
+			chain.Commit()
+		}
+		if pending := chain.Head().Number.Uint64() + 1; s.Block != pending {
+			panic(fmt.Sprintf("step of block %d on pending block %d", s.Block, pending))
+		}
+		set(s)
+	}
+	chain.Commit()
+}
+
+// Case is a balance of a holder at a block
+type Case struct {
+	Holder  common.Address
+	Block   uint64
+	Balance int64
+}
+
+// Token gets and verifies the proofs of the balances of a token, as
+// token.Token does
+type Token interface {
+	GetProof(ctx context.Context, holder common.Address, ref source.BlockRef,
+		indexSlot int) (*ethstorageproof.StorageProof, error)
+	VerifyProof(holder common.Address, root common.Hash,
+		proofs []ethstorageproof.StorageResult, indexSlot int, targetBalance,
+		targetBlock *big.Int) error
+}
+
+// Checker checks the proofs of the balances of Token at index slot Slot
+type Checker struct {
+	Chain *testchain.Chain
+	Token Token
+	Slot  int
+	// Proofs returns the root and the proofs of sp given to VerifyProof, the
+	// storage hash and the storage proofs if nil
+	Proofs func(c *qt.C, sp *ethstorageproof.StorageProof) (common.Hash,
+		[]ethstorageproof.StorageResult)
+	// Timepoint returns the target block given to VerifyProof for the block
+	// of header, its number if nil
+	Timepoint func(header *types.Header) *big.Int
+	// Amount returns the balance given to VerifyProof for the balance of a
+	// case, such as in units of the token decimals, the balance itself if nil
+	Amount func(balance int64) *big.Int
+	// ErrOtherHolder is the error of verifying a proof for the other holder,
+	// ethstorageproof.ErrBadKey if nil
+	ErrOtherHolder error
+	// Extra checks the proof sp of the case tc further, such as with the
+	// wrong token id or index slot
+	Extra func(c *qt.C, tc Case, sp *ethstorageproof.StorageProof)
+}
+
+// Check checks, for each of the cases, that the proof returned by GetProof
+// is valid for the block, and that it proves the balance of the holder, but
+// neither another balance nor the same balance of the other holder.
+func (ch *Checker) Check(c *qt.C, cases ...Case) {
+	ctx := context.Background()
+	for _, tc := range cases {
+		comment := qt.Commentf("%s at block %d", tc.Holder.Hex(), tc.Block)
+		header := ch.Chain.Header(tc.Block)
+		sp, err := ch.Token.GetProof(ctx, tc.Holder, source.BlockNumber(header.Number), ch.Slot)
+		c.Assert(err, qt.IsNil, comment)
+		c.Assert(sp.Height.Uint64(), qt.Equals, tc.Block, comment)
+		ok, err := ethstorageproof.VerifyEIP1186WithBlockHash(sp, header.Hash())
+		c.Assert(err, qt.IsNil, comment)
+		c.Assert(ok, qt.IsTrue, comment)
+
+		root, proofs := sp.StorageHash, sp.StorageProof
+		if ch.Proofs != nil {
+			root, proofs = ch.Proofs(c, sp)
+		}
+		timepoint := header.Number
+		if ch.Timepoint != nil {
+			timepoint = ch.Timepoint(header)
+		}
+		balance := big.NewInt(tc.Balance)
+		if ch.Amount != nil {
+			balance = ch.Amount(tc.Balance)
+		}
+		c.Assert(ch.Token.VerifyProof(tc.Holder, root, proofs, ch.Slot, balance, timepoint),
+			qt.IsNil, comment)
+		err = ch.Token.VerifyProof(tc.Holder, root, proofs, ch.Slot,
+			new(big.Int).Add(balance, big.NewInt(1)), timepoint)
+		c.Assert(errors.Is(err, ethstorageproof.ErrValueMismatch), qt.IsTrue,
+			qt.Commentf("%s at block %d: %v", tc.Holder.Hex(), tc.Block, err))
+		err = ch.Token.VerifyProof(Other(tc.Holder), root, proofs, ch.Slot, balance, timepoint)
+		errOtherHolder := ch.ErrOtherHolder
+		if errOtherHolder == nil {
+			errOtherHolder = ethstorageproof.ErrBadKey
+		}
+		c.Assert(errors.Is(err, errOtherHolder), qt.IsTrue,
+			qt.Commentf("%s at block %d: %v", tc.Holder.Hex(), tc.Block, err))
+		if ch.Extra != nil {
+			ch.Extra(c, tc, sp)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package prover generates EIP1186 storage proofs from a local go-ethereum
+// state database, without using the eth_getProof method of a web3 endpoint.
+package prover
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+)
+
+// Prover generates storage proofs from the state with a given state root.
+// The proofs are the same returned by the eth_getProof method of a node
+// holding that state.
+type Prover struct {
+	db     state.Database
+	root   common.Hash
+	header *ethstorageproof.BlockHeader
+}
+
+// New creates a new Prover for the state with root found in db.  The state of
+// a geth datadir can be opened with state.NewDatabaseWithConfig, and the one
+// of a committed state.StateDB is available with its Database method.
+func New(db state.Database, root common.Hash) (*Prover, error) {
+	if _, err := db.OpenTrie(root); err != nil {
+		return nil, fmt.Errorf("cannot open state %x: %w", root, err)
+	}
+	return &Prover{db: db, root: root}, nil
+}
+
+// NewWithHeader creates a new Prover for the state of the block with header,
+// so the generated proofs include the block header and height, which allows
+// verifying them against the block hash.
+func NewWithHeader(db state.Database, header *ethstorageproof.BlockHeader) (*Prover, error) {
+	p, err := New(db, header.Root)
+	if err != nil {
+		return nil, err
+	}
+	p.header = header
+	return p, nil
+}
+
+// Root returns the state root the proofs are generated for
+func (p *Prover) Root() common.Hash {
+	return p.root
+}
+
+// GetProof returns the account proof of address and the storage proofs of
+// keys, as the eth_getProof method does.  Keys of non existing slots get a
+// proof of non existence with an empty value.
+func (p *Prover) GetProof(address common.Address,
+	keys [][]byte) (*ethstorageproof.StorageProof, error) {
+	tr, err := p.db.OpenTrie(p.root)
+	if err != nil {
+		return nil, err
+	}
+	var accountProof proofList
+	if err := tr.Prove(crypto.Keccak256(address.Bytes()), &accountProof); err != nil {
+		return nil, fmt.Errorf("cannot prove account %x: %w", address, err)
+	}
+	acc, err := tr.GetAccount(address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get account %x: %w", address, err)
+	}
+	sp := &ethstorageproof.StorageProof{
+		Address:      address,
+		Balance:      (*hexutil.Big)(new(big.Int)),
+		StateRoot:    p.root,
+		AccountProof: ethstorageproof.SliceData(accountProof),
+		StorageProof: make([]ethstorageproof.StorageResult, len(keys)),
+	}
+	if p.header != nil {
+		sp.Height = p.header.Number
+		sp.BlockHash = p.header.Hash()
+		sp.Header = p.header
+	}
+	if acc != nil {
+		sp.Balance = (*hexutil.Big)(acc.Balance.ToBig())
+		sp.CodeHash = common.BytesToHash(acc.CodeHash)
+		sp.Nonce = hexutil.Uint64(acc.Nonce)
+		sp.StorageHash = acc.Root
+	}
+
+	var st state.Trie
+	if acc != nil && acc.Root != types.EmptyRootHash {
+		if st, err = p.db.OpenStorageTrie(p.root, address, acc.Root, tr); err != nil {
+			return nil, fmt.Errorf("cannot open storage of %x: %w", address, err)
+		}
+	}
+	for i, key := range keys {
+		if len(key) > common.HashLength {
+			return nil, fmt.Errorf("%w: key %x longer than 32 bytes",
+				ethstorageproof.ErrBadKey, key)
+		}
+		sr := ethstorageproof.StorageResult{
+			Key:   common.CopyBytes(key),
+			Value: ethstorageproof.QuantityBytes{},
+			Proof: ethstorageproof.SliceData{},
+		}
+		if st != nil {
+			if sr.Proof, sr.Value, err = proveStorage(st, key); err != nil {
+				return nil, fmt.Errorf("cannot prove key %x: %w", key, err)
+			}
+		}
+		sp.StorageProof[i] = sr
+	}
+	return sp, nil
+}
+
+// proveStorage returns the proof and the value of key in the storage trie st
+func proveStorage(st state.Trie, key []byte) (ethstorageproof.SliceData,
+	ethstorageproof.QuantityBytes, error) {
+	slot := common.LeftPadBytes(key, common.HashLength)
+	var proof proofList
+	if err := st.Prove(crypto.Keccak256(slot), &proof); err != nil {
+		return nil, nil, err
+	}
+	enc, err := st.GetStorage(common.Address{}, slot)
+	if err != nil {
+		return nil, nil, err
+	}
+	// GetStorage returns the value without its RLP encoding
+	value := ethstorageproof.QuantityBytes(common.CopyBytes(enc))
+	if value == nil {
+		value = ethstorageproof.QuantityBytes{}
+	}
+	return ethstorageproof.SliceData(proof), value, nil
+}
+
+// proofList is an ethdb.KeyValueWriter keeping the trie nodes in the order
+// they are written, which is from the root to the leaf.
+type proofList [][]byte
+
+// Put appends the node to the list
+func (n *proofList) Put(key []byte, value []byte) error {
+	*n = append(*n, common.CopyBytes(value))
+	return nil
+}
+
+// Delete is not supported
+func (n *proofList) Delete(key []byte) error {
+	return fmt.Errorf("delete not supported")
+}
@@ -0,0 +1,137 @@
+package prover
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	qt "github.com/frankban/quicktest"
+	"github.com/holiman/uint256"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/token/mapbased"
+)
+
+var (
+	tokenAddr = common.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7")
+	holders   = []common.Address{
+		common.HexToAddress("0x5041ed759dd4afc3a72b8192c143f72f4724081a"),
+		common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		common.HexToAddress("0x00000000219ab540356cbb839cbe05303d7705fa"),
+	}
+)
+
+const indexSlot = 2
+
+// testState builds a state with a map based token holding balances for
+// holders and commits it to an in memory database.
+func testState(c *qt.C) (state.Database, common.Hash) {
+	db := state.NewDatabase(rawdb.NewMemoryDatabase())
+	sdb, err := state.New(types.EmptyRootHash, db, nil)
+	c.Assert(err, qt.IsNil)
+	sdb.SetNonce(tokenAddr, 1)
+	sdb.SetBalance(tokenAddr, uint256.NewInt(1000), tracing.BalanceChangeUnspecified)
+	sdb.SetCode(tokenAddr, []byte{0x60, 0x00, 0x60, 0x00, 0xf3})
+	for i, h := range holders {
+		slot := helpers.GetMapSlot(h, indexSlot)
+		sdb.SetState(tokenAddr, slot, common.BigToHash(big.NewInt(int64(i+1)*1e9)))
+	}
+	sdb.SetState(tokenAddr, common.Hash{}, common.BigToHash(big.NewInt(42)))
+	root, err := sdb.Commit(0, false)
+	c.Assert(err, qt.IsNil)
+	return db, root
+}
+
+func TestGetProof(t *testing.T) {
+	c := qt.New(t)
+	db, root := testState(c)
+	p, err := New(db, root)
+	c.Assert(err, qt.IsNil)
+
+	var keys [][]byte
+	for _, h := range holders {
+		slot := helpers.GetMapSlot(h, indexSlot)
+		keys = append(keys, slot[:])
+	}
+	missing := helpers.GetMapSlot(common.HexToAddress("0xdead"), indexSlot)
+	keys = append(keys, missing[:], common.Hash{}.Bytes())
+
+	sp, err := p.GetProof(tokenAddr, keys)
+	c.Assert(err, qt.IsNil)
+	c.Assert(sp.StateRoot, qt.Equals, root)
+	c.Assert(sp.Nonce, qt.Equals, hexutil.Uint64(1))
+	c.Assert(sp.Balance.ToInt().Int64(), qt.Equals, int64(1000))
+	c.Assert(sp.CodeHash, qt.Equals, crypto.Keccak256Hash([]byte{0x60, 0x00, 0x60, 0x00, 0xf3}))
+	ok, err := ethstorageproof.VerifyEIP1186(sp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+
+	for i, h := range holders {
+		err := mapbased.VerifyProof(h, sp.StorageHash, sp.StorageProof[i], indexSlot,
+			big.NewInt(int64(i+1)*1e9), nil)
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(sp.StorageProof[3].Value, qt.HasLen, 0)
+	c.Assert(ethstorageproof.VerifyStorageAbsence(&sp.StorageProof[3], sp.StorageHash), qt.IsNil)
+	c.Assert(new(big.Int).SetBytes(sp.StorageProof[4].Value).Int64(), qt.Equals, int64(42))
+
+	// The proof nodes are sorted from the root to the leaf, as eth_getProof does
+	c.Assert(crypto.Keccak256Hash(sp.AccountProof[0]), qt.Equals, root)
+	c.Assert(crypto.Keccak256Hash(sp.StorageProof[0].Proof[0]), qt.Equals, sp.StorageHash)
+
+	// The proof survives a JSON round trip
+	data, err := json.Marshal(sp)
+	c.Assert(err, qt.IsNil)
+	var sp2 ethstorageproof.StorageProof
+	c.Assert(json.Unmarshal(data, &sp2), qt.IsNil)
+	ok, err = ethstorageproof.VerifyEIP1186(&sp2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+
+	// Non existing account
+	sp, err = p.GetProof(common.HexToAddress("0xdead"), keys[:1])
+	c.Assert(err, qt.IsNil)
+	c.Assert(sp.Balance.ToInt().Sign(), qt.Equals, 0)
+	c.Assert(sp.StorageProof[0].Proof, qt.HasLen, 0)
+	ok, err = ethstorageproof.VerifyEIP1186(sp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+
+	// Unknown state
+	_, err = New(db, crypto.Keccak256Hash([]byte("unknown")))
+	c.Assert(err, qt.Not(qt.IsNil))
+}
+
+func TestGetProofWithHeader(t *testing.T) {
+	c := qt.New(t)
+	db, root := testState(c)
+	gh := &types.Header{
+		Root:       root,
+		Difficulty: big.NewInt(0),
+		Number:     big.NewInt(1234),
+		GasLimit:   30_000_000,
+		BaseFee:    big.NewInt(7),
+	}
+	raw, err := rlp.EncodeToBytes(gh)
+	c.Assert(err, qt.IsNil)
+	header, err := ethstorageproof.DecodeBlockHeader(raw)
+	c.Assert(err, qt.IsNil)
+
+	p, err := NewWithHeader(db, header)
+	c.Assert(err, qt.IsNil)
+	slot := helpers.GetMapSlot(holders[0], indexSlot)
+	sp, err := p.GetProof(tokenAddr, [][]byte{slot[:]})
+	c.Assert(err, qt.IsNil)
+	c.Assert(sp.Height.Int64(), qt.Equals, int64(1234))
+	ok, err := ethstorageproof.VerifyEIP1186WithBlockHash(sp, gh.Hash())
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+)
+
+// CacheSize is the number of contract and block pairs which detected proxy is
+// kept by a Cache
+const CacheSize = 1024
+
+// cacheKey identifies the contract at a block
+type cacheKey struct {
+	address   common.Address
+	blockHash common.Hash
+}
+
+// Cache keeps the proxies detected on each contract and block, so the proxy
+// slots are read once for all the proofs of a block.  The zero Cache is ready
+// to use, and it is safe for concurrent use.
+type Cache struct {
+	once    sync.Once
+	proxies *lru.Cache[cacheKey, *Proxy]
+}
+
+// Detect returns the proxy at address on the block with blockHash, as Detect
+// does, detecting it only if it is not cached.
+func (c *Cache) Detect(ctx context.Context, src source.ProofSource, address common.Address,
+	blockHash common.Hash) (*Proxy, error) {
+	c.once.Do(func() { c.proxies = lru.NewCache[cacheKey, *Proxy](CacheSize) })
+	key := cacheKey{address: address, blockHash: blockHash}
+	if p, ok := c.proxies.Get(key); ok {
+		if p == nil {
+			return nil, errNotProxy(address)
+		}
+		return p, nil
+	}
+	p, err := Detect(ctx, src, address, blockHash)
+	if err != nil && !errors.Is(err, ErrNotProxy) {
+		return nil, err
+	}
+	// Contracts which are not proxies are cached as nil
+	c.proxies.Add(key, p)
+	return p, err
+}
+
+// GetProof returns the storage proofs of the keys of the contract at address,
+// as GetProof does, with the proxy detected by c.
+func (c *Cache) GetProof(ctx context.Context, src source.ProofSource, address common.Address,
+	keys [][]byte, ref source.BlockRef) (*ethstorageproof.StorageProof, error) {
+	return getProof(ctx, src, address, keys, ref, c.Detect)
+}
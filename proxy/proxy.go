@@ -0,0 +1,165 @@
+// Package proxy detects upgradeable proxy contracts and proves the storage
+// slot holding the address of their implementation contract.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+)
+
+var (
+	// ErrNotProxy is returned when a contract has none of the proxy slots set
+	ErrNotProxy = errors.New("contract is not a proxy")
+	// ErrUnknownBeacon is returned when the implementation of a beacon is
+	// not at ethstorageproof.BeaconImplementationSlot, so it cannot be proven
+	ErrUnknownBeacon = errors.New("beacon implementation slot unknown")
+)
+
+// implementationSelector is the selector of the implementation() method of
+// the beacon contracts
+var implementationSelector = crypto.Keccak256([]byte("implementation()"))[:4]
+
+// Proxy is a proxy contract, delegating its calls to Implementation
+type Proxy struct {
+	// Address is the proxy contract address
+	Address common.Address
+	// Kind is the proxy kind, one of ethstorageproof.ProxyKinds
+	Kind string
+	// Implementation is the contract which code is run by the proxy
+	Implementation common.Address
+	// Beacon is the beacon contract of beacon proxies, or nil
+	Beacon *common.Address
+}
+
+// Slot returns the storage slot holding the implementation (or beacon)
+// address of the proxy
+func (p *Proxy) Slot() common.Hash {
+	return ethstorageproof.ProxySlots[p.Kind]
+}
+
+// Detect reads the standard proxy slots of the contract at address at the
+// block with blockHash, in the order of ethstorageproof.ProxyKinds, and
+// returns the first proxy found.  The implementation of beacon proxies is
+// read from the ethstorageproof.BeaconImplementationSlot of the beacon, and
+// must be the one returned by its implementation() method, otherwise
+// ErrUnknownBeacon is returned.  Returns ErrNotProxy if none of the slots is
+// set.
+func Detect(ctx context.Context, src source.ProofSource, address common.Address,
+	blockHash common.Hash) (*Proxy, error) {
+	for _, kind := range ethstorageproof.ProxyKinds {
+		slot := ethstorageproof.ProxySlots[kind]
+		value, err := src.StorageAtHash(ctx, address, slot, blockHash)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get %s proxy slot: %w", kind, err)
+		}
+		stored := common.BytesToAddress(value)
+		if stored == (common.Address{}) {
+			continue
+		}
+		p := &Proxy{Address: address, Kind: kind, Implementation: stored}
+		if kind == ethstorageproof.ProxyEIP1967Beacon {
+			p.Beacon = &stored
+			if p.Implementation, err = beaconImplementation(ctx, src, stored,
+				blockHash); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
+	}
+	return nil, errNotProxy(address)
+}
+
+func errNotProxy(address common.Address) error {
+	return fmt.Errorf("%w: %s", ErrNotProxy, address.Hex())
+}
+
+// beaconImplementation returns the implementation of a beacon contract held
+// by its implementation slot, checking it is the one returned by
+// implementation()
+func beaconImplementation(ctx context.Context, src source.ProofSource,
+	beacon common.Address, blockHash common.Hash) (common.Address, error) {
+	out, err := src.CallContractAtHash(ctx,
+		ethereum.CallMsg{To: &beacon, Data: implementationSelector}, blockHash)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("cannot get beacon implementation: %w", err)
+	}
+	if len(out) != common.HashLength {
+		return common.Address{}, fmt.Errorf("invalid beacon implementation %x", out)
+	}
+	value, err := src.StorageAtHash(ctx, beacon, ethstorageproof.BeaconImplementationSlot,
+		blockHash)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("cannot get beacon implementation slot: %w", err)
+	}
+	if !bytes.Equal(common.LeftPadBytes(value, common.HashLength), out) {
+		return common.Address{}, fmt.Errorf("%w: %s", ErrUnknownBeacon, beacon.Hex())
+	}
+	return common.BytesToAddress(value), nil
+}
+
+// GetProof returns the storage proofs of the keys of the contract at address
+// at the block referred by ref, as source.ProofSource does.  If the contract
+// is a proxy, the proof of its implementation slot is requested along with
+// the keys and set as the Implementation of the returned proof, with the
+// proof of the beacon implementation slot for beacon proxies.
+func GetProof(ctx context.Context, src source.ProofSource, address common.Address,
+	keys [][]byte, ref source.BlockRef) (*ethstorageproof.StorageProof, error) {
+	return getProof(ctx, src, address, keys, ref, Detect)
+}
+
+// detectFunc detects the proxy at address, as Detect does
+type detectFunc func(ctx context.Context, src source.ProofSource, address common.Address,
+	blockHash common.Hash) (*Proxy, error)
+
+func getProof(ctx context.Context, src source.ProofSource, address common.Address,
+	keys [][]byte, ref source.BlockRef, detect detectFunc) (*ethstorageproof.StorageProof, error) {
+	// Resolve the block once, so the proxy is detected at the proven block
+	if !ref.IsHash() {
+		header, err := src.BlockHeader(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get block header: %w", err)
+		}
+		ref = source.BlockHash(header.Hash(), ref.RequireCanonical)
+	}
+	p, err := detect(ctx, src, address, ref.Hash)
+	if errors.Is(err, ErrNotProxy) {
+		return src.GetProof(ctx, address, keys, ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	slot := p.Slot()
+	sp, err := src.GetProof(ctx, address, append(keys[:len(keys):len(keys)], slot[:]), ref)
+	if err != nil {
+		return nil, err
+	}
+	if len(sp.StorageProof) != len(keys)+1 {
+		return nil, fmt.Errorf("unexpected number of storage proofs %d", len(sp.StorageProof))
+	}
+	sp.Implementation = &ethstorageproof.ImplementationProof{
+		Kind:           p.Kind,
+		Implementation: p.Implementation,
+		Beacon:         p.Beacon,
+		Slot:           sp.StorageProof[len(keys)],
+	}
+	sp.StorageProof = sp.StorageProof[:len(keys)]
+	if p.Beacon != nil {
+		beaconSlot := ethstorageproof.BeaconImplementationSlot
+		bp, err := src.GetProof(ctx, *p.Beacon, [][]byte{beaconSlot[:]}, ref)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get beacon proof: %w", err)
+		}
+		// The block is the one of the proxy proof
+		bp.Header = nil
+		sp.Implementation.BeaconProof = bp
+	}
+	return sp, nil
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/testchain"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+)
+
+func TestProxy(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	holder := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	chain := testchain.New()
+	defer chain.Close()
+	impl := chain.DeployERC20("Test Token", "TST", 18)
+	beaconProxy, beacon := chain.DeployBeaconProxy(impl)
+	proxies := map[string]common.Address{
+		ethstorageproof.ProxyEIP1967:       chain.DeployProxy(impl),
+		ethstorageproof.ProxyEIP1967Beacon: beaconProxy,
+		ethstorageproof.ProxyEIP1822: chain.DeployProxyAtSlot(
+			ethstorageproof.ProxySlots[ethstorageproof.ProxyEIP1822], impl),
+		ethstorageproof.ProxyOpenZeppelinLegacy: chain.DeployProxyAtSlot(
+			ethstorageproof.ProxySlots[ethstorageproof.ProxyOpenZeppelinLegacy], impl),
+	}
+	chain.Commit()
+	for _, addr := range proxies {
+		chain.SetERC20Balance(addr, holder, big.NewInt(100))
+	}
+	chain.Commit()
+	src := source.NewRPC(chain.Client())
+	head := chain.Head().Hash()
+	key := helpers.GetMapSlot(holder, testchain.ERC20BalancesSlot)
+
+	for kind, addr := range proxies {
+		p, err := Detect(ctx, src, addr, head)
+		c.Assert(err, qt.IsNil)
+		c.Assert(p.Kind, qt.Equals, kind)
+		c.Assert(p.Implementation, qt.Equals, impl)
+		if kind == ethstorageproof.ProxyEIP1967Beacon {
+			c.Assert(*p.Beacon, qt.Equals, beacon)
+		} else {
+			c.Assert(p.Beacon, qt.IsNil)
+		}
+
+		sp, err := GetProof(ctx, src, addr, [][]byte{key[:]}, source.Latest)
+		c.Assert(err, qt.IsNil)
+		c.Assert(sp.StorageProof, qt.HasLen, 1)
+		c.Assert(new(big.Int).SetBytes(sp.StorageProof[0].Value).Int64(), qt.Equals, int64(100))
+		c.Assert(sp.Implementation.Kind, qt.Equals, kind)
+		c.Assert(sp.Implementation.Implementation, qt.Equals, impl)
+		c.Assert(sp.Implementation.BeaconProof != nil, qt.Equals,
+			kind == ethstorageproof.ProxyEIP1967Beacon)
+		ok, err := ethstorageproof.VerifyEIP1186(sp)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ok, qt.IsTrue)
+
+		var perr *ethstorageproof.ProofError
+		if kind == ethstorageproof.ProxyEIP1967Beacon {
+			// The implementation of the beacon is proven too
+			sp.Implementation.Implementation = holder
+			_, err = ethstorageproof.VerifyEIP1186(sp)
+			c.Assert(errors.Is(err, ethstorageproof.ErrValueMismatch), qt.IsTrue)
+			sp.Implementation.Implementation = impl
+			bp := *sp.Implementation.BeaconProof
+			sp.Implementation.BeaconProof = nil
+			_, err = ethstorageproof.VerifyEIP1186(sp)
+			c.Assert(errors.As(err, &perr), qt.IsTrue)
+			c.Assert(perr.Layer, qt.Equals, ethstorageproof.LayerImplementation)
+			bp.StateRoot = common.Hash{1}
+			sp.Implementation.BeaconProof = &bp
+			_, err = ethstorageproof.VerifyEIP1186(sp)
+			c.Assert(errors.Is(err, ethstorageproof.ErrRootMismatch), qt.IsTrue)
+		}
+
+		// The proven address can not be replaced
+		sp.Implementation.Implementation, sp.Implementation.Beacon = holder, nil
+		sp.Implementation.Kind, sp.Implementation.BeaconProof = ethstorageproof.ProxyEIP1967, nil
+		_, err = ethstorageproof.VerifyEIP1186(sp)
+		c.Assert(errors.As(err, &perr), qt.IsTrue, qt.Commentf("%v", err))
+		c.Assert(perr.Layer, qt.Equals, ethstorageproof.LayerImplementation)
+	}
+
+	// Contracts which are not proxies have no implementation proof
+	_, err := Detect(ctx, src, impl, head)
+	c.Assert(errors.Is(err, ErrNotProxy), qt.IsTrue)
+	sp, err := GetProof(ctx, src, impl, [][]byte{key[:]}, source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(sp.StorageProof, qt.HasLen, 1)
+	c.Assert(sp.Implementation, qt.IsNil)
+}
+
+// countingSource counts the storage reads and block headers requested
+type countingSource struct {
+	source.ProofSource
+	reads, headers int
+}
+
+func (s *countingSource) StorageAtHash(ctx context.Context, account common.Address,
+	key common.Hash, blockHash common.Hash) ([]byte, error) {
+	s.reads++
+	return s.ProofSource.StorageAtHash(ctx, account, key, blockHash)
+}
+
+func (s *countingSource) BlockHeader(ctx context.Context,
+	ref source.BlockRef) (*ethstorageproof.BlockHeader, error) {
+	s.headers++
+	return s.ProofSource.BlockHeader(ctx, ref)
+}
+
+func TestCache(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	holder := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	chain := testchain.New()
+	defer chain.Close()
+	impl := chain.DeployERC20("Test Token", "TST", 18)
+	addr := chain.DeployProxy(impl)
+	chain.Commit()
+	chain.SetERC20Balance(addr, holder, big.NewInt(100))
+	chain.Commit()
+	src := &countingSource{ProofSource: source.NewRPC(chain.Client())}
+	head := chain.Head().Hash()
+	key := helpers.GetMapSlot(holder, testchain.ERC20BalancesSlot)
+
+	var cache Cache
+	for _, contract := range []common.Address{addr, impl} {
+		*src = countingSource{ProofSource: src.ProofSource}
+		for i := 0; i < 3; i++ {
+			sp, err := cache.GetProof(ctx, src, contract, [][]byte{key[:]},
+				source.BlockHash(head, false))
+			c.Assert(err, qt.IsNil)
+			c.Assert(sp.Implementation != nil, qt.Equals, contract == addr)
+		}
+		// The slots are read on the first proof only, up to the proxy found
+		reads := 1
+		if contract == impl {
+			reads = len(ethstorageproof.ProxyKinds)
+		}
+		c.Assert(src.reads, qt.Equals, reads)
+		// The block hash is not resolved again
+		c.Assert(src.headers, qt.Equals, 0)
+	}
+
+	// Another block is detected again
+	chain.Commit()
+	*src = countingSource{ProofSource: src.ProofSource}
+	_, err := cache.GetProof(ctx, src, addr, [][]byte{key[:]}, source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(src.reads, qt.Equals, 1)
+	c.Assert(src.headers, qt.Equals, 1)
+}
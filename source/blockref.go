@@ -0,0 +1,120 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+)
+
+// Block tags known by the web3 API
+const (
+	TagLatest    = "latest"
+	TagPending   = "pending"
+	TagSafe      = "safe"
+	TagFinalized = "finalized"
+	TagEarliest  = "earliest"
+)
+
+// BlockRef refers to a block by number, by hash (EIP-1898) or by tag.  The
+// zero value refers to the latest block.
+type BlockRef struct {
+	// Number is the block number, used when Hash and Tag are not set.
+	Number *big.Int
+	// Hash is the block hash.
+	Hash common.Hash
+	// RequireCanonical requires the block with Hash to be in the canonical
+	// chain, instead of any known block.
+	RequireCanonical bool
+	// Tag is one of the block tags (latest, safe, finalized or earliest).
+	Tag string
+}
+
+// ErrPendingBlock is returned for the pending block, which has no hash and
+// no final state to prove
+var ErrPendingBlock = errors.New("the pending block cannot be proven")
+
+var (
+	// Latest refers to the latest block
+	Latest = BlockRef{Tag: TagLatest}
+	// Safe refers to the latest safe head block
+	Safe = BlockRef{Tag: TagSafe}
+	// Finalized refers to the latest finalized block
+	Finalized = BlockRef{Tag: TagFinalized}
+	// Earliest refers to the genesis block
+	Earliest = BlockRef{Tag: TagEarliest}
+)
+
+// BlockNumber returns a BlockRef to the block number.  A nil number refers to
+// the latest block.
+func BlockNumber(number *big.Int) BlockRef {
+	return BlockRef{Number: number}
+}
+
+// BlockHash returns a BlockRef to the block with hash
+func BlockHash(hash common.Hash, requireCanonical bool) BlockRef {
+	return BlockRef{Hash: hash, RequireCanonical: requireCanonical}
+}
+
+// ParseBlockRef parses a block tag, a decimal or hexadecimal block number or
+// a block hash.  Block hashes are required to be canonical.  Returns
+// ErrPendingBlock for the pending tag.
+func ParseBlockRef(s string) (BlockRef, error) {
+	switch s {
+	case TagLatest, TagSafe, TagFinalized, TagEarliest:
+		return BlockRef{Tag: s}, nil
+	case TagPending:
+		return BlockRef{}, ErrPendingBlock
+	}
+	if strings.HasPrefix(s, "0x") && len(s) == 2+2*common.HashLength {
+		hash, err := hexutil.Decode(s)
+		if err != nil {
+			return BlockRef{}, fmt.Errorf("invalid block hash %q: %w", s, err)
+		}
+		return BlockHash(common.BytesToHash(hash), true), nil
+	}
+	number, ok := new(big.Int).SetString(s, 0)
+	if !ok || number.Sign() < 0 {
+		return BlockRef{}, fmt.Errorf("invalid block reference %q", s)
+	}
+	return BlockNumber(number), nil
+}
+
+// IsHash returns true if the BlockRef refers to a block hash
+func (r BlockRef) IsHash() bool {
+	return r.Hash != (common.Hash{})
+}
+
+// IsPending returns true if the BlockRef refers to the pending block, by tag
+// or by the -1 block number
+func (r BlockRef) IsPending() bool {
+	return !r.IsHash() && r.String() == TagPending
+}
+
+// String implements fmt.Stringer
+func (r BlockRef) String() string {
+	switch {
+	case r.IsHash():
+		return r.Hash.Hex()
+	case r.Tag != "":
+		return r.Tag
+	default:
+		return helpers.ToBlockNumArg(r.Number)
+	}
+}
+
+// Arg returns the BlockRef as a web3 block parameter, which is an EIP-1898
+// object for block hashes.
+func (r BlockRef) Arg() interface{} {
+	if r.IsHash() {
+		return map[string]interface{}{
+			"blockHash":        r.Hash,
+			"requireCanonical": r.RequireCanonical,
+		}
+	}
+	return r.String()
+}
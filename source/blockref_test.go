@@ -0,0 +1,43 @@
+package source
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestParseBlockRef(t *testing.T) {
+	c := qt.New(t)
+	hash := common.HexToHash("0x6cc7b8ea5fb5d2fbbb4f1d8ab2ac3b1e8c1ee2e2fb8e3c5c8d9cff0d3f0b1f6a")
+	for _, tc := range []struct {
+		in   string
+		want BlockRef
+		arg  interface{}
+	}{
+		{in: "finalized", want: Finalized, arg: "finalized"},
+		{in: "safe", want: Safe, arg: "safe"},
+		{in: "earliest", want: Earliest, arg: "earliest"},
+		{in: "latest", want: Latest, arg: "latest"},
+		{in: "1234", want: BlockNumber(big.NewInt(1234)), arg: "0x4d2"},
+		{in: "0x4d2", want: BlockNumber(big.NewInt(1234)), arg: "0x4d2"},
+		{in: hash.Hex(), want: BlockHash(hash, true), arg: map[string]interface{}{
+			"blockHash": hash, "requireCanonical": true}},
+	} {
+		ref, err := ParseBlockRef(tc.in)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ref.String(), qt.Equals, tc.want.String())
+		c.Assert(ref.IsHash(), qt.Equals, tc.want.IsHash())
+		c.Assert(ref.Arg(), qt.DeepEquals, tc.arg)
+	}
+	for _, in := range []string{"", "head", "-1", "0x" + hash.Hex()[4:] + "zz"} {
+		_, err := ParseBlockRef(in)
+		c.Assert(err, qt.Not(qt.IsNil), qt.Commentf("%q", in))
+	}
+	_, err := ParseBlockRef("pending")
+	c.Assert(errors.Is(err, ErrPendingBlock), qt.IsTrue)
+	// The zero value is the latest block
+	c.Assert(BlockRef{}.Arg(), qt.Equals, "latest")
+}
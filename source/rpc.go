@@ -0,0 +1,164 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+)
+
+// RPC is a ProofSource using a web3 endpoint
+type RPC struct {
+	*ethclient.Client
+	RPCCli *rpc.Client
+}
+
+var (
+	_ ProofSource   = (*RPC)(nil)
+	_ StorageTracer = (*RPC)(nil)
+)
+
+// accessListResult is the result of eth_createAccessList
+type accessListResult struct {
+	AccessList types.AccessList `json:"accessList"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// prestateAccount is an account of the debug_traceCall prestateTracer result
+type prestateAccount struct {
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// NewRPC creates a new ProofSource on top of a web3 RPC client
+func NewRPC(rpcCli *rpc.Client) *RPC {
+	return &RPC{Client: ethclient.NewClient(rpcCli), RPCCli: rpcCli}
+}
+
+// GetProof calls the eth_getProof web3 method.  The block header is fetched
+// first and the proof is requested by its hash, so the proof refers to the
+// same block even if ref is a tag and the head advances.
+func (s *RPC) GetProof(ctx context.Context, account common.Address, keys [][]byte,
+	ref BlockRef) (*ethstorageproof.StorageProof, error) {
+	header, err := s.BlockHeader(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	blockHash := header.Hash()
+	var resp ethstorageproof.StorageProof
+	if err := s.RPCCli.CallContext(
+		ctx,
+		&resp,
+		"eth_getProof",
+		fmt.Sprintf("0x%x", account),
+		ethstorageproof.SliceData(keys),
+		BlockHash(blockHash, ref.RequireCanonical).Arg(),
+	); err != nil {
+		return nil, err
+	}
+	resp.StateRoot = header.Root
+	resp.Height = header.Number
+	resp.BlockHash = blockHash
+	resp.Header = header
+	return &resp, nil
+}
+
+// BlockHeader returns the header of a block as returned by the
+// eth_getBlockByNumber or eth_getBlockByHash web3 methods, so its block hash
+// can be verified.  Returns ErrPendingBlock for the pending block.
+func (s *RPC) BlockHeader(ctx context.Context,
+	ref BlockRef) (*ethstorageproof.BlockHeader, error) {
+	if ref.IsPending() {
+		return nil, ErrPendingBlock
+	}
+	if !ref.IsHash() {
+		return s.blockHeader(ctx, "eth_getBlockByNumber", ref.String())
+	}
+	header, err := s.blockHeader(ctx, "eth_getBlockByHash", ref.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if header.Hash() != ref.Hash {
+		return nil, fmt.Errorf("%w: block hash (%x != %x)",
+			ethstorageproof.ErrRootMismatch, header.Hash(), ref.Hash)
+	}
+	if ref.RequireCanonical {
+		canonical, err := s.blockHeader(ctx, "eth_getBlockByNumber",
+			helpers.ToBlockNumArg(header.Number))
+		if err != nil {
+			return nil, err
+		}
+		if canonical.Hash() != ref.Hash {
+			return nil, fmt.Errorf("block %x is not canonical", ref.Hash)
+		}
+	}
+	return header, nil
+}
+
+// blockHeader calls a web3 method returning a block and decodes its header
+func (s *RPC) blockHeader(ctx context.Context, method string,
+	arg interface{}) (*ethstorageproof.BlockHeader, error) {
+	var raw json.RawMessage
+	if err := s.RPCCli.CallContext(ctx, &raw, method, arg, false); err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ethereum.NotFound
+	}
+	return ethstorageproof.HeaderFromJSON(raw)
+}
+
+// StorageKeys returns the storage keys read by msg using the
+// eth_createAccessList web3 method.  If the node does not support it,
+// debug_traceCall with the prestateTracer is used instead.
+func (s *RPC) StorageKeys(ctx context.Context, msg ethereum.CallMsg,
+	blockHash common.Hash) (map[common.Address][]common.Hash, error) {
+	arg := toCallArg(msg)
+	block := BlockHash(blockHash, false).Arg()
+	var al accessListResult
+	err := s.RPCCli.CallContext(ctx, &al, "eth_createAccessList", arg, block)
+	if err == nil {
+		if al.Error != "" {
+			return nil, fmt.Errorf("call failed: %s", al.Error)
+		}
+		keys := make(map[common.Address][]common.Hash)
+		for _, tuple := range al.AccessList {
+			keys[tuple.Address] = append(keys[tuple.Address], tuple.StorageKeys...)
+		}
+		return keys, nil
+	}
+	var prestate map[common.Address]prestateAccount
+	if terr := s.RPCCli.CallContext(ctx, &prestate, "debug_traceCall", arg, block,
+		map[string]interface{}{"tracer": "prestateTracer"}); terr != nil {
+		return nil, fmt.Errorf("eth_createAccessList: %v, debug_traceCall: %w", err, terr)
+	}
+	keys := make(map[common.Address][]common.Hash)
+	for addr, account := range prestate {
+		for key := range account.Storage {
+			keys[addr] = append(keys[addr], key)
+		}
+	}
+	return keys, nil
+}
+
+// toCallArg returns the call message as a web3 call object
+func toCallArg(msg ethereum.CallMsg) interface{} {
+	arg := map[string]interface{}{
+		"from": msg.From,
+		"to":   msg.To,
+	}
+	if len(msg.Data) > 0 {
+		arg["input"] = hexutil.Bytes(msg.Data)
+	}
+	if msg.Gas != 0 {
+		arg["gas"] = hexutil.Uint64(msg.Gas)
+	}
+	return arg
+}
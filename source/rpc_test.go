@@ -0,0 +1,144 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/testchain"
+)
+
+func TestRPC(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	holder := common.HexToAddress("0x5041ed759dd4afc3a72b8192c143f72f4724081a")
+	key := common.Hash(helpers.GetMapSlot(holder, testchain.ERC20BalancesSlot))
+
+	chain := testchain.New()
+	defer chain.Close()
+	contract := chain.DeployERC20("Test Token", "TST", 18)
+	chain.SetERC20Balance(contract, holder, big.NewInt(1234))
+	// The block 32 is finalized once the next one is sealed
+	for chain.Head().Number.Int64() < 31 {
+		chain.Commit()
+	}
+	gh := chain.Commit()
+	chain.SetERC20Balance(contract, holder, big.NewInt(5678))
+	chain.Commit()
+	src := NewRPC(chain.Client())
+
+	header, err := src.BlockHeader(ctx, BlockNumber(gh.Number))
+	c.Assert(err, qt.IsNil)
+	c.Assert(header.Hash(), qt.Equals, gh.Hash())
+	header, err = src.BlockHeader(ctx, BlockHash(gh.Hash(), true))
+	c.Assert(err, qt.IsNil)
+	c.Assert(header.Hash(), qt.Equals, gh.Hash())
+	header, err = src.BlockHeader(ctx, BlockRef{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(header.Hash(), qt.Equals, chain.Head().Hash())
+	header, err = src.BlockHeader(ctx, Earliest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(header.Number.Sign(), qt.Equals, 0)
+	_, err = src.BlockHeader(ctx, BlockNumber(big.NewInt(1000)))
+	c.Assert(errors.Is(err, ethereum.NotFound), qt.IsTrue)
+	_, err = src.BlockHeader(ctx, BlockHash(common.HexToHash("0x01"), false))
+	c.Assert(errors.Is(err, ethereum.NotFound), qt.IsTrue)
+	_, err = src.BlockHeader(ctx, BlockRef{Tag: TagPending})
+	c.Assert(errors.Is(err, ErrPendingBlock), qt.IsTrue)
+	_, err = src.BlockHeader(ctx, BlockNumber(big.NewInt(-1)))
+	c.Assert(errors.Is(err, ErrPendingBlock), qt.IsTrue)
+
+	// The finalized block is behind the head
+	header, err = src.BlockHeader(ctx, Finalized)
+	c.Assert(err, qt.IsNil)
+	c.Assert(header.Hash(), qt.Equals, gh.Hash())
+	c.Assert(chain.Finalized().Hash(), qt.Equals, gh.Hash())
+
+	value, err := src.StorageAt(ctx, contract, key, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(new(big.Int).SetBytes(value).Int64(), qt.Equals, int64(5678))
+	value, err = src.StorageAtHash(ctx, contract, key, gh.Hash())
+	c.Assert(err, qt.IsNil)
+	c.Assert(new(big.Int).SetBytes(value).Int64(), qt.Equals, int64(1234))
+
+	sp, err := src.GetProof(ctx, contract, [][]byte{key[:]}, Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(sp.BlockHash, qt.Equals, chain.Head().Hash())
+	c.Assert(new(big.Int).SetBytes(sp.StorageProof[0].Value).Int64(), qt.Equals, int64(5678))
+
+	sp, err = src.GetProof(ctx, contract, [][]byte{key[:]}, Finalized)
+	c.Assert(err, qt.IsNil)
+	c.Assert(sp.Height.Cmp(gh.Number), qt.Equals, 0)
+	c.Assert(sp.BlockHash, qt.Equals, gh.Hash())
+	ok, err := ethstorageproof.VerifyEIP1186WithBlockHash(sp, gh.Hash())
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(new(big.Int).SetBytes(sp.StorageProof[0].Value).Int64(), qt.Equals, int64(1234))
+}
+
+func TestRPCStorageKeys(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	chain := testchain.New()
+	defer chain.Close()
+	token := chain.DeployERC20AtSlot("Test Token", "TST", 18, 40)
+	proxy := chain.DeployProxy(token)
+	holder := common.HexToAddress("0x5041ed759dd4afc3a72b8192c143f72f4724081a")
+	head := chain.Commit()
+	src := NewRPC(chain.Client())
+
+	data := append(common.FromHex("0x70a08231"), common.LeftPadBytes(holder[:], 32)...)
+	want := common.Hash(helpers.GetMapSlot(holder, 40))
+	keys, err := src.StorageKeys(ctx, ethereum.CallMsg{To: &proxy, Data: data}, head.Hash())
+	c.Assert(err, qt.IsNil)
+	// The storage read through the proxy belongs to the proxy
+	c.Assert(keys[proxy], qt.Contains, want)
+	c.Assert(keys[token], qt.HasLen, 0)
+}
+
+// traceService serves debug_traceCall with a fixed prestateTracer result, as
+// a node without eth_createAccessList
+type traceService struct {
+	prestate map[common.Address]prestateAccount
+}
+
+// TraceCall implements debug_traceCall
+func (s *traceService) TraceCall(args, block json.RawMessage,
+	config map[string]interface{}) (map[common.Address]prestateAccount, error) {
+	if config["tracer"] != "prestateTracer" {
+		return nil, fmt.Errorf("unsupported tracer %v", config["tracer"])
+	}
+	return s.prestate, nil
+}
+
+func TestRPCStorageKeysTrace(t *testing.T) {
+	c := qt.New(t)
+	proxy := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	token := common.HexToAddress("0x1000000000000000000000000000000000000002")
+	want := common.Hash(helpers.GetMapSlot(proxy, 40))
+	server := rpc.NewServer()
+	defer server.Stop()
+	c.Assert(server.RegisterName("debug", &traceService{
+		prestate: map[common.Address]prestateAccount{
+			proxy: {Storage: map[common.Hash]common.Hash{want: common.HexToHash("0x01")}},
+			token: {},
+		},
+	}), qt.IsNil)
+	src := NewRPC(rpc.DialInProc(server))
+
+	// eth_createAccessList is not available, so debug_traceCall is used
+	keys, err := src.StorageKeys(context.Background(), ethereum.CallMsg{To: &proxy},
+		common.HexToHash("0x02"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(keys[proxy], qt.DeepEquals, []common.Hash{want})
+	c.Assert(keys[token], qt.HasLen, 0)
+}
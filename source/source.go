@@ -0,0 +1,52 @@
+// Package source defines where the token packages read the chain data they
+// need to discover storage slots and generate storage proofs.
+package source
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+)
+
+// ProofSource provides the chain data used by the token implementations.
+// A proof request resolves its BlockRef once with BlockHeader, and does all
+// the other reads against the hash of that block (EIP-1898), so they are
+// consistent even if the head of the chain advances.
+type ProofSource interface {
+	// ContractCaller allows calling contract methods, such as balanceOf.
+	bind.ContractCaller
+	// BlockHashContractCaller allows calling contract methods at a block hash.
+	bind.BlockHashContractCaller
+	// LogFilterer allows searching event logs, such as token transfers.
+	ethereum.LogFilterer
+	// ChainID returns the chain id, used to tell apart the same contract
+	// address on different chains.
+	ChainID(ctx context.Context) (*big.Int, error)
+	// StorageAtHash returns the value of the storage key of account at the
+	// block with blockHash.
+	StorageAtHash(ctx context.Context, account common.Address, key common.Hash,
+		blockHash common.Hash) ([]byte, error)
+	// BlockHeader returns the header of the block referred by ref.  Returns
+	// ethereum.NotFound if the block does not exist, and ErrPendingBlock for
+	// the pending block.
+	BlockHeader(ctx context.Context, ref BlockRef) (*ethstorageproof.BlockHeader, error)
+	// GetProof returns the EIP1186 proof of account and its storage keys at
+	// the block referred by ref, including the block header and hash.
+	GetProof(ctx context.Context, account common.Address, keys [][]byte,
+		ref BlockRef) (*ethstorageproof.StorageProof, error)
+}
+
+// StorageTracer is implemented by the sources able to report the storage
+// keys read by a contract call, used to discover storage layouts without
+// brute forcing them.
+type StorageTracer interface {
+	// StorageKeys returns the storage keys read (or written) by each account
+	// when executing msg at the block with blockHash.  The keys of a
+	// DELEGATECALL are reported under the account owning the storage.
+	StorageKeys(ctx context.Context, msg ethereum.CallMsg,
+		blockHash common.Hash) (map[common.Address][]common.Hash, error)
+}
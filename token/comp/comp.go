@@ -0,0 +1,384 @@
+// Package comp gets and verifies storage proofs of the voting power of the
+// delegates of Compound COMP style governance tokens, at a past block.
+package comp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc20votes"
+)
+
+const (
+	// CheckpointsSlot is the index slot of the checkpoints map of the COMP
+	// token.  The numCheckpoints map is at the next index slot.
+	CheckpointsSlot = 3
+	// MaxCheckpointScan is the number of positions, from the last checkpoint
+	// of the holder, searched by VerifyProof for the checkpoint of a proof
+	MaxCheckpointScan = 1024
+)
+
+var (
+	// ErrNoCheckpoint is returned when the delegate has no checkpoint at or
+	// before the block of the proof
+	ErrNoCheckpoint = errors.New("no checkpoint at the block")
+
+	// getCurrentVotesSelector is the selector of the getCurrentVotes(address)
+	// method
+	getCurrentVotesSelector = crypto.Keccak256([]byte("getCurrentVotes(address)"))[:4]
+)
+
+// Comp tokens store the voting power of each delegate on a map of maps
+// `address => uint32 => Checkpoint` (checkpoints), the history of its votes,
+// and the number of checkpoints of each delegate on a map `address => uint32`
+// (numCheckpoints), declared right after it.  Each checkpoint packs the
+// uint32 fromBlock and the uint96 votes on a storage slot.  To prove the
+// votes at a block three storage proofs are needed: the one of the number of
+// checkpoints, the one of the last checkpoint at or before the block, and the
+// one of the next checkpoint, which is after the block, unless the number of
+// checkpoints bounds it.  As a token.Token, the balance of a holder is its
+// voting power and the index slot is the one of the checkpoints map.
+type Comp struct {
+	erc20 *erc20.ERC20Token
+}
+
+// New creates a new Comp to get and verify COMP token proofs
+func New(ctx context.Context, src source.ProofSource,
+	tokenAddress common.Address) (*Comp, error) {
+	token, err := erc20.New(ctx, src, tokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &Comp{erc20: token}, nil
+}
+
+// ParseCheckpoint splits the storage value of a checkpoint into its votes and
+// its fromBlock
+func ParseCheckpoint(value []byte) (votes, fromBlock *big.Int) {
+	value = common.LeftPadBytes(value, 32)
+	return new(big.Int).SetBytes(value[16:28]), new(big.Int).SetBytes(value[28:])
+}
+
+// GetCurrentVotesAtHash returns the votes of delegate at the block with
+// blockHash, calling the getCurrentVotes method.
+func (m *Comp) GetCurrentVotesAtHash(ctx context.Context, delegate common.Address,
+	blockHash common.Hash) (*big.Int, error) {
+	out, err := m.erc20.Source.CallContractAtHash(ctx,
+		ethereum.CallMsg{To: &m.erc20.TokenAddr, Data: getCurrentVotesData(delegate)},
+		blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("getCurrentVotes: %w", err)
+	}
+	if len(out) != common.HashLength {
+		return nil, fmt.Errorf("invalid getCurrentVotes output %x", out)
+	}
+	return new(big.Int).SetBytes(out), nil
+}
+
+// getCurrentVotesData returns the call data of getCurrentVotes(delegate)
+func getCurrentVotesData(delegate common.Address) []byte {
+	return append(common.CopyBytes(getCurrentVotesSelector),
+		common.LeftPadBytes(delegate[:], 32)...)
+}
+
+// DiscoverSlot tries to find the index slot of the checkpoints map, matching
+// the last checkpoint of holder with its votes.  If the source implements
+// source.StorageTracer, the index slots read by getCurrentVotes are checked
+// first, otherwise the first erc20.DiscoveryIterations index slots are
+// checked.  If
+// holder is the zero address, a delegate is picked from the recent
+// DelegateVotesChanged events.  Returns erc20.ErrZeroBalance if the holder has
+// no votes.  If found, returns also the votes of holder at the block referred
+// by ref.
+func (m *Comp) DiscoverSlot(ctx context.Context, holder common.Address,
+	ref source.BlockRef) (int, *big.Rat, error) {
+	// All the reads are done at the same block, so the votes can not
+	// change while searching for them
+	header, err := m.erc20.GetBlockHeader(ctx, ref)
+	if err != nil {
+		return -1, nil, fmt.Errorf("cannot get block header: %w", err)
+	}
+	blockHash := header.Hash()
+	var votes *big.Int
+	if holder == (common.Address{}) {
+		if holder, votes, err = m.FindDelegate(ctx, header); err != nil {
+			return -1, nil, err
+		}
+	} else {
+		if votes, err = m.GetCurrentVotesAtHash(ctx, holder, blockHash); err != nil {
+			return -1, nil, err
+		}
+		if votes.Sign() == 0 {
+			return -1, nil, fmt.Errorf("%w: %s", erc20.ErrZeroBalance, holder.Hex())
+		}
+	}
+
+	// The slots read by getCurrentVotes are the ones of the numCheckpoints
+	// map, the checkpoints map is the previous one
+	numCheckpointsSlot := func(i int) [32]byte { return helpers.GetMapSlot(holder, i+1) }
+	index, err := m.erc20.DiscoverIndexSlot(ctx, getCurrentVotesData(holder),
+		numCheckpointsSlot, blockHash, erc20.DiscoveryIterations, func(i int) (bool, error) {
+			n, err := m.numCheckpoints(ctx, holder, i, blockHash)
+			if err != nil || n == 0 || n > math.MaxUint32 {
+				return false, err
+			}
+			value, err := m.erc20.Source.StorageAtHash(ctx, m.erc20.TokenAddr,
+				helpers.GetAddressNestedMapSlot(holder, new(big.Int).SetUint64(n-1), i),
+				blockHash)
+			if err != nil {
+				return false, err
+			}
+			v, _ := ParseCheckpoint(value)
+			return v.Cmp(votes) == 0, nil
+		})
+	if err != nil {
+		return -1, nil, err
+	}
+	return index, new(big.Rat).SetInt(votes), nil
+}
+
+// FindDelegate returns a delegate with votes at the block of header, and its
+// votes.  The delegates of the most recent DelegateVotesChanged events up to
+// the block are checked.  Returns erc20.ErrHolderNotFound if none of them has
+// votes.
+func (m *Comp) FindDelegate(ctx context.Context,
+	header *ethstorageproof.BlockHeader) (common.Address, *big.Int, error) {
+	delegate := func(l *types.Log) (common.Address, bool) {
+		if len(l.Topics) != 2 {
+			return common.Address{}, false
+		}
+		return common.BytesToAddress(l.Topics[1][:]), true
+	}
+	return m.erc20.FindHolderInLogs(ctx, header,
+		[]common.Hash{erc20votes.DelegateVotesChangedTopic}, delegate, m.GetCurrentVotesAtHash)
+}
+
+// GetProof returns the storage proofs of the votes of holder at the block
+// referred by ref, with the checkpoints map at index slot islot: the proof of
+// numCheckpoints, the one of the last checkpoint at or before the block, and
+// the one of the next checkpoint.
+func (m *Comp) GetProof(ctx context.Context, holder common.Address, ref source.BlockRef,
+	islot int) (*ethstorageproof.StorageProof, error) {
+	// Resolve the block once, so all the reads refer to the same block even
+	// if ref is a tag and the head advances.
+	header, err := m.erc20.GetBlockHeader(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get block header: %w", err)
+	}
+	blockHash := header.Hash()
+	n, err := m.numCheckpoints(ctx, holder, islot, blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get numCheckpoints: %w", err)
+	}
+	if n > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid numCheckpoints %d", n)
+	}
+	// Search the checkpoint as getPriorVotes does
+	low, high := uint64(0), n
+	for low < high {
+		mid := low + (high-low)/2
+		value, err := m.erc20.Source.StorageAtHash(ctx, m.erc20.TokenAddr,
+			helpers.GetAddressNestedMapSlot(holder, new(big.Int).SetUint64(mid), islot),
+			blockHash)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get checkpoint %d: %w", mid, err)
+		}
+		if _, fromBlock := ParseCheckpoint(value); fromBlock.Cmp(header.Number) > 0 {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	if high == 0 {
+		return nil, fmt.Errorf("%w: %s at %v", ErrNoCheckpoint, holder.Hex(), header.Number)
+	}
+	numSlot := helpers.GetMapSlot(holder, islot+1)
+	slot0 := helpers.GetAddressNestedMapSlot(holder, new(big.Int).SetUint64(high-1), islot)
+	slot1 := helpers.GetAddressNestedMapSlot(holder, new(big.Int).SetUint64(high), islot)
+	return m.erc20.GetProof(ctx, [][]byte{numSlot[:], slot0[:], slot1[:]},
+		source.BlockHash(blockHash, ref.RequireCanonical))
+}
+
+// numCheckpoints returns the number of checkpoints of holder, with the
+// checkpoints map at index slot islot, at the block with blockHash
+func (m *Comp) numCheckpoints(ctx context.Context, holder common.Address, islot int,
+	blockHash common.Hash) (uint64, error) {
+	value, err := m.erc20.Source.StorageAtHash(ctx, m.erc20.TokenAddr,
+		helpers.GetMapSlot(holder, islot+1), blockHash)
+	if err != nil {
+		return 0, err
+	}
+	n := new(big.Int).SetBytes(value)
+	if !n.IsUint64() {
+		return math.MaxUint64, nil
+	}
+	return n.Uint64(), nil
+}
+
+// VerifyProof verifies the storage proofs of the votes of holder at the block
+// targetBlock, which must be targetBalance.
+func (m *Comp) VerifyProof(holder common.Address, storageRoot common.Hash,
+	proofs []ethstorageproof.StorageResult, checkpointsSlot int, targetBalance,
+	targetBlock *big.Int) error {
+	return VerifyProof(holder, storageRoot, proofs, checkpointsSlot, targetBalance,
+		targetBlock)
+}
+
+// VerifyProof verifies the storage proofs of the votes of holder with the
+// checkpoints map at index slot checkpointsSlot, which must be targetVotes at
+// targetBlock.  The first proof must be the one of numCheckpoints, the second
+// one a checkpoint of targetVotes at or before targetBlock, and the third one
+// the next checkpoint, which must be after targetBlock unless it is beyond
+// numCheckpoints.  The position of the checkpoint is searched among the last
+// MaxCheckpointScan ones of holder, the proofs of older checkpoints are
+// verified by VerifyCheckpointProof with their position.
+func VerifyProof(holder common.Address, storageRoot common.Hash,
+	proofs []ethstorageproof.StorageResult, checkpointsSlot int, targetVotes,
+	targetBlock *big.Int) error {
+	n, err := verifyNumCheckpoints(holder, storageRoot, proofs, checkpointsSlot,
+		targetVotes, targetBlock)
+	if err != nil {
+		return err
+	}
+	// The checkpoint must be one of the last MaxCheckpointScan of holder
+	last := n - 1
+	for position := last; position+MaxCheckpointScan > last; position-- {
+		slot := helpers.GetAddressNestedMapSlot(holder, new(big.Int).SetUint64(position),
+			checkpointsSlot)
+		if bytes.Equal(slot[:], proofs[1].Key) {
+			return verifyCheckpoints(holder, storageRoot, proofs, checkpointsSlot, n,
+				position, targetVotes, targetBlock)
+		}
+		if position == 0 {
+			break
+		}
+	}
+	return fmt.Errorf("%w: proof 1 key is not one of the last %d checkpoints of holder",
+		ethstorageproof.ErrBadKey, MaxCheckpointScan)
+}
+
+// VerifyCheckpointProof verifies the storage proofs of the votes of holder as
+// VerifyProof does, with the checkpoint of the second proof at position, so
+// no position is searched.
+func VerifyCheckpointProof(holder common.Address, storageRoot common.Hash,
+	proofs []ethstorageproof.StorageResult, checkpointsSlot int, position uint32,
+	targetVotes, targetBlock *big.Int) error {
+	n, err := verifyNumCheckpoints(holder, storageRoot, proofs, checkpointsSlot,
+		targetVotes, targetBlock)
+	if err != nil {
+		return err
+	}
+	if uint64(position) >= n {
+		return fmt.Errorf("%w: checkpoint %d is beyond numCheckpoints %d",
+			ethstorageproof.ErrBadKey, position, n)
+	}
+	slot := helpers.GetAddressNestedMapSlot(holder, new(big.Int).SetUint64(uint64(position)),
+		checkpointsSlot)
+	if !bytes.Equal(slot[:], proofs[1].Key) {
+		return fmt.Errorf("%w: proof 1 key is not the checkpoint %d of holder",
+			ethstorageproof.ErrBadKey, position)
+	}
+	return verifyCheckpoints(holder, storageRoot, proofs, checkpointsSlot, n,
+		uint64(position), targetVotes, targetBlock)
+}
+
+// verifyNumCheckpoints checks the proofs layout and verifies the first one,
+// returning the number of checkpoints of holder it proves
+func verifyNumCheckpoints(holder common.Address, storageRoot common.Hash,
+	proofs []ethstorageproof.StorageResult, checkpointsSlot int, targetVotes,
+	targetBlock *big.Int) (uint64, error) {
+	if len(proofs) != 3 {
+		return 0, fmt.Errorf("invalid length of proofs %d", len(proofs))
+	}
+	if targetVotes == nil || targetBlock == nil {
+		return 0, fmt.Errorf("target votes or target block is nil")
+	}
+	for i, p := range proofs {
+		if len(p.Value) > 32 {
+			return 0, fmt.Errorf("%w: proof %d value length is %d",
+				ethstorageproof.ErrValueMismatch, i, len(p.Value))
+		}
+	}
+
+	numSlot := helpers.GetMapSlot(holder, checkpointsSlot+1)
+	if !bytes.Equal(numSlot[:], proofs[0].Key) {
+		return 0, fmt.Errorf("%w: proof 0 key and holder do not match (%x != %x)",
+			ethstorageproof.ErrBadKey, numSlot, proofs[0].Key)
+	}
+	n := new(big.Int).SetBytes(proofs[0].Value)
+	if n.Sign() == 0 || n.Cmp(big.NewInt(math.MaxUint32)) > 0 {
+		return 0, fmt.Errorf("%w: invalid numCheckpoints %v",
+			ethstorageproof.ErrValueMismatch, n)
+	}
+	// numCheckpoints bounds the checkpoint positions, so it is verified
+	// before checking them
+	if err := verifyStorage(&proofs[0], storageRoot); err != nil {
+		return 0, fmt.Errorf("proof 0 is not valid: %w", err)
+	}
+	return n.Uint64(), nil
+}
+
+// verifyCheckpoints verifies the proofs of the checkpoint of holder at
+// position, out of n, and the next one
+func verifyCheckpoints(holder common.Address, storageRoot common.Hash,
+	proofs []ethstorageproof.StorageResult, checkpointsSlot int, n, position uint64,
+	targetVotes, targetBlock *big.Int) error {
+	next := new(big.Int).SetUint64(position + 1)
+	if slot := helpers.GetAddressNestedMapSlot(holder, next, checkpointsSlot); !bytes.Equal(
+		slot[:], proofs[2].Key) {
+		return fmt.Errorf("%w: proof 2 key is not the next checkpoint",
+			ethstorageproof.ErrBadKey)
+	}
+
+	votes, block0 := ParseCheckpoint(proofs[1].Value)
+	if votes.Cmp(targetVotes) != 0 {
+		return fmt.Errorf("%w: proof votes and provided votes mismatch (%v != %v)",
+			ethstorageproof.ErrValueMismatch, votes, targetVotes)
+	}
+	// Verify that `block0 <= targetBlock < block1`
+	if block0.Cmp(targetBlock) > 0 {
+		return fmt.Errorf("%w: proof 1 block %v is after the target block %v",
+			ethstorageproof.ErrCheckpointRange, block0, targetBlock)
+	}
+	// The checkpoints from numCheckpoints on are not used
+	if position+1 < n {
+		_, block1 := ParseCheckpoint(proofs[2].Value)
+		if block0.Cmp(block1) >= 0 {
+			return fmt.Errorf("%w: proof 1 block is not behind proof 2 block",
+				ethstorageproof.ErrCheckpointRange)
+		}
+		if targetBlock.Cmp(block1) >= 0 {
+			return fmt.Errorf("%w: proof 2 block %v is not after the target block %v",
+				ethstorageproof.ErrCheckpointRange, block1, targetBlock)
+		}
+	}
+
+	for i := 1; i < len(proofs); i++ {
+		if err := verifyStorage(&proofs[i], storageRoot); err != nil {
+			return fmt.Errorf("proof %d is not valid: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// verifyStorage verifies the storage proof of a value, or of its absence if
+// the value is empty
+func verifyStorage(proof *ethstorageproof.StorageResult, storageRoot common.Hash) error {
+	if len(proof.Value) == 0 {
+		return ethstorageproof.VerifyStorageAbsence(proof, storageRoot)
+	}
+	_, err := ethstorageproof.VerifyEthStorageProof(proof, storageRoot)
+	return err
+}
@@ -0,0 +1,133 @@
+package comp
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/testchain"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/tokentest"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
+)
+
+func TestComp(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	alice, bob := tokentest.Alice, tokentest.Bob
+	chain := testchain.New()
+	defer chain.Close()
+	addr := chain.DeployComp("Compound", "COMP")
+	chain.Commit()
+	// alice checkpoints on blocks 2, 4 and 5, bob on block 3
+	tokentest.Play(chain, []tokentest.Step{
+		{Block: 2, Holder: alice, Value: 100},
+		{Block: 3, Holder: bob, Value: 50},
+		{Block: 4, Holder: alice, Value: 300},
+		{Block: 5, Holder: alice, Value: 200},
+	}, func(s tokentest.Step) {
+		chain.SetCompVotes(addr, s.Holder, big.NewInt(s.Value))
+	})
+	chain.Commit()
+	src := source.NewRPC(chain.Client())
+
+	m, err := New(ctx, src, addr)
+	c.Assert(err, qt.IsNil)
+	slot, votes, err := m.DiscoverSlot(ctx, common.Address{}, source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, CheckpointsSlot)
+	c.Assert(votes.Cmp(big.NewRat(200, 1)), qt.Equals, 0)
+
+	checker := &tokentest.Checker{Chain: chain, Token: m, Slot: slot}
+	checker.Extra = func(c *qt.C, tc tokentest.Case, sp *ethstorageproof.StorageProof) {
+		c.Assert(sp.StorageProof, qt.HasLen, 3)
+		err := VerifyProof(tc.Holder, sp.StorageHash, sp.StorageProof, slot+1,
+			big.NewInt(tc.Balance), big.NewInt(int64(tc.Block)))
+		c.Assert(errors.Is(err, ethstorageproof.ErrBadKey), qt.IsTrue)
+	}
+	checker.Check(c,
+		tokentest.Case{Holder: alice, Block: 2, Balance: 100},
+		tokentest.Case{Holder: alice, Block: 3, Balance: 100},
+		tokentest.Case{Holder: alice, Block: 4, Balance: 300},
+		tokentest.Case{Holder: alice, Block: 6, Balance: 200},
+		tokentest.Case{Holder: bob, Block: 6, Balance: 50},
+	)
+
+	// The proof of the checkpoint of block 4 does not prove the votes at
+	// block 3, before it
+	sp, err := m.GetProof(ctx, alice, source.BlockNumber(big.NewInt(4)), slot)
+	c.Assert(err, qt.IsNil)
+	err = VerifyProof(alice, sp.StorageHash, sp.StorageProof, slot, big.NewInt(300),
+		big.NewInt(3))
+	c.Assert(errors.Is(err, ethstorageproof.ErrCheckpointRange), qt.IsTrue)
+
+	// At block 6 the checkpoint of block 2 is stale, followed by the one of
+	// block 4, so a proof of it does not prove the votes at block 6
+	latest, err := m.GetProof(ctx, alice, source.BlockNumber(big.NewInt(6)), slot)
+	c.Assert(err, qt.IsNil)
+	old, err := m.GetProof(ctx, alice, source.BlockNumber(big.NewInt(2)), slot)
+	c.Assert(err, qt.IsNil)
+	next, err := m.erc20.GetProof(ctx, [][]byte{old.StorageProof[2].Key},
+		source.BlockNumber(big.NewInt(6)))
+	c.Assert(err, qt.IsNil)
+	old.StorageProof[0] = latest.StorageProof[0]
+	old.StorageProof[2] = next.StorageProof[0]
+	err = VerifyProof(alice, latest.StorageHash, old.StorageProof, slot, big.NewInt(100),
+		big.NewInt(6))
+	c.Assert(errors.Is(err, ethstorageproof.ErrCheckpointRange), qt.IsTrue)
+
+	_, err = m.GetProof(ctx, bob, source.BlockNumber(big.NewInt(2)), slot)
+	c.Assert(errors.Is(err, ErrNoCheckpoint), qt.IsTrue)
+	_, _, err = m.DiscoverSlot(ctx, bob, source.BlockNumber(big.NewInt(2)))
+	c.Assert(errors.Is(err, erc20.ErrZeroBalance), qt.IsTrue)
+}
+
+func TestCompOldCheckpoint(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	alice := tokentest.Alice
+	chain := testchain.New()
+	defer chain.Close()
+	addr := chain.DeployComp("Compound", "COMP")
+	chain.Commit()
+	// alice has more checkpoints than VerifyProof searches, the first one of
+	// block 2 and the second one of block 1000
+	n := int64(MaxCheckpointScan + 10)
+	checkpoint := func(votes, block int64) common.Hash {
+		var value common.Hash
+		big.NewInt(votes).FillBytes(value[16:28])
+		big.NewInt(block).FillBytes(value[28:])
+		return value
+	}
+	numSlot := helpers.GetMapSlot(alice, CheckpointsSlot+1)
+	slot0 := helpers.GetAddressNestedMapSlot(alice, big.NewInt(0), CheckpointsSlot)
+	slot1 := helpers.GetAddressNestedMapSlot(alice, big.NewInt(1), CheckpointsSlot)
+	chain.SetStorage(addr, numSlot, common.BigToHash(big.NewInt(n)))
+	chain.SetStorage(addr, slot0, checkpoint(100, 2))
+	chain.SetStorage(addr, slot1, checkpoint(300, 1000))
+	chain.Commit()
+	src := source.NewRPC(chain.Client())
+
+	m, err := New(ctx, src, addr)
+	c.Assert(err, qt.IsNil)
+	sp, err := m.erc20.GetProof(ctx, [][]byte{numSlot[:], slot0[:], slot1[:]}, source.Latest)
+	c.Assert(err, qt.IsNil)
+	err = VerifyProof(alice, sp.StorageHash, sp.StorageProof, CheckpointsSlot,
+		big.NewInt(100), big.NewInt(3))
+	c.Assert(errors.Is(err, ethstorageproof.ErrBadKey), qt.IsTrue)
+	c.Assert(VerifyCheckpointProof(alice, sp.StorageHash, sp.StorageProof, CheckpointsSlot,
+		0, big.NewInt(100), big.NewInt(3)), qt.IsNil)
+	err = VerifyCheckpointProof(alice, sp.StorageHash, sp.StorageProof, CheckpointsSlot,
+		0, big.NewInt(100), big.NewInt(1000))
+	c.Assert(errors.Is(err, ethstorageproof.ErrCheckpointRange), qt.IsTrue)
+	for _, position := range []uint32{1, uint32(n)} {
+		err = VerifyCheckpointProof(alice, sp.StorageHash, sp.StorageProof, CheckpointsSlot,
+			position, big.NewInt(100), big.NewInt(3))
+		c.Assert(errors.Is(err, ethstorageproof.ErrBadKey), qt.IsTrue)
+	}
+}
@@ -0,0 +1,143 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/proxy"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
+	"github.com/vocdoni/storage-proofs-eth-go/token/mapbased"
+)
+
+var (
+	// ErrNotContract is returned when detecting the type of an account
+	// without code
+	ErrNotContract = errors.New("account has no code")
+	// ErrUnknownTokenType is returned when the storage layout of a contract
+	// does not match any token type
+	ErrUnknownTokenType = errors.New("unknown token type")
+)
+
+// minimeSelectors are the selectors of methods only implemented by MiniMe
+// tokens
+var minimeSelectors = []string{
+	"balanceOfAt(address,uint256)",
+	"totalSupplyAt(uint256)",
+	"createCloneToken(string,uint8,string,uint256,bool)",
+}
+
+// erc721Selectors are the selectors of methods only implemented by ERC721
+// tokens
+var erc721Selectors = []string{
+	"ownerOf(uint256)",
+	"safeTransferFrom(address,address,uint256)",
+}
+
+// Detection is the result of Detect
+type Detection struct {
+	// Token is the implementation of the detected token type
+	Token Token
+	// Type is the detected token type
+	Type int
+	// Slot is the discovered index slot
+	Slot int
+	// Implementation is the implementation contract of a proxy, which
+	// code was probed, or the zero address if the token is not a proxy
+	Implementation common.Address
+}
+
+// Detect probes the contract at address at the block referred by ref, and
+// returns its token type with the discovered index slot.  The type suggested
+// by the contract code selectors (such as balanceOfAt or createCloneToken for
+// MiniMe) is tried first, and confirmed by discovering its storage layout
+// with the balance of holder.  If holder is the zero address, a holder is
+// picked from the recent Transfer events.  Returns ErrUnknownTokenType if no
+// storage layout is found.
+func Detect(ctx context.Context, src source.ProofSource, address, holder common.Address,
+	ref source.BlockRef) (*Detection, error) {
+	// Resolve the block once, so all the probes are done at the same block
+	header, err := src.BlockHeader(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get block header: %w", err)
+	}
+	blockHash := header.Hash()
+	ref = source.BlockHash(blockHash, ref.RequireCanonical)
+
+	code, err := src.CodeAtHash(ctx, address, blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get code: %w", err)
+	}
+	if len(code) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotContract, address.Hex())
+	}
+	d := &Detection{}
+	// The methods of a proxy are implemented by the implementation code
+	p, err := proxy.Detect(ctx, src, address, blockHash)
+	if err != nil && !errors.Is(err, proxy.ErrNotProxy) {
+		return nil, err
+	}
+	if p != nil {
+		d.Implementation = p.Implementation
+		if code, err = src.CodeAtHash(ctx, p.Implementation, blockHash); err != nil {
+			return nil, fmt.Errorf("cannot get implementation code: %w", err)
+		}
+	}
+
+	// The map based tokens try both the Solidity and the Vyper map layouts
+	types := []int{TokenTypeMapbased, TokenTypeMinime}
+	if hasAnySelector(code, minimeSelectors) {
+		types = []int{TokenTypeMinime, TokenTypeMapbased}
+	}
+	// The balances of ERC721 tokens are stored as the ones of map based
+	// tokens, but they have no decimals
+	if hasAnySelector(code, erc721Selectors) {
+		types = []int{TokenTypeERC721}
+	}
+	for _, ttype := range types {
+		t, err := New(ctx, src, ttype, address)
+		if err != nil {
+			return nil, err
+		}
+		slot, _, err := t.DiscoverSlot(ctx, holder, ref)
+		if errors.Is(err, erc20.ErrSlotNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m, ok := t.(*mapbased.Mapbased); ok && m.Layout() == helpers.LayoutVyper {
+			ttype = TokenTypeVyper
+		}
+		d.Token, d.Type, d.Slot = t, ttype, slot
+		return d, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownTokenType, address.Hex())
+}
+
+// hasAnySelector returns true if code pushes the selector of any of the
+// method signatures, as the Solidity method dispatcher does.
+func hasAnySelector(code []byte, signatures []string) bool {
+	selectors := make(map[[4]byte]bool)
+	for _, s := range signatures {
+		selectors[[4]byte(crypto.Keccak256([]byte(s))[:4])] = true
+	}
+	for pc := 0; pc < len(code); pc++ {
+		op := vm.OpCode(code[pc])
+		if op < vm.PUSH1 || op > vm.PUSH32 {
+			continue
+		}
+		size := int(op-vm.PUSH1) + 1
+		if size == 4 && pc+5 <= len(code) && selectors[[4]byte(code[pc+1:pc+5])] {
+			return true
+		}
+		// Skip the push data
+		pc += size
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/testchain"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/tokentest"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+)
+
+func TestDetect(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	chain := testchain.New()
+	defer chain.Close()
+	erc20Addr := chain.DeployERC20("Test Token", "TST", 18)
+	minimeImpl := chain.DeployMinime("Test MiniMe", "MMT", 18)
+	minimeAddr := chain.DeployMinime("Test MiniMe", "MMT", 18)
+	minimeProxy := chain.DeployProxy(minimeImpl)
+	farAddr := chain.DeployERC20AtSlot("Far Token", "FAR", 18, 60)
+	erc20Impl := chain.DeployERC20AtSlot("Proxied Token", "PRX", 18, 3)
+	beaconProxy, _ := chain.DeployBeaconProxy(erc20Impl)
+	vyperAddr := chain.DeployERC20WithLayout("Vyper Token", "VYP", 18, 5, helpers.LayoutVyper)
+	nftAddr := chain.DeployERC721("Test NFT", "NFT")
+	chain.Commit()
+	chain.SetERC20Balance(erc20Addr, holders[0], ether(1))
+	chain.SetMinimeBalance(minimeAddr, holders[0], ether(2))
+	chain.SetMinimeBalance(minimeProxy, holders[0], ether(3))
+	chain.SetERC20Balance(farAddr, holders[0], ether(4))
+	chain.SetERC20Balance(beaconProxy, holders[0], ether(5))
+	chain.SetERC20Balance(vyperAddr, holders[0], ether(6))
+	chain.SetERC721Owner(nftAddr, big.NewInt(1), holders[0])
+	chain.Commit()
+	src := source.NewRPC(chain.Client())
+
+	for _, tc := range []struct {
+		addr    common.Address
+		ttype   int
+		slot    int
+		impl    common.Address
+		balance int64
+	}{
+		{erc20Addr, TokenTypeMapbased, testchain.ERC20BalancesSlot, common.Address{}, 1},
+		{minimeAddr, TokenTypeMinime, testchain.MinimeBalancesSlot, common.Address{}, 2},
+		{minimeProxy, TokenTypeMinime, testchain.MinimeBalancesSlot, minimeImpl, 3},
+		{farAddr, TokenTypeMapbased, 60, common.Address{}, 4},
+		{beaconProxy, TokenTypeMapbased, 3, erc20Impl, 5},
+		{vyperAddr, TokenTypeVyper, 5, common.Address{}, 6},
+		{nftAddr, TokenTypeERC721, testchain.ERC721BalancesSlot, common.Address{}, 0},
+	} {
+		d, err := Detect(ctx, src, tc.addr, holders[0], source.Latest)
+		c.Assert(err, qt.IsNil)
+		c.Assert(d.Type, qt.Equals, tc.ttype)
+		c.Assert(d.Slot, qt.Equals, tc.slot)
+		c.Assert(d.Implementation, qt.Equals, tc.impl)
+		ch := checker(chain, d.Token, d.Slot)
+		balance := tc.balance
+		if tc.ttype == TokenTypeERC721 {
+			// The balance of an ERC721 token is its number of tokens
+			ch.Amount, balance = nil, 1
+		}
+		// The proofs of proxies include the implementation slot
+		ch.Extra = func(c *qt.C, _ tokentest.Case, sp *ethstorageproof.StorageProof) {
+			if tc.impl == (common.Address{}) {
+				c.Assert(sp.Implementation, qt.IsNil)
+				return
+			}
+			c.Assert(sp.Implementation, qt.Not(qt.IsNil))
+			c.Assert(sp.Implementation.Implementation, qt.Equals, tc.impl)
+		}
+		ch.Check(c, tokentest.Case{Holder: holders[0], Block: 2, Balance: balance})
+	}
+
+	_, err := Detect(ctx, src, holders[0], holders[0], source.Latest)
+	c.Assert(errors.Is(err, ErrNotContract), qt.IsTrue, qt.Commentf("%v", err))
+	// Without tracing the Vyper balances map is brute forced
+	d, err := Detect(ctx, untracedSource{src}, vyperAddr, holders[0], source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(d.Type, qt.Equals, TokenTypeVyper)
+	c.Assert(d.Slot, qt.Equals, 5)
+	// Without tracing the far balances map is not found
+	_, err = Detect(ctx, untracedSource{src}, farAddr, holders[0], source.Latest)
+	c.Assert(errors.Is(err, ErrUnknownTokenType), qt.IsTrue, qt.Commentf("%v", err))
+
+	// The registry detects the type of unknown contracts
+	tk, e, err := NewRegistry().Resolve(ctx, src, TokenTypeAuto, minimeAddr, holders[0],
+		source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(e.Type, qt.Equals, "minime")
+	checker(chain, tk, e.Slot).Check(c, tokentest.Case{Holder: holders[0], Block: 2, Balance: 2})
+}
+
+func TestHasAnySelector(t *testing.T) {
+	c := qt.New(t)
+	sigs := []string{"balanceOfAt(address,uint256)"}
+	// PUSH4 0x4ee2cd7e
+	c.Assert(hasAnySelector(common.FromHex("0x634ee2cd7e"), sigs), qt.IsTrue)
+	// The selector as PUSH32 data is not pushed
+	c.Assert(hasAnySelector(common.FromHex("0x7f634ee2cd7e"), sigs), qt.IsFalse)
+	c.Assert(hasAnySelector(common.FromHex("0x634ee2cd"), sigs), qt.IsFalse)
+}
@@ -0,0 +1,237 @@
+// Package erc1155 gets and verifies storage proofs of OpenZeppelin style
+// ERC1155 (multi token) tokens.
+package erc1155
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
+)
+
+const (
+	// BalancesSlot is the index slot of the _balances map of the
+	// OpenZeppelin ERC1155 contract
+	BalancesSlot = 0
+)
+
+var (
+	// TransferSingleTopic is the topic of the
+	// TransferSingle(address,address,address,uint256,uint256) event
+	TransferSingleTopic = crypto.Keccak256Hash(
+		[]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	// TransferBatchTopic is the topic of the
+	// TransferBatch(address,address,address,uint256[],uint256[]) event
+	TransferBatchTopic = crypto.Keccak256Hash(
+		[]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+	// balanceOfSelector is the selector of the balanceOf(address,uint256)
+	// method
+	balanceOfSelector = crypto.Keccak256([]byte("balanceOf(address,uint256)"))[:4]
+)
+
+// ERC1155 tokens store the balances on a nested map
+// `uint256 => address => uint256` (_balances), keyed by the token id and the
+// holder, as the OpenZeppelin ERC1155 contract does.  As a token.Token, the
+// balance of a holder is its balance of the token id of the ERC1155, and the
+// index slot is the one of the _balances map.
+type ERC1155 struct {
+	erc20 *erc20.ERC20Token
+	id    *big.Int
+}
+
+// New creates a new ERC1155 to get and verify the ERC1155 token proofs of the
+// token id
+func New(ctx context.Context, src source.ProofSource, tokenAddress common.Address,
+	id *big.Int) (*ERC1155, error) {
+	if id == nil || id.Sign() < 0 {
+		return nil, fmt.Errorf("invalid token id %v", id)
+	}
+	token, err := erc20.New(ctx, src, tokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &ERC1155{erc20: token, id: new(big.Int).Set(id)}, nil
+}
+
+// ID returns the token id
+func (e *ERC1155) ID() *big.Int {
+	return new(big.Int).Set(e.id)
+}
+
+// WithID returns an ERC1155 for the token id of the same contract
+func (e *ERC1155) WithID(id *big.Int) *ERC1155 {
+	return &ERC1155{erc20: e.erc20, id: new(big.Int).Set(id)}
+}
+
+// BalanceOfAtHash returns the balance of the token id of holder at the block
+// with blockHash, calling the balanceOf method.
+func (e *ERC1155) BalanceOfAtHash(ctx context.Context, holder common.Address,
+	blockHash common.Hash) (*big.Int, error) {
+	out, err := e.erc20.Source.CallContractAtHash(ctx, e.balanceOfCall(holder), blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("balanceOf: %w", err)
+	}
+	if len(out) != common.HashLength {
+		return nil, fmt.Errorf("invalid balanceOf output %x", out)
+	}
+	return new(big.Int).SetBytes(out), nil
+}
+
+// balanceOfCall returns the balanceOf(holder, id) call
+func (e *ERC1155) balanceOfCall(holder common.Address) ethereum.CallMsg {
+	data := append(common.CopyBytes(balanceOfSelector), common.LeftPadBytes(holder[:], 32)...)
+	data = append(data, common.LeftPadBytes(e.id.Bytes(), 32)...)
+	return ethereum.CallMsg{To: &e.erc20.TokenAddr, Data: data}
+}
+
+// DiscoverSlot tries to find the index slot of the _balances map.
+// If the source implements source.StorageTracer, the index slots read by
+// balanceOf are checked first, otherwise the first erc20.DiscoveryIterations
+// index slots are checked.
+// A token holder address with a balance of the token id must be provided in
+// order to have a value to search and compare.  If holder is the zero
+// address, a holder is picked from the recent transfer events.  Returns
+// erc20.ErrZeroBalance if the holder has no balance, and erc20.ErrSlotNotFound
+// if the slot cannot be found.  If found, returns also the balance at the
+// block referred by ref.
+func (e *ERC1155) DiscoverSlot(ctx context.Context, holder common.Address,
+	ref source.BlockRef) (int, *big.Rat, error) {
+	// All the reads are done at the same block, so the balance can not
+	// change while searching for it
+	header, err := e.erc20.GetBlockHeader(ctx, ref)
+	if err != nil {
+		return -1, nil, fmt.Errorf("cannot get block header: %w", err)
+	}
+	blockHash := header.Hash()
+	var balance *big.Int
+	if holder == (common.Address{}) {
+		if holder, balance, err = e.FindHolder(ctx, header); err != nil {
+			return -1, nil, err
+		}
+	} else {
+		if balance, err = e.BalanceOfAtHash(ctx, holder, blockHash); err != nil {
+			return -1, nil, err
+		}
+		if balance.Sign() == 0 {
+			return -1, nil, fmt.Errorf("%w: %s", erc20.ErrZeroBalance, holder.Hex())
+		}
+	}
+
+	index, err := e.erc20.DiscoverValueSlot(ctx, e.balanceOfCall(holder).Data,
+		func(i int) [32]byte { return helpers.GetNestedMapSlot(e.id, holder, i) }, blockHash,
+		erc20.DiscoveryIterations, balance)
+	if err != nil {
+		return -1, nil, err
+	}
+	return index, new(big.Rat).SetInt(balance), nil
+}
+
+// FindHolder returns an address with a non zero balance of the token id at
+// the block of header, and its balance.  The recipients of the most recent
+// TransferSingle and TransferBatch events of the token id up to the block are
+// checked.  Returns erc20.ErrHolderNotFound if none of them has a balance.
+func (e *ERC1155) FindHolder(ctx context.Context,
+	header *ethstorageproof.BlockHeader) (common.Address, *big.Int, error) {
+	recipient := func(l *types.Log) (common.Address, bool) {
+		if len(l.Topics) != 4 {
+			return common.Address{}, false
+		}
+		to := common.BytesToAddress(l.Topics[3][:])
+		switch l.Topics[0] {
+		case TransferSingleTopic:
+			// The token id is the first word of the data
+			if len(l.Data) == 64 && new(big.Int).SetBytes(l.Data[:32]).Cmp(e.id) == 0 {
+				return to, true
+			}
+		case TransferBatchTopic:
+			ids, err := batchIDs(l.Data)
+			if err != nil {
+				return common.Address{}, false
+			}
+			for _, id := range ids {
+				if id.Cmp(e.id) == 0 {
+					return to, true
+				}
+			}
+		}
+		return common.Address{}, false
+	}
+	return e.erc20.FindHolderInLogs(ctx, header,
+		[]common.Hash{TransferSingleTopic, TransferBatchTopic}, recipient, e.BalanceOfAtHash)
+}
+
+// batchIDs returns the token ids of the data of a TransferBatch event, the
+// ABI encoded ids and values arrays, which must have the same length.
+func batchIDs(data []byte) ([]*big.Int, error) {
+	values, err := batchArguments.Unpack(data)
+	if err != nil {
+		return nil, err
+	}
+	ids, amounts := values[0].([]*big.Int), values[1].([]*big.Int)
+	if len(ids) != len(amounts) {
+		return nil, fmt.Errorf("%d token ids and %d values", len(ids), len(amounts))
+	}
+	return ids, nil
+}
+
+// batchArguments are the non indexed arguments of the TransferBatch event
+var batchArguments = func() abi.Arguments {
+	uint256Array, err := abi.NewType("uint256[]", "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return abi.Arguments{{Name: "ids", Type: uint256Array}, {Name: "values", Type: uint256Array}}
+}()
+
+// GetProof returns the storage proof of the balance of the token id of
+// holder, on the _balances map at index slot islot, at the block referred by
+// ref.
+func (e *ERC1155) GetProof(ctx context.Context, holder common.Address,
+	ref source.BlockRef, islot int) (*ethstorageproof.StorageProof, error) {
+	slot := helpers.GetNestedMapSlot(e.id, holder, islot)
+	return e.erc20.GetProof(ctx, [][]byte{slot[:]}, ref)
+}
+
+// VerifyProof verifies the storage proof of the balance of the token id of
+// holder, which must be targetBalance.
+func (e *ERC1155) VerifyProof(holder common.Address, storageRoot common.Hash,
+	proofs []ethstorageproof.StorageResult, balancesSlot int, targetBalance,
+	targetBlock *big.Int) error {
+	if len(proofs) != 1 {
+		return fmt.Errorf("invalid length of proofs %d", len(proofs))
+	}
+	return VerifyProof(holder, e.id, storageRoot, proofs[0], balancesSlot, targetBalance)
+}
+
+// VerifyProof verifies the storage proof of the balance of the token id of
+// holder on the _balances map at index slot balancesSlot, which must be
+// targetBalance.
+func VerifyProof(holder common.Address, id *big.Int, storageRoot common.Hash,
+	proof ethstorageproof.StorageResult, balancesSlot int, targetBalance *big.Int) error {
+	if id == nil || targetBalance == nil {
+		return fmt.Errorf("token id or target balance is nil")
+	}
+	keySlot := helpers.GetNestedMapSlot(id, holder, balancesSlot)
+	if !bytes.Equal(keySlot[:], proof.Key) {
+		return fmt.Errorf("%w: proof key and holder do not match (%x != %x)",
+			ethstorageproof.ErrBadKey, keySlot, proof.Key)
+	}
+	if balance := new(big.Int).SetBytes(proof.Value); balance.Cmp(targetBalance) != 0 {
+		return fmt.Errorf("%w: proof balance and provided balance mismatch (%v != %v)",
+			ethstorageproof.ErrValueMismatch, balance, targetBalance)
+	}
+	if _, err := ethstorageproof.VerifyEthStorageProof(&proof, storageRoot); err != nil {
+		return fmt.Errorf("proof is not valid: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package erc1155
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/testchain"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/tokentest"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
+)
+
+func TestERC1155(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	alice, bob := tokentest.Alice, tokentest.Bob
+	chain := testchain.New()
+	defer chain.Close()
+	addr := chain.DeployERC1155()
+	chain.Commit()
+	tokentest.Play(chain, []tokentest.Step{
+		{Block: 2, Holder: alice, ID: 1, Value: 10},
+		{Block: 2, Holder: bob, ID: 2, Value: 20},
+		{Block: 3, Holder: alice, ID: 1, Value: 15},
+	}, func(s tokentest.Step) {
+		chain.SetERC1155Balance(addr, big.NewInt(s.ID), s.Holder, big.NewInt(s.Value))
+	})
+	src := source.NewRPC(chain.Client())
+	block2 := source.BlockNumber(big.NewInt(2))
+
+	e, err := New(ctx, src, addr, big.NewInt(1))
+	c.Assert(err, qt.IsNil)
+	slot, amount, err := e.DiscoverSlot(ctx, alice, block2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, BalancesSlot)
+	c.Assert(amount.Cmp(big.NewRat(10, 1)), qt.Equals, 0)
+
+	for _, tc := range []struct {
+		e     *ERC1155
+		cases []tokentest.Case
+	}{
+		{e, []tokentest.Case{
+			{Holder: alice, Block: 2, Balance: 10},
+			{Holder: alice, Block: 3, Balance: 15},
+		}},
+		{e.WithID(big.NewInt(2)), []tokentest.Case{
+			{Holder: bob, Block: 3, Balance: 20},
+		}},
+	} {
+		id := tc.e.ID()
+		checker := &tokentest.Checker{Chain: chain, Token: tc.e, Slot: slot}
+		checker.Extra = func(c *qt.C, vc tokentest.Case, sp *ethstorageproof.StorageProof) {
+			// The proof of a token id does not prove the balance of another one
+			for _, other := range []*big.Int{big.NewInt(0), big.NewInt(3),
+				new(big.Int).Add(id, big.NewInt(1))} {
+				err := VerifyProof(vc.Holder, other, sp.StorageHash, sp.StorageProof[0], slot,
+					big.NewInt(vc.Balance))
+				c.Assert(errors.Is(err, ethstorageproof.ErrBadKey), qt.IsTrue)
+			}
+		}
+		checker.Check(c, tc.cases...)
+	}
+
+	// A holder is picked from the TransferSingle events of the token id
+	slot, amount, err = e.WithID(big.NewInt(2)).DiscoverSlot(ctx, common.Address{},
+		source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, BalancesSlot)
+	c.Assert(amount.Cmp(big.NewRat(20, 1)), qt.Equals, 0)
+
+	// and from the TransferBatch events
+	chain.MintERC1155Batch(addr, bob, []*big.Int{big.NewInt(4), big.NewInt(5)},
+		[]*big.Int{big.NewInt(40), big.NewInt(50)})
+	chain.Commit()
+	header, err := src.BlockHeader(ctx, source.Latest)
+	c.Assert(err, qt.IsNil)
+	holder, balance, err := e.WithID(big.NewInt(5)).FindHolder(ctx, header)
+	c.Assert(err, qt.IsNil)
+	c.Assert(holder, qt.Equals, bob)
+	c.Assert(balance.Int64(), qt.Equals, int64(50))
+	slot, amount, err = e.WithID(big.NewInt(4)).DiscoverSlot(ctx, common.Address{},
+		source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, BalancesSlot)
+	c.Assert(amount.Cmp(big.NewRat(40, 1)), qt.Equals, 0)
+
+	_, _, err = e.DiscoverSlot(ctx, bob, source.Latest)
+	c.Assert(errors.Is(err, erc20.ErrZeroBalance), qt.IsTrue)
+	_, _, err = e.WithID(big.NewInt(3)).DiscoverSlot(ctx, common.Address{}, source.Latest)
+	c.Assert(errors.Is(err, erc20.ErrHolderNotFound), qt.IsTrue)
+}
@@ -2,44 +2,89 @@ package erc20
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
+	"sort"
 	"strings"
 
-	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
 	"github.com/vocdoni/storage-proofs-eth-go/helpers"
 	contracts "github.com/vocdoni/storage-proofs-eth-go/ierc20"
+	"github.com/vocdoni/storage-proofs-eth-go/proxy"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
-// ERC20Token holds a reference to a go-ethereum client,
-// to an ERC20 like contract and to an ENS.
+const (
+	// HolderSearchBlocks is the number of blocks of each Transfer logs
+	// query done by FindHolder
+	HolderSearchBlocks = 2000
+	// HolderSearchQueries is the maximum number of Transfer logs queries
+	// done by FindHolder, going back from the requested block
+	HolderSearchQueries = 10
+	// HolderSearchCandidates is the maximum number of Transfer recipients
+	// which balance is checked by FindHolder
+	HolderSearchCandidates = 20
+	// MaxTracedIndexSlot is the highest index slot matched against the
+	// storage keys read by balanceOf
+	MaxTracedIndexSlot = 1024
+	// DiscoveryIterations is the number of index slots, from 0, checked when
+	// discovering the slot of a map which is not found tracing the calls
+	DiscoveryIterations = 30
+)
+
+var (
+	// TransferTopic is the topic of the Transfer(address,address,uint256)
+	// event
+	TransferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	// balanceOfSelector is the selector of the balanceOf(address) method
+	balanceOfSelector = crypto.Keccak256([]byte("balanceOf(address)"))[:4]
+	// decimalsSelector is the selector of the decimals() method
+	decimalsSelector = crypto.Keccak256([]byte("decimals()"))[:4]
+
+	// ErrHolderNotFound is returned when no holder with a balance is found
+	ErrHolderNotFound = errors.New("token holder not found")
+	// ErrSlotNotFound represents the storage slot not found error
+	ErrSlotNotFound = errors.New("storage slot not found")
+	// ErrZeroBalance is returned when searching for the storage of a zero
+	// balance, which matches any unused slot
+	ErrZeroBalance = errors.New("holder has no balance")
+)
+
+// ERC20Token holds a reference to a ProofSource and to an ERC20 like
+// contract.
 // It is expected for the ERC20 contract to implement the standard
 // optional ERC20 functions: {name, symbol, decimals, totalSupply}
 type ERC20Token struct {
-	RPCCli    *rpc.Client
-	EthCli    *ethclient.Client
+	Source    source.ProofSource
 	token     *contracts.TokenCaller
 	TokenAddr common.Address
+	// TransferTopics is the number of topics of the Transfer events of the
+	// token checked by FindHolder: 3 for ERC20, 4 for ERC721 tokens, which
+	// index the token id.
+	TransferTopics int
+	// proxies caches the proxy detected at each block by GetProof
+	proxies proxy.Cache
 }
 
 // New creates a new ERC20Token to access ERC20 token data and get storage proofs
-func New(ctx context.Context, rpcCli *rpc.Client,
+func New(ctx context.Context, src source.ProofSource,
 	contractAddress common.Address) (*ERC20Token, error) {
-	ethCli := ethclient.NewClient(rpcCli)
-	token, err := contracts.NewTokenCaller(contractAddress, ethCli)
+	token, err := contracts.NewTokenCaller(contractAddress, src)
 	if err != nil {
 		return nil, err
 	}
 	return &ERC20Token{
-		RPCCli:    rpcCli,
-		EthCli:    ethCli,
-		token:     token,
-		TokenAddr: contractAddress,
+		Source:         src,
+		token:          token,
+		TokenAddr:      contractAddress,
+		TransferTopics: 3,
 	}, nil
 }
 
@@ -77,7 +122,14 @@ func (w *ERC20Token) GetTokenData(ctx context.Context) (*TokenData, error) {
 
 // Balance returns the current address balance
 func (w *ERC20Token) Balance(ctx context.Context, address common.Address) (*big.Rat, error) {
-	b, err := w.token.BalanceOf(&bind.CallOpts{Context: ctx}, address)
+	return w.BalanceAtHash(ctx, address, common.Hash{})
+}
+
+// BalanceAtHash returns the address balance at the block with blockHash.  If
+// blockHash is zero, the current balance is returned.
+func (w *ERC20Token) BalanceAtHash(ctx context.Context, address common.Address,
+	blockHash common.Hash) (*big.Rat, error) {
+	b, err := w.BalanceOfAtHash(ctx, address, blockHash)
 	if err != nil {
 		return nil, err
 	}
@@ -88,6 +140,226 @@ func (w *ERC20Token) Balance(ctx context.Context, address common.Address) (*big.
 	return helpers.BalanceToRat(b, int(decimals)), nil
 }
 
+// BalanceOfAtHash returns the full address balance, without decimals, at the
+// block with blockHash.  If blockHash is zero, the current balance is returned.
+func (w *ERC20Token) BalanceOfAtHash(ctx context.Context, address common.Address,
+	blockHash common.Hash) (*big.Int, error) {
+	return w.token.BalanceOf(&bind.CallOpts{Context: ctx, BlockHash: blockHash}, address)
+}
+
+// DiscoveryBalance returns the full balance of holder at the block of header,
+// to be searched for on the contract storage.  If holder is the zero address,
+// a holder is picked with FindHolder.  Returns ErrZeroBalance if the balance
+// is zero, since it would match any unused storage slot.
+func (w *ERC20Token) DiscoveryBalance(ctx context.Context, holder common.Address,
+	header *ethstorageproof.BlockHeader) (common.Address, *big.Int, error) {
+	if holder == (common.Address{}) {
+		return w.FindHolder(ctx, header)
+	}
+	balance, err := w.BalanceOfAtHash(ctx, holder, header.Hash())
+	if err != nil {
+		return holder, nil, fmt.Errorf("balance: %w", err)
+	}
+	if balance.Sign() == 0 {
+		return holder, nil, fmt.Errorf("%w: %s", ErrZeroBalance, holder.Hex())
+	}
+	return holder, balance, nil
+}
+
+// MapSlotFunc returns the storage key slot of a value on the map at index
+// slot position, such as the key of a holder on a balances map.
+type MapSlotFunc func(position int) [32]byte
+
+// LayoutMapSlot returns the MapSlotFunc of holder on the maps of layout
+func LayoutMapSlot(layout helpers.MapLayout, holder common.Address) MapSlotFunc {
+	return func(position int) [32]byte {
+		return layout.MapSlot(holder, position)
+	}
+}
+
+// BalanceOfIndexSlots returns the index slots of the maps keyed by holder
+// which the token contract storage is read by balanceOf(holder), at the block
+// with blockHash.  The source must implement source.StorageTracer.
+//
+// The storage keys are matched against the map slot formula of layout for
+// the index slots up to MaxTracedIndexSlot, so the maps of ERC-7201
+// namespaced storage, which base slot is a hash, are not found.
+func (w *ERC20Token) BalanceOfIndexSlots(ctx context.Context, holder common.Address,
+	blockHash common.Hash, layout helpers.MapLayout) ([]int, error) {
+	return w.CallIndexSlots(ctx, w.BalanceOfData(holder), LayoutMapSlot(layout, holder),
+		blockHash)
+}
+
+// BalanceOfData returns the call data of balanceOf(holder)
+func (w *ERC20Token) BalanceOfData(holder common.Address) []byte {
+	return append(common.CopyBytes(balanceOfSelector), common.LeftPadBytes(holder[:], 32)...)
+}
+
+// CallIndexSlots returns the index slots of the maps which the token
+// contract storage is read by the call with data at the key slot given by
+// mapSlot, at the block with blockHash, as BalanceOfIndexSlots does for
+// balanceOf.
+func (w *ERC20Token) CallIndexSlots(ctx context.Context, data []byte, mapSlot MapSlotFunc,
+	blockHash common.Hash) ([]int, error) {
+	tracer, ok := w.Source.(source.StorageTracer)
+	if !ok {
+		return nil, fmt.Errorf("source does not implement StorageTracer")
+	}
+	keys, err := tracer.StorageKeys(ctx, ethereum.CallMsg{To: &w.TokenAddr, Data: data},
+		blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("cannot trace call: %w", err)
+	}
+	if len(keys[w.TokenAddr]) == 0 {
+		return nil, nil
+	}
+	positions := make(map[common.Hash]int, MaxTracedIndexSlot+1)
+	for i := 0; i <= MaxTracedIndexSlot; i++ {
+		positions[mapSlot(i)] = i
+	}
+	var slots []int
+	traced := make(map[int]bool)
+	for _, key := range keys[w.TokenAddr] {
+		if i, ok := positions[key]; ok && !traced[i] {
+			traced[i] = true
+			slots = append(slots, i)
+		}
+	}
+	sort.Ints(slots)
+	return slots, nil
+}
+
+// DiscoveryIndexSlots returns the index slots to check when discovering the
+// balances map of holder with layout: the ones read by balanceOf(holder)
+// first, if the call can be traced, and then the ones from 0 to
+// iterations-1.
+func (w *ERC20Token) DiscoveryIndexSlots(ctx context.Context, holder common.Address,
+	blockHash common.Hash, layout helpers.MapLayout, iterations int) []int {
+	return w.DiscoveryCallIndexSlots(ctx, w.BalanceOfData(holder),
+		LayoutMapSlot(layout, holder), blockHash, iterations)
+}
+
+// DiscoveryCallIndexSlots returns the index slots to check when discovering
+// a map with the key slot given by mapSlot: the ones read by the call with
+// data first, if the call can be traced, and then the ones from 0 to
+// iterations-1.
+func (w *ERC20Token) DiscoveryCallIndexSlots(ctx context.Context, data []byte,
+	mapSlot MapSlotFunc, blockHash common.Hash, iterations int) []int {
+	// If the call cannot be traced, the index slots are brute forced
+	slots, _ := w.CallIndexSlots(ctx, data, mapSlot, blockHash)
+	traced := make(map[int]bool)
+	for _, i := range slots {
+		traced[i] = true
+	}
+	for i := 0; i < iterations; i++ {
+		if !traced[i] {
+			slots = append(slots, i)
+		}
+	}
+	return slots
+}
+
+// DiscoverIndexSlot returns the first of the index slots returned by
+// DiscoveryCallIndexSlots for which match returns true.  Returns
+// ErrSlotNotFound if there is none.
+func (w *ERC20Token) DiscoverIndexSlot(ctx context.Context, data []byte, mapSlot MapSlotFunc,
+	blockHash common.Hash, iterations int, match func(position int) (bool, error)) (int, error) {
+	for _, i := range w.DiscoveryCallIndexSlots(ctx, data, mapSlot, blockHash, iterations) {
+		ok, err := match(i)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, ErrSlotNotFound
+}
+
+// DiscoverValueSlot returns the index slot of the map which holds value at
+// the key slot given by mapSlot, at the block with blockHash, checking the
+// index slots as DiscoverIndexSlot does.  Returns ErrSlotNotFound if none
+// holds value.
+func (w *ERC20Token) DiscoverValueSlot(ctx context.Context, data []byte, mapSlot MapSlotFunc,
+	blockHash common.Hash, iterations int, value *big.Int) (int, error) {
+	return w.DiscoverIndexSlot(ctx, data, mapSlot, blockHash, iterations,
+		func(position int) (bool, error) {
+			stored, err := w.Source.StorageAtHash(ctx, w.TokenAddr, mapSlot(position), blockHash)
+			if err != nil {
+				return false, err
+			}
+			return new(big.Int).SetBytes(stored).Cmp(value) == 0, nil
+		})
+}
+
+// FindHolder returns an address with a non zero balance at the block of
+// header, and its full balance.  The recipients of the most recent Transfer
+// events up to the block are checked.  Returns ErrHolderNotFound if none of
+// them has a balance.
+func (w *ERC20Token) FindHolder(ctx context.Context,
+	header *ethstorageproof.BlockHeader) (common.Address, *big.Int, error) {
+	recipient := func(l *types.Log) (common.Address, bool) {
+		// Not a Transfer of the token standard (ERC721 has an indexed
+		// token id)
+		if len(l.Topics) != w.TransferTopics {
+			return common.Address{}, false
+		}
+		return common.BytesToAddress(l.Topics[2][:]), true
+	}
+	return w.FindHolderInLogs(ctx, header, []common.Hash{TransferTopic}, recipient,
+		w.BalanceOfAtHash)
+}
+
+// FindHolderInLogs returns an address with a non zero balance at the block
+// of header, and its balance.  The accounts of the most recent events of the
+// token contract with any of topics up to the block are checked: account
+// returns the account of an event, if any, and balance returns its balance at
+// a block.
+// Returns ErrHolderNotFound if none of them has a balance.
+func (w *ERC20Token) FindHolderInLogs(ctx context.Context, header *ethstorageproof.BlockHeader,
+	topics []common.Hash, account func(*types.Log) (common.Address, bool),
+	balance func(context.Context, common.Address, common.Hash) (*big.Int, error),
+) (common.Address, *big.Int, error) {
+	blockHash := header.Hash()
+	checked := make(map[common.Address]bool)
+	to := new(big.Int).Set(header.Number)
+	for i := 0; i < HolderSearchQueries && to.Sign() >= 0; i++ {
+		from := new(big.Int).Sub(to, big.NewInt(HolderSearchBlocks-1))
+		if from.Sign() < 0 {
+			from.SetInt64(0)
+		}
+		logs, err := w.Source.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: from,
+			ToBlock:   to,
+			Addresses: []common.Address{w.TokenAddr},
+			Topics:    [][]common.Hash{topics},
+		})
+		if err != nil {
+			return common.Address{}, nil, fmt.Errorf("cannot get logs: %w", err)
+		}
+		// The most recent accounts are more likely to keep a balance
+		for j := len(logs) - 1; j >= 0; j-- {
+			holder, ok := account(&logs[j])
+			if !ok || holder == (common.Address{}) || checked[holder] {
+				continue
+			}
+			if len(checked) == HolderSearchCandidates {
+				return common.Address{}, nil, ErrHolderNotFound
+			}
+			checked[holder] = true
+			amount, err := balance(ctx, holder, blockHash)
+			if err != nil {
+				return common.Address{}, nil, fmt.Errorf("balance: %w", err)
+			}
+			if amount.Sign() > 0 {
+				return holder, amount, nil
+			}
+		}
+		to.Sub(from, big.NewInt(1))
+	}
+	return common.Address{}, nil, ErrHolderNotFound
+}
+
 // TokenName wraps the name() function contract call
 func (w *ERC20Token) TokenName(ctx context.Context) (string, error) {
 	return w.token.Name(&bind.CallOpts{Context: ctx})
@@ -103,31 +375,49 @@ func (w *ERC20Token) TokenDecimals(ctx context.Context) (uint8, error) {
 	return w.token.Decimals(&bind.CallOpts{Context: ctx})
 }
 
+// DiscoveryDecimals returns the decimals of the balances found when
+// discovering a slot, at the block with blockHash: the token decimals, or 0
+// if the token does not implement the optional decimals method, so the
+// balance is kept in its base unit.  A call which reverts or returns no data
+// is taken as a token without decimals; any other error is returned.
+func (w *ERC20Token) DiscoveryDecimals(ctx context.Context, blockHash common.Hash) (int, error) {
+	out, err := w.Source.CallContractAtHash(ctx,
+		ethereum.CallMsg{To: &w.TokenAddr, Data: decimalsSelector}, blockHash)
+	if err != nil {
+		if strings.Contains(err.Error(), "execution reverted") {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("cannot get token decimals: %w", err)
+	}
+	if len(out) == 0 {
+		return 0, nil
+	}
+	if len(out) != common.HashLength {
+		return 0, fmt.Errorf("invalid decimals output %x", out)
+	}
+	decimals := new(big.Int).SetBytes(out)
+	if !decimals.IsUint64() || decimals.Uint64() > 255 {
+		return 0, fmt.Errorf("invalid decimals %v", decimals)
+	}
+	return int(decimals.Uint64()), nil
+}
+
 // TokenTotalSupply wraps the totalSupply function contract call
 func (w *ERC20Token) TokenTotalSupply(ctx context.Context) (*big.Int, error) {
 	return w.token.TotalSupply(&bind.CallOpts{Context: ctx})
 }
 
-// GetProof calls the eth_getProof web3 method.  If block is nil, the proof at
-// the latest block will be retreived.
+// GetProof returns the storage proofs of the token contract keys at the block
+// referred by ref.  If the token is a proxy, the proof includes the proof of
+// its implementation slot.  The proxy is detected once for each block.
 func (w *ERC20Token) GetProof(ctx context.Context, keys [][]byte,
-	block *big.Int) (*ethstorageproof.StorageProof, error) {
-	blockData, err := w.EthCli.BlockByNumber(ctx, block)
-	if err != nil {
-		return nil, err
-	}
-	var resp ethstorageproof.StorageProof
-	if err := w.RPCCli.CallContext(
-		ctx,
-		&resp,
-		"eth_getProof",
-		fmt.Sprintf("0x%x", w.TokenAddr),
-		ethstorageproof.SliceData(keys),
-		helpers.ToBlockNumArg(block),
-	); err != nil {
-		return nil, err
-	}
-	resp.StateRoot = blockData.Root()
-	resp.Height = blockData.Header().Number
-	return &resp, nil
+	ref source.BlockRef) (*ethstorageproof.StorageProof, error) {
+	return w.proxies.GetProof(ctx, w.Source, w.TokenAddr, keys, ref)
+}
+
+// GetBlockHeader returns the header of the block referred by ref, so its
+// block hash can be verified.
+func (w *ERC20Token) GetBlockHeader(ctx context.Context,
+	ref source.BlockRef) (*ethstorageproof.BlockHeader, error) {
+	return w.Source.BlockHeader(ctx, ref)
 }
@@ -0,0 +1,511 @@
+// Package erc20votes gets and verifies storage proofs of the voting power of
+// the delegates of OpenZeppelin ERC20Votes (and Votes) tokens, at a past block
+// or timestamp.
+package erc20votes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
+	"github.com/vocdoni/storage-proofs-eth-go/token/minime"
+)
+
+const (
+	// CheckpointsSlot is the index slot of the _checkpoints map of the
+	// OpenZeppelin 4 ERC20Votes contract, with no other state variables
+	CheckpointsSlot = 8
+)
+
+var (
+	// ErrNoCheckpoint is returned when the delegate has no checkpoint at or
+	// before the timepoint of the proof
+	ErrNoCheckpoint = errors.New("no checkpoint at the timepoint")
+	// ErrUnknownFormat is returned when the checkpoints format of the token
+	// is not known, nor can be detected
+	ErrUnknownFormat = errors.New("checkpoints format unknown")
+
+	// DelegateVotesChangedTopic is the topic of the
+	// DelegateVotesChanged(address,uint256,uint256) event
+	DelegateVotesChangedTopic = crypto.Keccak256Hash(
+		[]byte("DelegateVotesChanged(address,uint256,uint256)"))
+	// getVotesSelector is the selector of the getVotes(address) method
+	getVotesSelector = crypto.Keccak256([]byte("getVotes(address)"))[:4]
+	// clockModeSelector is the selector of the CLOCK_MODE() method
+	clockModeSelector = crypto.Keccak256([]byte("CLOCK_MODE()"))[:4]
+)
+
+// Format is the packing of the checkpoints of a token.  Each checkpoint is a
+// storage word holding the votes in the upper bits and the key (the block
+// number or timestamp the votes are set from) in the lower bits.
+type Format int
+
+const (
+	// FormatUnknown is the format of the tokens which format has not been
+	// detected yet
+	FormatUnknown Format = iota
+	// FormatV4 is the `(uint32 fromBlock, uint224 votes)` checkpoint of
+	// OpenZeppelin 4
+	FormatV4
+	// FormatV5 is the `(uint48 key, uint208 value)` checkpoint of
+	// OpenZeppelin 5
+	FormatV5
+)
+
+// Formats holds the known checkpoint formats, in detection order
+var Formats = []Format{FormatV4, FormatV5}
+
+// KeyBits returns the number of bits of the checkpoint key, or 0 if the
+// format is unknown
+func (f Format) KeyBits() uint {
+	switch f {
+	case FormatV4:
+		return 32
+	case FormatV5:
+		return 48
+	default:
+		return 0
+	}
+}
+
+// String returns the name of the format
+func (f Format) String() string {
+	switch f {
+	case FormatV4:
+		return "v4"
+	case FormatV5:
+		return "v5"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(f))
+	}
+}
+
+// ParseCheckpoint splits the storage value of a checkpoint of format into
+// its votes and its key
+func (f Format) ParseCheckpoint(value []byte) (votes, key *big.Int) {
+	value = common.LeftPadBytes(value, 32)
+	split := common.HashLength - int(f.KeyBits()/8)
+	return new(big.Int).SetBytes(value[:split]), new(big.Int).SetBytes(value[split:])
+}
+
+// Clock is the kind of timepoint of the checkpoints keys of a token, as
+// reported by the ERC6372 CLOCK_MODE method
+type Clock int
+
+const (
+	// ClockBlockNumber keys the checkpoints by block number.  It is the
+	// clock of the tokens which do not implement CLOCK_MODE.
+	ClockBlockNumber Clock = iota
+	// ClockTimestamp keys the checkpoints by block timestamp
+	ClockTimestamp
+)
+
+// String returns the name of the clock
+func (c Clock) String() string {
+	if c == ClockTimestamp {
+		return "timestamp"
+	}
+	return "blocknumber"
+}
+
+// Timepoint returns the timepoint of the block of header on the clock
+func (c Clock) Timepoint(header *ethstorageproof.BlockHeader) *big.Int {
+	if c == ClockTimestamp {
+		return new(big.Int).SetUint64(header.Time)
+	}
+	return new(big.Int).Set(header.Number)
+}
+
+// ERC20Votes tokens store the voting power of each delegate on a map
+// `address => Checkpoint[]` (_checkpoints), the history of its votes.  To
+// prove the votes at a timepoint two storage proofs are needed, the one of
+// the last checkpoint at or before the timepoint, and the one of the next
+// checkpoint, which is either after the timepoint or does not exist.  As a
+// token.Token, the balance of a holder is its voting power and the index slot
+// is the one of the _checkpoints map.
+type ERC20Votes struct {
+	erc20 *erc20.ERC20Token
+	// lock guards format, which DiscoverSlot and GetProof set once detected
+	lock   sync.RWMutex
+	format Format
+}
+
+// New creates a new ERC20Votes to get and verify ERC20Votes token proofs.  The
+// checkpoints format is detected by DiscoverSlot or GetProof, and the clock
+// is read from the CLOCK_MODE method at the block of each proof.
+func New(ctx context.Context, src source.ProofSource,
+	tokenAddress common.Address) (*ERC20Votes, error) {
+	token, err := erc20.New(ctx, src, tokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &ERC20Votes{erc20: token}, nil
+}
+
+// NewWithFormat creates a new ERC20Votes to get and verify the proofs of an
+// ERC20Votes token with the checkpoints format.  The format must be fixed by
+// the verifier: the same storage value holds different votes on each format.
+func NewWithFormat(ctx context.Context, src source.ProofSource, tokenAddress common.Address,
+	format Format) (*ERC20Votes, error) {
+	if format.KeyBits() == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownFormat, format)
+	}
+	e, err := New(ctx, src, tokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	e.setFormat(format)
+	return e, nil
+}
+
+// ClockAtHash returns the clock of the token at the block with blockHash,
+// calling its CLOCK_MODE method.
+func (e *ERC20Votes) ClockAtHash(ctx context.Context, blockHash common.Hash) (Clock, error) {
+	out, err := e.erc20.Source.CallContractAtHash(ctx,
+		ethereum.CallMsg{To: &e.erc20.TokenAddr, Data: clockModeSelector}, blockHash)
+	if err != nil {
+		// Tokens before ERC6372 use block numbers
+		if strings.Contains(err.Error(), "execution reverted") {
+			return ClockBlockNumber, nil
+		}
+		return 0, fmt.Errorf("CLOCK_MODE: %w", err)
+	}
+	if len(out) == 0 {
+		return ClockBlockNumber, nil
+	}
+	// The output is an ABI encoded string: offset, length and data
+	if len(out) < 64 {
+		return 0, fmt.Errorf("invalid CLOCK_MODE output %x", out)
+	}
+	length := new(big.Int).SetBytes(out[32:64])
+	if !length.IsUint64() || length.Uint64() > uint64(len(out)-64) {
+		return 0, fmt.Errorf("invalid CLOCK_MODE output %x", out)
+	}
+	mode := string(out[64 : 64+length.Uint64()])
+	switch {
+	case mode == "mode=timestamp":
+		return ClockTimestamp, nil
+	case strings.HasPrefix(mode, "mode=blocknumber"):
+		return ClockBlockNumber, nil
+	default:
+		return 0, fmt.Errorf("clock mode %q not supported", mode)
+	}
+}
+
+// Format returns the checkpoints format of the token, FormatUnknown if it is
+// not detected yet
+func (e *ERC20Votes) Format() Format {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.format
+}
+
+// setFormat sets the checkpoints format of the token
+func (e *ERC20Votes) setFormat(format Format) {
+	e.lock.Lock()
+	e.format = format
+	e.lock.Unlock()
+}
+
+// GetVotesAtHash returns the votes of delegate at the block with blockHash,
+// calling the getVotes method.
+func (e *ERC20Votes) GetVotesAtHash(ctx context.Context, delegate common.Address,
+	blockHash common.Hash) (*big.Int, error) {
+	out, err := e.erc20.Source.CallContractAtHash(ctx,
+		ethereum.CallMsg{To: &e.erc20.TokenAddr, Data: getVotesData(delegate)}, blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("getVotes: %w", err)
+	}
+	if len(out) != common.HashLength {
+		return nil, fmt.Errorf("invalid getVotes output %x", out)
+	}
+	return new(big.Int).SetBytes(out), nil
+}
+
+// getVotesData returns the call data of getVotes(delegate)
+func getVotesData(delegate common.Address) []byte {
+	return append(common.CopyBytes(getVotesSelector), common.LeftPadBytes(delegate[:], 32)...)
+}
+
+// DiscoverSlot tries to find the index slot of the _checkpoints map, and the
+// checkpoints format, matching the last checkpoint of holder with its votes.
+// If the source implements source.StorageTracer, the index slots read by
+// getVotes are checked first, otherwise the first erc20.DiscoveryIterations
+// index slots are checked.  If holder is the zero address, a delegate is
+// picked from the recent DelegateVotesChanged events.  Returns erc20.ErrZeroBalance if the
+// holder has no votes.  If found, returns also the votes of holder at the
+// block referred by ref.
+func (e *ERC20Votes) DiscoverSlot(ctx context.Context, holder common.Address,
+	ref source.BlockRef) (int, *big.Rat, error) {
+	// All the reads are done at the same block, so the votes can not
+	// change while searching for them
+	header, err := e.erc20.GetBlockHeader(ctx, ref)
+	if err != nil {
+		return -1, nil, fmt.Errorf("cannot get block header: %w", err)
+	}
+	blockHash := header.Hash()
+	var votes *big.Int
+	if holder == (common.Address{}) {
+		if holder, votes, err = e.FindDelegate(ctx, header); err != nil {
+			return -1, nil, err
+		}
+	} else {
+		if votes, err = e.GetVotesAtHash(ctx, holder, blockHash); err != nil {
+			return -1, nil, err
+		}
+		if votes.Sign() == 0 {
+			return -1, nil, fmt.Errorf("%w: %s", erc20.ErrZeroBalance, holder.Hex())
+		}
+	}
+
+	format := FormatUnknown
+	index, err := e.erc20.DiscoverIndexSlot(ctx, getVotesData(holder),
+		erc20.LayoutMapSlot(helpers.LayoutSolidity, holder), blockHash,
+		erc20.DiscoveryIterations, func(i int) (bool, error) {
+			var err error
+			format, err = e.matchFormat(ctx, holder, i, votes, blockHash)
+			return format != FormatUnknown, err
+		})
+	if err != nil {
+		return -1, nil, err
+	}
+	e.setFormat(format)
+	return index, new(big.Rat).SetInt(votes), nil
+}
+
+// matchFormat returns the format on which the last checkpoint of holder on
+// the _checkpoints map at index slot islot holds votes, or FormatUnknown if
+// there is none.
+func (e *ERC20Votes) matchFormat(ctx context.Context, holder common.Address, islot int,
+	votes *big.Int, blockHash common.Hash) (Format, error) {
+	size, err := e.checkpointsSize(ctx, holder, islot, blockHash)
+	if err != nil {
+		return FormatUnknown, err
+	}
+	// A map of arrays holds small lengths, any other value is not one
+	if size == 0 || size > 1<<32 {
+		return FormatUnknown, nil
+	}
+	value, err := e.erc20.Source.StorageAtHash(ctx, e.erc20.TokenAddr,
+		checkpointSlot(holder, islot, size-1), blockHash)
+	if err != nil {
+		return FormatUnknown, err
+	}
+	for _, format := range Formats {
+		if v, _ := format.ParseCheckpoint(value); v.Cmp(votes) == 0 {
+			return format, nil
+		}
+	}
+	return FormatUnknown, nil
+}
+
+// FindDelegate returns a delegate with votes at the block of header, and its
+// votes.  The delegates of the most recent DelegateVotesChanged events up to
+// the block are checked.  Returns erc20.ErrHolderNotFound if none of them has
+// votes.
+func (e *ERC20Votes) FindDelegate(ctx context.Context,
+	header *ethstorageproof.BlockHeader) (common.Address, *big.Int, error) {
+	delegate := func(l *types.Log) (common.Address, bool) {
+		if len(l.Topics) != 2 {
+			return common.Address{}, false
+		}
+		return common.BytesToAddress(l.Topics[1][:]), true
+	}
+	return e.erc20.FindHolderInLogs(ctx, header, []common.Hash{DelegateVotesChangedTopic},
+		delegate, e.GetVotesAtHash)
+}
+
+// GetProof returns the storage proofs of the votes of holder at the block
+// referred by ref, on the _checkpoints map at index slot islot: the proof of
+// the last checkpoint at or before the timepoint of the block, and the proof
+// of the next checkpoint, or of its absence.  The timepoint is on the clock
+// of the token at that block.  If the format is not known, it is detected
+// with the votes of holder.
+func (e *ERC20Votes) GetProof(ctx context.Context, holder common.Address, ref source.BlockRef,
+	islot int) (*ethstorageproof.StorageProof, error) {
+	// Resolve the block once, so all the reads refer to the same block even
+	// if ref is a tag and the head advances.
+	header, err := e.erc20.GetBlockHeader(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get block header: %w", err)
+	}
+	blockHash := header.Hash()
+	format := e.Format()
+	if format == FormatUnknown {
+		votes, err := e.GetVotesAtHash(ctx, holder, blockHash)
+		if err != nil {
+			return nil, err
+		}
+		if format, err = e.matchFormat(ctx, holder, islot, votes, blockHash); err != nil {
+			return nil, err
+		}
+		if format == FormatUnknown {
+			return nil, fmt.Errorf("%w: no checkpoint of %s holds its votes",
+				ErrUnknownFormat, holder.Hex())
+		}
+		e.setFormat(format)
+	}
+	clock, err := e.ClockAtHash(ctx, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	size, err := e.checkpointsSize(ctx, holder, islot, blockHash)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get checkpoints size: %w", err)
+	}
+	position, err := e.lookup(ctx, format, holder, islot, size, clock.Timepoint(header),
+		blockHash)
+	if err != nil {
+		return nil, err
+	}
+	slot0 := checkpointSlot(holder, islot, position)
+	slot1 := checkpointSlot(holder, islot, position+1)
+	return e.erc20.GetProof(ctx, [][]byte{slot0[:], slot1[:]},
+		source.BlockHash(blockHash, ref.RequireCanonical))
+}
+
+// lookup returns the position of the last of the size checkpoints of holder,
+// of format, with a key lower or equal to timepoint, as the upperLookup of
+// the OpenZeppelin Checkpoints library does.  Returns ErrNoCheckpoint if
+// there is none.
+func (e *ERC20Votes) lookup(ctx context.Context, format Format, holder common.Address,
+	islot int, size uint64, timepoint *big.Int, blockHash common.Hash) (uint64, error) {
+	low, high := uint64(0), size
+	for low < high {
+		mid := low + (high-low)/2
+		value, err := e.erc20.Source.StorageAtHash(ctx, e.erc20.TokenAddr,
+			checkpointSlot(holder, islot, mid), blockHash)
+		if err != nil {
+			return 0, fmt.Errorf("cannot get checkpoint %d: %w", mid, err)
+		}
+		if _, key := format.ParseCheckpoint(value); key.Cmp(timepoint) > 0 {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	if high == 0 {
+		return 0, fmt.Errorf("%w: %s at %v", ErrNoCheckpoint, holder.Hex(), timepoint)
+	}
+	return high - 1, nil
+}
+
+// checkpointsSize returns the number of checkpoints of holder on the
+// _checkpoints map at index slot islot, at the block with blockHash
+func (e *ERC20Votes) checkpointsSize(ctx context.Context, holder common.Address, islot int,
+	blockHash common.Hash) (uint64, error) {
+	value, err := e.erc20.Source.StorageAtHash(ctx, e.erc20.TokenAddr,
+		helpers.GetMapSlot(holder, islot), blockHash)
+	if err != nil {
+		return 0, err
+	}
+	size := new(big.Int).SetBytes(value)
+	if !size.IsUint64() {
+		return 1<<64 - 1, nil
+	}
+	return size.Uint64(), nil
+}
+
+// checkpointSlot returns the storage slot of the checkpoint at position of
+// holder, on the _checkpoints map at index slot islot
+func checkpointSlot(holder common.Address, islot int, position uint64) common.Hash {
+	mapSlot := helpers.GetMapSlot(holder, islot)
+	base := new(big.Int).SetBytes(crypto.Keccak256(mapSlot[:]))
+	return common.BigToHash(base.Add(base, new(big.Int).SetUint64(position)))
+}
+
+// VerifyProof verifies the storage proofs of the votes of holder at the
+// timepoint targetBlock (a block number, or a timestamp for timestamp clock
+// tokens), which must be targetBalance.  The format of the token must be
+// known.
+func (e *ERC20Votes) VerifyProof(holder common.Address, storageRoot common.Hash,
+	proofs []ethstorageproof.StorageResult, checkpointsSlot int, targetBalance,
+	targetBlock *big.Int) error {
+	return VerifyProof(e.Format(), holder, storageRoot, proofs, checkpointsSlot, targetBalance,
+		targetBlock)
+}
+
+// VerifyProof verifies the storage proofs of the votes of holder on the
+// _checkpoints map at index slot checkpointsSlot of a token with the
+// checkpoints format, which must be targetVotes at timepoint: the first proof
+// must be a checkpoint of targetVotes at or before timepoint, and the second
+// one either a later checkpoint after timepoint or the proof of its absence.
+func VerifyProof(format Format, holder common.Address, storageRoot common.Hash,
+	proofs []ethstorageproof.StorageResult, checkpointsSlot int, targetVotes,
+	timepoint *big.Int) error {
+	if format.KeyBits() == 0 {
+		return fmt.Errorf("%w: %v", ErrUnknownFormat, format)
+	}
+	if len(proofs) != 2 {
+		return fmt.Errorf("invalid length of proofs %d", len(proofs))
+	}
+	if targetVotes == nil || timepoint == nil {
+		return fmt.Errorf("target votes or timepoint is nil")
+	}
+	if len(proofs[0].Value) == 0 {
+		return fmt.Errorf("%w: proof 0 value is empty", ethstorageproof.ErrValueMismatch)
+	}
+	for i, p := range proofs {
+		if len(p.Value) > 32 {
+			return fmt.Errorf("%w: proof %d value length is %d", ethstorageproof.ErrValueMismatch,
+				i, len(p.Value))
+		}
+		if len(p.Key) != 32 {
+			return fmt.Errorf("%w: proof %d key length is %d", ethstorageproof.ErrBadKey,
+				i, len(p.Key))
+		}
+	}
+	// The checkpoints are stored on an array, as the MiniMe ones
+	if err := minime.CheckMinimeKeys(proofs[0].Key, proofs[1].Key, holder,
+		checkpointsSlot); err != nil {
+		return fmt.Errorf("proof key and holder do not match: (%w)", err)
+	}
+
+	votes, key0 := format.ParseCheckpoint(proofs[0].Value)
+	if votes.Cmp(targetVotes) != 0 {
+		return fmt.Errorf("%w: proof votes and provided votes mismatch (%v != %v)",
+			ethstorageproof.ErrValueMismatch, votes, targetVotes)
+	}
+	// Verify that `key0 <= timepoint < key1`
+	if key0.Cmp(timepoint) > 0 {
+		return fmt.Errorf("%w: proof 0 key %v is after the timepoint %v",
+			ethstorageproof.ErrCheckpointRange, key0, timepoint)
+	}
+	if len(proofs[1].Value) != 0 {
+		_, key1 := format.ParseCheckpoint(proofs[1].Value)
+		if key0.Cmp(key1) >= 0 {
+			return fmt.Errorf("%w: proof 0 key is not behind proof 1 key",
+				ethstorageproof.ErrCheckpointRange)
+		}
+		if timepoint.Cmp(key1) >= 0 {
+			return fmt.Errorf("%w: proof 1 key %v is not after the timepoint %v",
+				ethstorageproof.ErrCheckpointRange, key1, timepoint)
+		}
+	}
+
+	if _, err := ethstorageproof.VerifyEthStorageProof(&proofs[0], storageRoot); err != nil {
+		return fmt.Errorf("proof 0 is not valid: %w", err)
+	}
+	// proofs[1] may prove there is no checkpoint after proofs[0]
+	if len(proofs[1].Value) == 0 {
+		if err := ethstorageproof.VerifyStorageAbsence(&proofs[1], storageRoot); err != nil {
+			return fmt.Errorf("proof 1 is not valid: %w", err)
+		}
+		return nil
+	}
+	if _, err := ethstorageproof.VerifyEthStorageProof(&proofs[1], storageRoot); err != nil {
+		return fmt.Errorf("proof 1 is not valid: %w", err)
+	}
+	return nil
+}
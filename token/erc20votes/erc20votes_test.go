@@ -0,0 +1,136 @@
+package erc20votes
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/testchain"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/tokentest"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
+)
+
+func TestERC20Votes(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := tokentest.Alice, tokentest.Bob
+	for _, tc := range []struct {
+		name      string
+		keyBytes  int
+		clockMode string
+		format    Format
+		clock     Clock
+	}{
+		{"v4", 4, "", FormatV4, ClockBlockNumber},
+		{"v4.9", 4, testchain.ClockModeBlockNumber, FormatV4, ClockBlockNumber},
+		{"v5", 6, testchain.ClockModeBlockNumber, FormatV5, ClockBlockNumber},
+		{"v5 timestamp", 6, testchain.ClockModeTimestamp, FormatV5, ClockTimestamp},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := qt.New(t)
+			chain := testchain.New()
+			defer chain.Close()
+			addr := chain.DeployERC20Votes("Votes", "VOT", tc.keyBytes, tc.clockMode)
+			chain.Commit()
+			// alice checkpoints on blocks 2, 4 and 5, bob on block 3
+			tokentest.Play(chain, []tokentest.Step{
+				{Block: 2, Holder: alice, Value: 100},
+				{Block: 3, Holder: bob, Value: 50},
+				{Block: 4, Holder: alice, Value: 300},
+				{Block: 5, Holder: alice, Value: 200},
+			}, func(s tokentest.Step) {
+				chain.SetERC20Votes(addr, s.Holder, big.NewInt(s.Value))
+			})
+			chain.Commit()
+			src := source.NewRPC(chain.Client())
+			timepoint := func(header *types.Header) *big.Int {
+				if tc.clock == ClockTimestamp {
+					return new(big.Int).SetUint64(header.Time)
+				}
+				return header.Number
+			}
+
+			e, err := New(ctx, src, addr)
+			c.Assert(err, qt.IsNil)
+			clock, err := e.ClockAtHash(ctx, chain.Head().Hash())
+			c.Assert(err, qt.IsNil)
+			c.Assert(clock, qt.Equals, tc.clock)
+			c.Assert(e.Format(), qt.Equals, FormatUnknown)
+			slot, votes, err := e.DiscoverSlot(ctx, common.Address{}, source.Latest)
+			c.Assert(err, qt.IsNil)
+			c.Assert(slot, qt.Equals, testchain.ERC20VotesCheckpointsSlot)
+			c.Assert(votes.Cmp(big.NewRat(200, 1)), qt.Equals, 0)
+			c.Assert(e.Format(), qt.Equals, tc.format)
+
+			// The format is detected by GetProof if not known
+			e, err = New(ctx, src, addr)
+			c.Assert(err, qt.IsNil)
+			// The checkpoints of the other holder are on another array
+			checker := &tokentest.Checker{Chain: chain, Token: e, Slot: slot,
+				Timepoint: timepoint, ErrOtherHolder: ethstorageproof.ErrKeyOffsetOverflow}
+			checker.Extra = func(c *qt.C, vc tokentest.Case, sp *ethstorageproof.StorageProof) {
+				// The proof of a checkpoint does not hold on the other format
+				other := FormatV5
+				if tc.format == FormatV5 {
+					other = FormatV4
+				}
+				target := timepoint(chain.Header(vc.Block))
+				err := VerifyProof(other, vc.Holder, sp.StorageHash, sp.StorageProof, slot,
+					big.NewInt(vc.Balance), target)
+				c.Assert(err, qt.Not(qt.IsNil))
+				err = VerifyProof(tc.format, vc.Holder, sp.StorageHash, sp.StorageProof, slot+1,
+					big.NewInt(vc.Balance), target)
+				c.Assert(errors.Is(err, ethstorageproof.ErrKeyOffsetOverflow), qt.IsTrue)
+			}
+			checker.Check(c,
+				tokentest.Case{Holder: alice, Block: 2, Balance: 100},
+				tokentest.Case{Holder: alice, Block: 3, Balance: 100},
+				tokentest.Case{Holder: alice, Block: 4, Balance: 300},
+				tokentest.Case{Holder: alice, Block: 6, Balance: 200},
+				tokentest.Case{Holder: bob, Block: 6, Balance: 50},
+			)
+
+			// The proof at block 4 does not prove the votes at block 3, before
+			// its checkpoint
+			sp, err := e.GetProof(ctx, alice, source.BlockNumber(big.NewInt(4)), slot)
+			c.Assert(err, qt.IsNil)
+			err = e.VerifyProof(alice, sp.StorageHash, sp.StorageProof, slot, big.NewInt(300),
+				timepoint(chain.Header(3)))
+			c.Assert(errors.Is(err, ethstorageproof.ErrCheckpointRange), qt.IsTrue)
+
+			// At block 6 the checkpoint of block 4 is stale, followed by the one
+			// of block 5, so it does not prove the votes at block 6
+			slot1, slot2 := checkpointSlot(alice, slot, 1), checkpointSlot(alice, slot, 2)
+			sp, err = e.erc20.GetProof(ctx, [][]byte{slot1[:], slot2[:]},
+				source.BlockNumber(big.NewInt(6)))
+			c.Assert(err, qt.IsNil)
+			err = e.VerifyProof(alice, sp.StorageHash, sp.StorageProof, slot, big.NewInt(300),
+				timepoint(chain.Header(6)))
+			c.Assert(errors.Is(err, ethstorageproof.ErrCheckpointRange), qt.IsTrue)
+
+			_, err = e.GetProof(ctx, bob, source.BlockNumber(big.NewInt(2)), slot)
+			c.Assert(errors.Is(err, ErrNoCheckpoint), qt.IsTrue)
+			_, _, err = e.DiscoverSlot(ctx, bob, source.BlockNumber(big.NewInt(2)))
+			c.Assert(errors.Is(err, erc20.ErrZeroBalance), qt.IsTrue)
+
+			// The format can be detected by concurrent proofs
+			e, err = New(ctx, src, addr)
+			c.Assert(err, qt.IsNil)
+			errs := make(chan error)
+			for _, holder := range []common.Address{alice, bob} {
+				go func(holder common.Address) {
+					_, err := e.GetProof(ctx, holder, source.Latest, slot)
+					errs <- err
+				}(holder)
+			}
+			c.Assert(<-errs, qt.IsNil)
+			c.Assert(<-errs, qt.IsNil)
+			c.Assert(e.Format(), qt.Equals, tc.format)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Package erc721 gets and verifies storage proofs of OpenZeppelin style
+// ERC721 (non fungible) tokens.
+package erc721
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
+	"github.com/vocdoni/storage-proofs-eth-go/token/mapbased"
+)
+
+const (
+	// OwnersSlot is the index slot of the _owners map of the OpenZeppelin
+	// ERC721 contract
+	OwnersSlot = 2
+	// BalancesSlot is the index slot of the _balances map of the
+	// OpenZeppelin ERC721 contract
+	BalancesSlot = 3
+)
+
+var (
+	// ErrNoOwner is returned when discovering the owners map with a token id
+	// which is not minted
+	ErrNoOwner = errors.New("token id has no owner")
+
+	// ownerOfSelector is the selector of the ownerOf(uint256) method
+	ownerOfSelector = crypto.Keccak256([]byte("ownerOf(uint256)"))[:4]
+)
+
+// ERC721 tokens store the owner of each token id on a map
+// `uint256 => address` (_owners), and the number of tokens of each owner on
+// a map `address => uint256` (_balances), as the OpenZeppelin ERC721
+// contract does.  As a token.Token, the balance of a holder is its number of
+// tokens and the index slot is the one of the _balances map.
+type ERC721 struct {
+	erc20 *erc20.ERC20Token
+}
+
+// New creates a new ERC721 to get and verify ERC721 token proofs
+func New(ctx context.Context, src source.ProofSource,
+	tokenAddress common.Address) (*ERC721, error) {
+	token, err := erc20.New(ctx, src, tokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	token.TransferTopics = 4
+	return &ERC721{erc20: token}, nil
+}
+
+// DiscoverSlot tries to find the index slot of the _balances map, as
+// mapbased.Mapbased does with the balanceOf method.  A token holder address
+// with a balance must be provided in order to have a value to search and
+// compare.  If holder is the zero address, a holder is picked from the recent
+// Transfer events.  If found, returns also the number of tokens of holder at
+// the block referred by ref.
+func (e *ERC721) DiscoverSlot(ctx context.Context, holder common.Address,
+	ref source.BlockRef) (int, *big.Rat, error) {
+	// All the reads are done at the same block, so the balance can not
+	// change while searching for it
+	header, err := e.erc20.GetBlockHeader(ctx, ref)
+	if err != nil {
+		return -1, nil, fmt.Errorf("cannot get block header: %w", err)
+	}
+	blockHash := header.Hash()
+	holder, balance, err := e.erc20.DiscoveryBalance(ctx, holder, header)
+	if err != nil {
+		return -1, nil, err
+	}
+	index, err := e.erc20.DiscoverValueSlot(ctx, e.erc20.BalanceOfData(holder),
+		erc20.LayoutMapSlot(helpers.LayoutSolidity, holder), blockHash,
+		erc20.DiscoveryIterations, balance)
+	if err != nil {
+		return -1, nil, err
+	}
+	return index, new(big.Rat).SetInt(balance), nil
+}
+
+// GetProof returns the storage proof of the number of tokens of holder, on the
+// _balances map at index slot islot, at the block referred by ref.
+func (e *ERC721) GetProof(ctx context.Context, holder common.Address,
+	ref source.BlockRef, islot int) (*ethstorageproof.StorageProof, error) {
+	slot := helpers.GetMapSlot(holder, islot)
+	return e.erc20.GetProof(ctx, [][]byte{slot[:]}, ref)
+}
+
+// VerifyProof verifies the storage proof of the number of tokens of holder,
+// which must be targetBalance.
+func (e *ERC721) VerifyProof(holder common.Address, storageRoot common.Hash,
+	proofs []ethstorageproof.StorageResult, balancesSlot int, targetBalance,
+	targetBlock *big.Int) error {
+	if len(proofs) != 1 {
+		return fmt.Errorf("invalid length of proofs %d", len(proofs))
+	}
+	return mapbased.VerifyProof(holder, storageRoot, proofs[0], balancesSlot, targetBalance,
+		targetBlock)
+}
+
+// OwnerOfAtHash returns the owner of tokenID at the block with blockHash,
+// calling the ownerOf method.  Returns the zero address if the call reverts,
+// as ownerOf does for token ids which are not minted.
+func (e *ERC721) OwnerOfAtHash(ctx context.Context, tokenID *big.Int,
+	blockHash common.Hash) (common.Address, error) {
+	out, err := e.erc20.Source.CallContractAtHash(ctx,
+		ethereum.CallMsg{To: &e.erc20.TokenAddr, Data: ownerOfData(tokenID)}, blockHash)
+	if err != nil {
+		if strings.Contains(err.Error(), "execution reverted") {
+			return common.Address{}, nil
+		}
+		return common.Address{}, fmt.Errorf("ownerOf: %w", err)
+	}
+	if len(out) != common.HashLength {
+		return common.Address{}, fmt.Errorf("invalid ownerOf output %x", out)
+	}
+	return common.BytesToAddress(out), nil
+}
+
+// DiscoverOwnersSlot tries to find the index slot of the _owners map, with
+// the owner of tokenID returned by ownerOf.  If the source implements
+// source.StorageTracer, the index slots read by ownerOf are checked first,
+// otherwise the first erc20.DiscoveryIterations index slots are checked.
+// Returns ErrNoOwner if tokenID has no owner, and erc20.ErrSlotNotFound if the
+// slot cannot be found.  If found, returns also the owner at the block
+// referred by ref.
+func (e *ERC721) DiscoverOwnersSlot(ctx context.Context, tokenID *big.Int,
+	ref source.BlockRef) (int, common.Address, error) {
+	header, err := e.erc20.GetBlockHeader(ctx, ref)
+	if err != nil {
+		return -1, common.Address{}, fmt.Errorf("cannot get block header: %w", err)
+	}
+	blockHash := header.Hash()
+	owner, err := e.OwnerOfAtHash(ctx, tokenID, blockHash)
+	if err != nil {
+		return -1, common.Address{}, err
+	}
+	if owner == (common.Address{}) {
+		return -1, common.Address{}, fmt.Errorf("%w: %v", ErrNoOwner, tokenID)
+	}
+	index, err := e.erc20.DiscoverValueSlot(ctx, ownerOfData(tokenID),
+		func(i int) [32]byte { return helpers.GetUintMapSlot(tokenID, i) }, blockHash,
+		erc20.DiscoveryIterations, new(big.Int).SetBytes(owner[:]))
+	if err != nil {
+		return -1, common.Address{}, err
+	}
+	return index, owner, nil
+}
+
+// ownerOfData returns the call data of ownerOf(tokenID)
+func ownerOfData(tokenID *big.Int) []byte {
+	return append(common.CopyBytes(ownerOfSelector), common.LeftPadBytes(tokenID.Bytes(), 32)...)
+}
+
+// GetOwnerProof returns the storage proof of the owner of tokenID, on the
+// _owners map at index slot ownersSlot, at the block referred by ref.
+func (e *ERC721) GetOwnerProof(ctx context.Context, tokenID *big.Int,
+	ref source.BlockRef, ownersSlot int) (*ethstorageproof.StorageProof, error) {
+	slot := helpers.GetUintMapSlot(tokenID, ownersSlot)
+	return e.erc20.GetProof(ctx, [][]byte{slot[:]}, ref)
+}
+
+// VerifyOwnerProof verifies that the storage proof of the _owners map at
+// index slot ownersSlot proves that owner owns tokenID.
+func VerifyOwnerProof(owner common.Address, storageRoot common.Hash,
+	proof ethstorageproof.StorageResult, ownersSlot int, tokenID *big.Int) error {
+	if tokenID == nil || tokenID.Sign() < 0 {
+		return fmt.Errorf("invalid token id %v", tokenID)
+	}
+	keySlot := helpers.GetUintMapSlot(tokenID, ownersSlot)
+	if !bytes.Equal(keySlot[:], proof.Key) {
+		return fmt.Errorf("%w: proof key and token id do not match (%x != %x)",
+			ethstorageproof.ErrBadKey, keySlot, proof.Key)
+	}
+	if new(big.Int).SetBytes(proof.Value).Cmp(new(big.Int).SetBytes(owner[:])) != 0 {
+		return fmt.Errorf("%w: token %v is owned by %x, not %s",
+			ethstorageproof.ErrValueMismatch, tokenID, proof.Value, owner.Hex())
+	}
+	if _, err := ethstorageproof.VerifyEthStorageProof(&proof, storageRoot); err != nil {
+		return fmt.Errorf("proof is not valid: %w", err)
+	}
+	return nil
+}
+
+// VerifyMinBalanceProof verifies that the storage proof of the _balances map
+// at index slot balancesSlot proves that holder owns at least minBalance
+// tokens.
+func VerifyMinBalanceProof(holder common.Address, storageRoot common.Hash,
+	proof ethstorageproof.StorageResult, balancesSlot int, minBalance *big.Int) error {
+	if minBalance == nil {
+		return fmt.Errorf("minimum balance is nil")
+	}
+	keySlot := helpers.GetMapSlot(holder, balancesSlot)
+	if !bytes.Equal(keySlot[:], proof.Key) {
+		return fmt.Errorf("%w: proof key and holder do not match (%x != %x)",
+			ethstorageproof.ErrBadKey, keySlot, proof.Key)
+	}
+	if balance := new(big.Int).SetBytes(proof.Value); balance.Cmp(minBalance) < 0 {
+		return fmt.Errorf("%w: holder has %v tokens, less than %v",
+			ethstorageproof.ErrValueMismatch, balance, minBalance)
+	}
+	if _, err := ethstorageproof.VerifyEthStorageProof(&proof, storageRoot); err != nil {
+		return fmt.Errorf("proof is not valid: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package erc721
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/testchain"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/tokentest"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+)
+
+func TestERC721(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	alice, bob := tokentest.Alice, tokentest.Bob
+	chain := testchain.New()
+	defer chain.Close()
+	addr := chain.DeployERC721("Test NFT", "NFT")
+	chain.Commit()
+	// Token 3 is transferred to bob after block 2
+	tokentest.Play(chain, []tokentest.Step{
+		{Block: 2, Holder: alice, ID: 1},
+		{Block: 2, Holder: alice, ID: 2},
+		{Block: 2, Holder: alice, ID: 3},
+		{Block: 2, Holder: bob, ID: 4},
+		{Block: 3, Holder: bob, ID: 3},
+	}, func(s tokentest.Step) {
+		chain.SetERC721Owner(addr, big.NewInt(s.ID), s.Holder)
+	})
+	src := source.NewRPC(chain.Client())
+	block2 := source.BlockNumber(big.NewInt(2))
+
+	e, err := New(ctx, src, addr)
+	c.Assert(err, qt.IsNil)
+
+	// The balances map is discovered with a holder picked from the Transfer
+	// events, which index the token id
+	slot, amount, err := e.DiscoverSlot(ctx, common.Address{}, block2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, BalancesSlot)
+	c.Assert(amount.Sign(), qt.Equals, 1)
+
+	checker := &tokentest.Checker{Chain: chain, Token: e, Slot: slot}
+	checker.Extra = func(c *qt.C, tc tokentest.Case, sp *ethstorageproof.StorageProof) {
+		balance := big.NewInt(tc.Balance)
+		c.Assert(VerifyMinBalanceProof(tc.Holder, sp.StorageHash, sp.StorageProof[0], slot,
+			balance), qt.IsNil)
+		c.Assert(VerifyMinBalanceProof(tc.Holder, sp.StorageHash, sp.StorageProof[0], slot,
+			big.NewInt(1)), qt.IsNil)
+		err := VerifyMinBalanceProof(tc.Holder, sp.StorageHash, sp.StorageProof[0], slot,
+			new(big.Int).Add(balance, big.NewInt(1)))
+		c.Assert(errors.Is(err, ethstorageproof.ErrValueMismatch), qt.IsTrue)
+		err = VerifyMinBalanceProof(tokentest.Other(tc.Holder), sp.StorageHash,
+			sp.StorageProof[0], slot, big.NewInt(1))
+		c.Assert(errors.Is(err, ethstorageproof.ErrBadKey), qt.IsTrue)
+	}
+	checker.Check(c,
+		tokentest.Case{Holder: alice, Block: 2, Balance: 3},
+		tokentest.Case{Holder: bob, Block: 2, Balance: 1},
+		tokentest.Case{Holder: alice, Block: 3, Balance: 2},
+		tokentest.Case{Holder: bob, Block: 3, Balance: 2},
+	)
+
+	// The owner of a token id at a past block
+	ownersSlot, owner, err := e.DiscoverOwnersSlot(ctx, big.NewInt(3), block2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(ownersSlot, qt.Equals, OwnersSlot)
+	c.Assert(owner, qt.Equals, alice)
+	for _, tc := range []struct {
+		owner common.Address
+		block int64
+		id    int64
+	}{
+		{alice, 2, 3},
+		{bob, 3, 3},
+		{bob, 3, 4},
+	} {
+		header := chain.Header(uint64(tc.block))
+		sp, err := e.GetOwnerProof(ctx, big.NewInt(tc.id), source.BlockNumber(header.Number),
+			ownersSlot)
+		c.Assert(err, qt.IsNil)
+		ok, err := ethstorageproof.VerifyEIP1186WithBlockHash(sp, header.Hash())
+		c.Assert(err, qt.IsNil)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(VerifyOwnerProof(tc.owner, sp.StorageHash, sp.StorageProof[0], ownersSlot,
+			big.NewInt(tc.id)), qt.IsNil)
+		// The proof does not prove another owner, nor the owner of another id
+		err = VerifyOwnerProof(tokentest.Other(tc.owner), sp.StorageHash, sp.StorageProof[0],
+			ownersSlot, big.NewInt(tc.id))
+		c.Assert(errors.Is(err, ethstorageproof.ErrValueMismatch), qt.IsTrue)
+		err = VerifyOwnerProof(tc.owner, sp.StorageHash, sp.StorageProof[0], ownersSlot,
+			big.NewInt(tc.id+1))
+		c.Assert(errors.Is(err, ethstorageproof.ErrBadKey), qt.IsTrue)
+		err = VerifyOwnerProof(tc.owner, sp.StorageHash, sp.StorageProof[0], ownersSlot+1,
+			big.NewInt(tc.id))
+		c.Assert(errors.Is(err, ethstorageproof.ErrBadKey), qt.IsTrue)
+	}
+
+	// The proof of a token id which is not minted proves no owner
+	sp, err := e.GetOwnerProof(ctx, big.NewInt(5), source.Latest, ownersSlot)
+	c.Assert(err, qt.IsNil)
+	err = VerifyOwnerProof(alice, sp.StorageHash, sp.StorageProof[0], ownersSlot,
+		big.NewInt(5))
+	c.Assert(errors.Is(err, ethstorageproof.ErrValueMismatch), qt.IsTrue)
+	_, _, err = e.DiscoverOwnersSlot(ctx, big.NewInt(5), source.Latest)
+	c.Assert(errors.Is(err, ErrNoOwner), qt.IsTrue, qt.Commentf("%v", err))
+}
@@ -6,89 +6,136 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
 	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
 	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
 )
 
 const (
-	DiscoveryIterations = 30
+	DiscoveryIterations = erc20.DiscoveryIterations
 )
 
 // ErrSlotNotFound represents the storage slot not found error
-var ErrSlotNotFound = errors.New("storage slot not found")
+var ErrSlotNotFound = erc20.ErrSlotNotFound
 
 // Mapbased tokens are those where the balance is stored on a map `address => uint256`.
 // Most of ERC20 tokens follows this approach.
 type Mapbased struct {
 	erc20 *erc20.ERC20Token
+	// lock guards layout, which DiscoverSlot sets to the layout it matched
+	lock sync.RWMutex
+	// layout is the map layout of the compiler of the token contract
+	layout helpers.MapLayout
 }
 
-// New creates a new Mapbased to get and verify Mapbased token proofs
-func New(ctx context.Context, rpcCli *rpc.Client, tokenAddress common.Address) (*Mapbased, error) {
-	erc20, err := erc20.New(ctx, rpcCli, tokenAddress)
-	return &Mapbased{erc20: erc20}, err
+// New creates a new Mapbased to get and verify Mapbased token proofs of a
+// Solidity token contract
+func New(ctx context.Context, src source.ProofSource,
+	tokenAddress common.Address) (*Mapbased, error) {
+	return NewWithLayout(ctx, src, tokenAddress, helpers.LayoutSolidity)
 }
 
-// GetProof returns the storage merkle proofs for the acount holder.
+// NewWithLayout creates a new Mapbased to get and verify Mapbased token
+// proofs of a token contract which balances map follows layout, such as
+// helpers.LayoutVyper for Vyper token contracts, see helpers.CompilerLayout.
+func NewWithLayout(ctx context.Context, src source.ProofSource,
+	tokenAddress common.Address, layout helpers.MapLayout) (*Mapbased, error) {
+	erc20, err := erc20.New(ctx, src, tokenAddress)
+	return &Mapbased{erc20: erc20, layout: layout}, err
+}
+
+// Layout returns the map layout of the token contract: the one it was created
+// with, or the one matched by DiscoverSlot.
+func (m *Mapbased) Layout() helpers.MapLayout {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.layout
+}
+
+// GetProof returns the storage merkle proofs for the acount holder at the
+// block referred by ref.
 func (m *Mapbased) GetProof(ctx context.Context, holder common.Address,
-	block *big.Int, islot int) (*ethstorageproof.StorageProof, error) {
-	return m.getMapProofWithIndexSlot(ctx, holder, block, islot)
+	ref source.BlockRef, islot int) (*ethstorageproof.StorageProof, error) {
+	return m.getMapProofWithIndexSlot(ctx, holder, ref, islot)
 }
 
 // getMapProofWithIndexSlot returns the storage merkle proofs for the acount holder.
 // The index slot is the position on the EVM storage sub-trie for the contract.
 // If index slot is unknown, GetProof() could be used instead to try to find it
 func (m *Mapbased) getMapProofWithIndexSlot(ctx context.Context, holder common.Address,
-	block *big.Int, islot int) (*ethstorageproof.StorageProof, error) {
-	slot := helpers.GetMapSlot(holder, islot)
-	return m.erc20.GetProof(ctx, [][]byte{slot[:]}, block)
+	ref source.BlockRef, islot int) (*ethstorageproof.StorageProof, error) {
+	slot := m.Layout().MapSlot(holder, islot)
+	return m.erc20.GetProof(ctx, [][]byte{slot[:]}, ref)
+}
+
+// DiscoverSlot tries to find the EVM storage index slot, as
+// DiscoverLayoutSlot does.  The layout matched is used for the following
+// proofs, and returned by Layout.
+func (m *Mapbased) DiscoverSlot(ctx context.Context, holder common.Address,
+	ref source.BlockRef) (int, *big.Rat, error) {
+	index, layout, amount, err := m.DiscoverLayoutSlot(ctx, holder, ref)
+	if err != nil {
+		return -1, nil, err
+	}
+	m.lock.Lock()
+	m.layout = layout
+	m.lock.Unlock()
+	return index, amount, nil
 }
 
-// DiscoverSlot tries to find the EVM storage index slot.
-// A token holder address must be provided in order to have a balance to search and compare.
-// Returns ErrSlotNotFound if the slot cannot be found.
-// If found, returns also the amount stored.
-func (m *Mapbased) DiscoverSlot(ctx context.Context, holder common.Address) (int, *big.Rat, error) {
-	var slot [32]byte
-	tokenData, err := m.erc20.GetTokenData(ctx)
+// DiscoverLayoutSlot tries to find the EVM storage index slot, and the map
+// layout of the token contract.  The layout of the token is tried first,
+// then the other ones of helpers.MapLayouts.
+// If the source implements source.StorageTracer, the index slots read by
+// balanceOf are checked first, otherwise the first DiscoveryIterations index
+// slots are checked.
+// A token holder address with a balance must be provided in order to have a
+// value to search and compare.  If holder is the zero address, a holder is
+// picked from the recent Transfer events.  Returns erc20.ErrZeroBalance if
+// the holder has no balance, and ErrSlotNotFound if the slot cannot be found
+// with any layout.  If found, returns also the amount stored at the block
+// referred by ref, in token units, or in base units if the token has no
+// decimals method.
+func (m *Mapbased) DiscoverLayoutSlot(ctx context.Context, holder common.Address,
+	ref source.BlockRef) (int, helpers.MapLayout, *big.Rat, error) {
+	// All the reads are done at the same block, so the balance can not
+	// change while searching for it
+	header, err := m.erc20.GetBlockHeader(ctx, ref)
 	if err != nil {
-		return -1, nil, fmt.Errorf("GetTokenData: %w", err)
+		return -1, 0, nil, fmt.Errorf("cannot get block header: %w", err)
 	}
-	balance, err := m.erc20.Balance(ctx, holder)
+	blockHash := header.Hash()
+	holder, balance, err := m.erc20.DiscoveryBalance(ctx, holder, header)
 	if err != nil {
-		return -1, nil, fmt.Errorf("balance: %w", err)
+		return -1, 0, nil, err
 	}
 
-	addr := common.Address{}
-	copy(addr[:], m.erc20.TokenAddr[:20])
-
-	var amount *big.Rat
-	index := -1
-	for i := 0; i < DiscoveryIterations; i++ {
-		// Prepare storage index
-		slot = helpers.GetMapSlot(holder, i)
-		// Get Storage
-		value, err := m.erc20.EthCli.StorageAt(ctx, addr, slot, nil)
+	layouts := []helpers.MapLayout{m.Layout()}
+	for _, layout := range helpers.MapLayouts {
+		if layout != layouts[0] {
+			layouts = append(layouts, layout)
+		}
+	}
+	for _, layout := range layouts {
+		index, err := m.erc20.DiscoverValueSlot(ctx, m.erc20.BalanceOfData(holder),
+			erc20.LayoutMapSlot(layout, holder), blockHash, DiscoveryIterations, balance)
+		if errors.Is(err, ErrSlotNotFound) {
+			continue
+		}
 		if err != nil {
-			return index, nil, err
+			return -1, 0, nil, err
 		}
-
-		// Parse balance value
-		amount, _ = helpers.ValueToBalance(value, int(tokenData.Decimals))
-		// Check if balance matches
-		if amount.Cmp(balance) == 0 {
-			index = i
-			break
+		decimals, err := m.erc20.DiscoveryDecimals(ctx, blockHash)
+		if err != nil {
+			return -1, 0, nil, err
 		}
+		return index, layout, helpers.BalanceToRat(balance, decimals), nil
 	}
-	if index == -1 {
-		return index, nil, ErrSlotNotFound
-	}
-	return index, amount, nil
+	return -1, 0, nil, ErrSlotNotFound
 }
 
 // VerifyProof verifies a map based storage proof.
@@ -98,51 +145,60 @@ func (m *Mapbased) VerifyProof(holder common.Address, storageRoot common.Hash,
 	if len(proofs) != 1 {
 		return fmt.Errorf("invalid length of proofs %d", len(proofs))
 	}
-	return VerifyProof(holder, storageRoot, proofs[0], mapIndexSlot, targetBalance, targetBlock)
+	return VerifyLayoutProof(m.Layout(), holder, storageRoot, proofs[0], mapIndexSlot,
+		targetBalance, targetBlock)
 }
 
-// VerifyProof verifies a map based storage proof.
+// VerifyProof verifies a map based storage proof of a Solidity token.
 // The targetBalance parameter is the full balance value, without decimals.
 func VerifyProof(holder common.Address, storageRoot common.Hash,
 	proof ethstorageproof.StorageResult, mapIndexSlot int, targetBalance, targetBlock *big.Int) error {
+	return VerifyLayoutProof(helpers.LayoutSolidity, holder, storageRoot, proof, mapIndexSlot,
+		targetBalance, targetBlock)
+}
+
+// VerifyLayoutProof verifies a map based storage proof of a token which
+// balances map follows layout.
+// The targetBalance parameter is the full balance value, without decimals.
+func VerifyLayoutProof(layout helpers.MapLayout, holder common.Address,
+	storageRoot common.Hash, proof ethstorageproof.StorageResult, mapIndexSlot int,
+	targetBalance, targetBlock *big.Int) error {
 	// Sanity checks
 	if proof.Value == nil {
-		return fmt.Errorf("value is nil")
+		return fmt.Errorf("%w: value is nil", ethstorageproof.ErrValueMismatch)
 	}
 	if len(proof.Key) != 32 {
-		return fmt.Errorf("key length is wrong.  Expected 32, got %v", len(proof.Key))
+		return fmt.Errorf("%w: key length is wrong.  Expected 32, got %v",
+			ethstorageproof.ErrBadKey, len(proof.Key))
 	}
 	if targetBalance == nil {
 		return fmt.Errorf("target balance is nil")
 	}
 
 	// Check proof key matches with holder address
-	keySlot := helpers.GetMapSlot(holder, mapIndexSlot)
+	keySlot := layout.MapSlot(holder, mapIndexSlot)
 	if !bytes.Equal(keySlot[:], proof.Key) {
-		return fmt.Errorf("proof key and leafData do not match (%x != %x)", keySlot, proof.Key)
+		return fmt.Errorf("%w: proof key and leafData do not match (%x != %x)",
+			ethstorageproof.ErrBadKey, keySlot, proof.Key)
 	}
 
 	// Check value balances matches
 	proofBalance := new(big.Int).SetBytes(proof.Value)
 	if targetBalance.Cmp(proofBalance) != 0 {
-		return fmt.Errorf("proof balance and provided balance mismatch (%v != %v)",
-			proofBalance, targetBalance)
+		return fmt.Errorf("%w: proof balance and provided balance mismatch (%v != %v)",
+			ethstorageproof.ErrValueMismatch, proofBalance, targetBalance)
 	}
 
 	// Check merkle proof against the storage root hash
-	valid, err := ethstorageproof.VerifyEthStorageProof(
+	if _, err := ethstorageproof.VerifyEthStorageProof(
 		&ethstorageproof.StorageResult{
 			Key:   proof.Key,
 			Proof: proof.Proof,
 			Value: proof.Value,
 		},
 		storageRoot,
-	)
-	if err != nil {
-		return err
-	}
-	if !valid {
-		return fmt.Errorf("proof is not valid")
+	); err != nil {
+		return fmt.Errorf("proof is not valid: %w", err)
 	}
 	return nil
 }
@@ -22,69 +22,76 @@ func VerifyProof(holder common.Address, storageRoot common.Hash,
 	for i, p := range proofs {
 		// proofs[1].Value can be nil when it's a non-existence proof
 		if i == 0 && p.Value == nil {
-			return fmt.Errorf("value is nil")
+			return fmt.Errorf("%w: value is nil", ethstorageproof.ErrValueMismatch)
 		}
 		if len(p.Value) > 32 {
-			return fmt.Errorf("value length is wrong.  Expected <= 32, got %v",
-				len(p.Value))
+			return fmt.Errorf("%w: value length is wrong.  Expected <= 32, got %v",
+				ethstorageproof.ErrValueMismatch, len(p.Value))
 		}
 		if len(p.Key) != 32 {
-			return fmt.Errorf("key length is wrong.  Expected 32, got %v", len(p.Key))
+			return fmt.Errorf("%w: key length is wrong.  Expected 32, got %v",
+				ethstorageproof.ErrBadKey, len(p.Key))
 		}
 	}
 	if targetBalance == nil {
 		return fmt.Errorf("target balance is nil")
 	}
 	if targetBlock == nil {
-		return fmt.Errorf("target balance is nil")
+		return fmt.Errorf("target block is nil")
 	}
 
 	// Check the proof keys (should match with the holder)
 	if err := CheckMinimeKeys(proofs[0].Key, proofs[1].Key, holder, mapIndexSlot); err != nil {
-		return fmt.Errorf("proof key and holder do not match: (%v)", err)
+		return fmt.Errorf("proof key and holder do not match: (%w)", err)
 	}
 
 	// Extract balance and block from the minime proof
 	_, proof0Balance, proof0Block := ParseMinimeValue(proofs[0].Value, 1)
 	// Check balance matches with the provided balance
 	if proof0Balance.Cmp(targetBalance) != 0 {
-		return fmt.Errorf("proof balance and provided balance mismatch (%v != %v)",
-			proof0Balance, targetBalance)
+		return fmt.Errorf("%w: proof balance and provided balance mismatch (%v != %v)",
+			ethstorageproof.ErrValueMismatch, proof0Balance, targetBalance)
 	}
 
 	// Verify that `proof0Block <= targetBlock < proof1Block`
 
 	// Proof 0 checkpoint block should be smaller or equal than target block
 	if !(proof0Block.Cmp(targetBlock) <= 0) { // !(proof0Block <= targetBlock)
-		return fmt.Errorf("proof 0 block is not greater equal than target block")
+		return fmt.Errorf("%w: proof 0 block is not greater equal than target block",
+			ethstorageproof.ErrCheckpointRange)
 	}
 	// Check if the proof1 is a proof of non existence (so proof0 is the last checkpoint).
 	// If not the last, then check the target block is
 	if len(proofs[1].Value) != 0 {
 		_, _, proof1Block := ParseMinimeValue(proofs[1].Value, 1)
 		if !(proof0Block.Cmp(proof1Block) < 0) { // !(proof0Block < proof1Block)
-			return fmt.Errorf("proof 0 block is not behind proof 1 block")
+			return fmt.Errorf("%w: proof 0 block is not behind proof 1 block",
+				ethstorageproof.ErrCheckpointRange)
 		}
 		if !(targetBlock.Cmp(proof1Block) < 0) { // !(targetBlock < proof1Block)
-			return fmt.Errorf("target block is not smaller than proof 1 block")
+			return fmt.Errorf("%w: target block is not smaller than proof 1 block",
+				ethstorageproof.ErrCheckpointRange)
 		}
 	}
 
 	// Check both merkle proofs against the storage root hash
 	for i, p := range proofs {
-		valid, err := ethstorageproof.VerifyEthStorageProof(
+		// proofs[1] proves there is no checkpoint after proofs[0]
+		if i == 1 && len(p.Value) == 0 {
+			if err := ethstorageproof.VerifyStorageAbsence(&p, storageRoot); err != nil {
+				return fmt.Errorf("proof %d is not valid: %w", i, err)
+			}
+			continue
+		}
+		if _, err := ethstorageproof.VerifyEthStorageProof(
 			&ethstorageproof.StorageResult{
 				Key:   p.Key,
 				Proof: p.Proof,
 				Value: p.Value,
 			},
 			storageRoot,
-		)
-		if err != nil {
-			return err
-		}
-		if !valid {
-			return fmt.Errorf("proof %d is not valid", i)
+		); err != nil {
+			return fmt.Errorf("proof %d is not valid: %w", i, err)
 		}
 	}
 	return nil
@@ -118,13 +125,13 @@ func CheckMinimeKeys(key1, key2 []byte, holder common.Address, mapIndexSlot int)
 
 	// key1+1 != key2
 	if new(big.Int).Add(key1Uindex, big.NewInt(1)).Cmp(key2Uindex) != 0 {
-		return fmt.Errorf("keys are not consecutive")
+		return fmt.Errorf("%w: keys are not consecutive", ethstorageproof.ErrBadKey)
 	}
 
 	// We tolerate maximum 2^16 minime checkpoints
 	offset := new(big.Int).Sub(key1Uindex, holderMapUindex)
 	if offset.Cmp(big.NewInt(65536)) >= 0 || offset.Cmp(big.NewInt(0)) < 0 {
-		return fmt.Errorf("key offset overflow")
+		return ethstorageproof.ErrKeyOffsetOverflow
 	}
 	return nil
 }
@@ -2,19 +2,18 @@ package minime
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
 	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
 	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
 )
 
 // ErrSlotNotFound represents the storage slot not found error
-var ErrSlotNotFound = errors.New("storage slot not found")
+var ErrSlotNotFound = erc20.ErrSlotNotFound
 
 const maxIterationsForDiscover = 20
 
@@ -27,56 +26,58 @@ type Minime struct {
 }
 
 // New creates a new Minime to get and verify Minime token proofs
-func New(ctx context.Context, rpcCli *rpc.Client, tokenAddress common.Address) (*Minime, error) {
-	erc20, err := erc20.New(ctx, rpcCli, tokenAddress)
+func New(ctx context.Context, src source.ProofSource,
+	tokenAddress common.Address) (*Minime, error) {
+	erc20, err := erc20.New(ctx, src, tokenAddress)
 	return &Minime{erc20: erc20}, err
 }
 
-// DiscoverSlot tries to find the map index slot for the minime balances
-func (m *Minime) DiscoverSlot(ctx context.Context, holder common.Address) (int, *big.Rat, error) {
-	balance, err := m.erc20.Balance(ctx, holder)
+// DiscoverSlot tries to find the map index slot for the minime balances at
+// the block referred by ref.  The index slots read by balanceOf are checked
+// first if the source implements source.StorageTracer.  If holder is the zero
+// address, a holder is picked from the recent Transfer events.  Returns
+// erc20.ErrZeroBalance if the holder has no balance.  The balance is in base
+// units if the token has no decimals method.
+func (m *Minime) DiscoverSlot(ctx context.Context, holder common.Address,
+	ref source.BlockRef) (int, *big.Rat, error) {
+	// All the reads are done at the same block, so the balance can not
+	// change while searching for it
+	header, err := m.erc20.GetBlockHeader(ctx, ref)
+	if err != nil {
+		return -1, nil, fmt.Errorf("cannot get block header: %w", err)
+	}
+	blockHash := header.Hash()
+	holder, balance, err := m.erc20.DiscoveryBalance(ctx, holder, header)
 	if err != nil {
 		return -1, nil, err
 	}
 
-	addr := common.Address{}
-	copy(addr[:], m.erc20.TokenAddr[:20])
-	var amount *big.Rat
-	var block *big.Int
-	index := -1
-
-	for i := 0; i < maxIterationsForDiscover; i++ {
-		checkPointsSize, err := m.getMinimeArraySize(ctx, holder, i)
-		if err != nil {
-			return 0, nil, err
-		}
-		if checkPointsSize <= 0 {
-			continue
-		}
-
-		if amount, block, _, err = m.getMinimeAtPosition(
-			ctx,
-			holder,
-			i,
-			checkPointsSize,
-			nil,
-		); err != nil {
-			continue
-		}
-		if block.Uint64() == 0 {
-			continue
-		}
-
-		// Check if balance matches
-		if amount.Cmp(balance) == 0 {
-			index = i
-			break
-		}
+	index, err := m.erc20.DiscoverIndexSlot(ctx, m.erc20.BalanceOfData(holder),
+		erc20.LayoutMapSlot(helpers.LayoutSolidity, holder), blockHash,
+		maxIterationsForDiscover, func(i int) (bool, error) {
+			checkPointsSize, err := m.getMinimeArraySize(ctx, holder, i, blockHash)
+			if err != nil {
+				return false, err
+			}
+			if checkPointsSize <= 0 {
+				return false, nil
+			}
+			amount, block, _, err := m.getMinimeAtPosition(ctx, holder, i,
+				checkPointsSize, blockHash)
+			if err != nil || block.Uint64() == 0 {
+				return false, nil
+			}
+			// Check if balance matches
+			return amount.Cmp(balance) == 0, nil
+		})
+	if err != nil {
+		return -1, nil, err
 	}
-	if index == -1 {
-		return index, nil, ErrSlotNotFound
+	decimals, err := m.erc20.DiscoveryDecimals(ctx, blockHash)
+	if err != nil {
+		return -1, nil, err
 	}
-	return index, amount, nil
+	return index, helpers.BalanceToRat(balance, decimals), nil
 }
 
 // GetProof returns a storage proof for a token holder and a block number.
@@ -89,9 +90,17 @@ func (m *Minime) DiscoverSlot(ctx context.Context, holder common.Address) (int,
 //
 // Minime checkpoints: [70],[80],[90],[100]
 // For block 87, we need to provide checkpoint 80 and 90
-func (m *Minime) GetProof(ctx context.Context, holder common.Address, block *big.Int,
+func (m *Minime) GetProof(ctx context.Context, holder common.Address, ref source.BlockRef,
 	islot int) (*ethstorageproof.StorageProof, error) {
-	checkPointsSize, err := m.getMinimeArraySize(ctx, holder, islot)
+	// Resolve the block once, so all the reads refer to the same block even
+	// if ref is a tag and the head advances.
+	header, err := m.erc20.GetBlockHeader(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get block header: %w", err)
+	}
+	block := header.Number
+	blockHash := header.Hash()
+	checkPointsSize, err := m.getMinimeArraySize(ctx, holder, islot, blockHash)
 	if err != nil {
 		return nil, fmt.Errorf("cannot fetch minime array size: %w", err)
 	}
@@ -99,12 +108,12 @@ func (m *Minime) GetProof(ctx context.Context, holder common.Address, block *big
 
 	// Firstly, check the last checkpoint block, if smaller than the current block number
 	// the proof will include the last checkpoint and a proof-of-nil for the next position.
-	_, mblock, slot, err := m.getMinimeAtPosition(ctx, holder, islot, checkPointsSize, block)
+	_, mblock, slot, err := m.getMinimeAtPosition(ctx, holder, islot, checkPointsSize, blockHash)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get minime: %w", err)
 	}
 	if block.Uint64() >= mblock.Uint64() {
-		_, _, slot2, err := m.getMinimeAtPosition(ctx, holder, islot, checkPointsSize+1, block)
+		_, _, slot2, err := m.getMinimeAtPosition(ctx, holder, islot, checkPointsSize+1, blockHash)
 		if err != nil {
 			return nil, err
 		}
@@ -114,7 +123,7 @@ func (m *Minime) GetProof(ctx context.Context, holder common.Address, block *big
 	// Secondly walk through all checkpoints starting from the last.
 	if len(keys) == 0 {
 		for i := checkPointsSize - 1; i > 0; i-- {
-			_, checkpointBlock, prevSlot, err := m.getMinimeAtPosition(ctx, holder, islot, i-1, block)
+			_, checkpointBlock, prevSlot, err := m.getMinimeAtPosition(ctx, holder, islot, i-1, blockHash)
 			if err != nil {
 				return nil, fmt.Errorf("cannot get minime: %w", err)
 			}
@@ -122,11 +131,11 @@ func (m *Minime) GetProof(ctx context.Context, holder common.Address, block *big
 			// If minime checkpoint block -1 is equal or greather than the block we
 			// are looking for, that's the one we need (the previous and the current)
 			if checkpointBlock.Uint64() >= block.Uint64() {
-				balance, block, currSlot, err := m.getMinimeAtPosition(ctx, holder, islot, i, block)
+				balance, block, currSlot, err := m.getMinimeAtPosition(ctx, holder, islot, i, blockHash)
 				if err != nil {
 					return nil, err
 				}
-				if balance.Cmp(big.NewRat(0, 1)) == 1 {
+				if balance.Sign() > 0 {
 					return nil, fmt.Errorf("proof of nil has a balance value")
 				}
 				if block.Uint64() > 0 {
@@ -141,7 +150,7 @@ func (m *Minime) GetProof(ctx context.Context, holder common.Address, block *big
 		return nil, fmt.Errorf("checkpoint not found")
 	}
 
-	return m.erc20.GetProof(ctx, keys, block)
+	return m.erc20.GetProof(ctx, keys, source.BlockHash(blockHash, ref.RequireCanonical))
 }
 
 // VerifyProof verifies a minime storage proof
@@ -151,14 +160,11 @@ func (m *Minime) VerifyProof(holder common.Address, storageRoot common.Hash,
 	return VerifyProof(holder, storageRoot, proofs, mapIndexSlot, targetBalance, targetBlock)
 }
 
-// getMinimeAtPosition returns the data contained in a specific checkpoint array position,
-// returns the balance, the checkpoint block and the merkle tree key slot
+// getMinimeAtPosition returns the data contained in a specific checkpoint array position
+// at the block with blockHash, returns the full balance, the checkpoint block and the
+// merkle tree key slot
 func (m *Minime) getMinimeAtPosition(ctx context.Context, holder common.Address, mapIndexSlot,
-	position int, block *big.Int) (*big.Rat, *big.Int, *common.Hash, error) {
-	token, err := m.erc20.GetTokenData(ctx)
-	if err != nil {
-		return nil, nil, nil, err
-	}
+	position int, blockHash common.Hash) (*big.Int, *big.Int, *common.Hash, error) {
 	contractAddr := common.Address{}
 	copy(contractAddr[:], m.erc20.TokenAddr[:20])
 
@@ -170,25 +176,28 @@ func (m *Minime) getMinimeAtPosition(ctx context.Context, holder common.Address,
 	v.Add(v, offset)
 
 	arraySlot := common.BytesToHash(v.Bytes())
-	value, err := m.erc20.EthCli.StorageAt(ctx, contractAddr, arraySlot, block)
+	value, err := m.erc20.Source.StorageAtHash(ctx, contractAddr, arraySlot, blockHash)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	balance, _, mblock := ParseMinimeValue(value, int(token.Decimals))
+	_, balance, mblock := ParseMinimeValue(value, 0)
 
 	return balance, mblock, &arraySlot, nil
 }
 
+// getMinimeArraySize returns the number of checkpoints of holder at the block
+// with blockHash.  The size must be read at the block of the proof, later
+// checkpoints are not part of its storage.
 func (m *Minime) getMinimeArraySize(ctx context.Context, holder common.Address,
-	islot int) (int, error) {
+	islot int, blockHash common.Hash) (int, error) {
 	// In this slot we should find the array size
 	mapSlot := helpers.GetMapSlot(holder, islot)
 
 	addr := common.Address{}
 	copy(addr[:], m.erc20.TokenAddr[:20])
 
-	value, err := m.erc20.EthCli.StorageAt(ctx, addr, mapSlot, nil)
+	value, err := m.erc20.Source.StorageAtHash(ctx, addr, mapSlot, blockHash)
 	if err != nil {
 		return 0, err
 	}
@@ -3,6 +3,7 @@ package minime
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"math/big"
 	"testing"
 
@@ -43,6 +44,37 @@ func TestEthProof(t *testing.T) {
 	}
 }
 
+func TestEthProofErrors(t *testing.T) {
+	sp := testStorageProof{}
+	if err := json.Unmarshal([]byte(proof1), &sp); err != nil {
+		t.Fatal(err)
+	}
+	balance, err := hex.DecodeString(sp.Balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		balance *big.Int
+		block   *big.Int
+		slot    int
+		err     error
+	}{
+		{new(big.Int).SetBytes(balance), new(big.Int).SetUint64(sp.Block), sp.Slot, nil},
+		{big.NewInt(1), new(big.Int).SetUint64(sp.Block), sp.Slot,
+			ethstorageproof.ErrValueMismatch},
+		{new(big.Int).SetBytes(balance), big.NewInt(4325156), sp.Slot,
+			ethstorageproof.ErrCheckpointRange},
+		{new(big.Int).SetBytes(balance), new(big.Int).SetUint64(sp.Block), sp.Slot + 1,
+			ethstorageproof.ErrKeyOffsetOverflow},
+	}
+	for i, tt := range tests {
+		err := VerifyProof(sp.Address, sp.Root, sp.StorageProofs, tt.slot, tt.balance, tt.block)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("testcase %d: expected error %v, got %v", i, tt.err, err)
+		}
+	}
+}
+
 type testStorageProof struct {
 	Address       common.Address                  `json:"address"`
 	Root          common.Hash                     `json:"root"`
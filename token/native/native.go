@@ -0,0 +1,155 @@
+// Package native gets and verifies proofs of the native balance (ETH) of
+// accounts, so they can be used as a token.Token.
+package native
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+)
+
+// Decimals is the number of decimals of the native balance, in wei
+const Decimals = 18
+
+// Native proves the balance of the holder account itself, with an account
+// proof and no storage keys.  There is no token contract nor storage slot: as
+// a token.Token the index slot is ignored and DiscoverSlot returns 0.
+type Native struct {
+	src source.ProofSource
+}
+
+// New creates a new Native to get and verify native balance proofs
+func New(ctx context.Context, src source.ProofSource) (*Native, error) {
+	return &Native{src: src}, nil
+}
+
+// DiscoverSlot returns 0, as there is no slot to discover, and the balance of
+// holder at the block referred by ref in ether.
+func (n *Native) DiscoverSlot(ctx context.Context, holder common.Address,
+	ref source.BlockRef) (int, *big.Rat, error) {
+	sp, err := n.GetProof(ctx, holder, ref, 0)
+	if err != nil {
+		return -1, nil, err
+	}
+	return 0, helpers.BalanceToRat(sp.Balance.ToInt(), Decimals), nil
+}
+
+// GetProof returns the account proof of holder, which holds its balance, at
+// the block referred by ref.  islot is ignored.
+func (n *Native) GetProof(ctx context.Context, holder common.Address, ref source.BlockRef,
+	islot int) (*ethstorageproof.StorageProof, error) {
+	sp, err := n.src.GetProof(ctx, holder, nil, ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get account proof: %w", err)
+	}
+	if sp.Balance == nil {
+		return nil, fmt.Errorf("account proof of %s has no balance", holder.Hex())
+	}
+	return sp, nil
+}
+
+// VerifyProof verifies the proof of the balance of holder, which must be
+// targetBalance, as a token.Token.  Unlike the tokens storage, the balance is
+// proven against the state root of the block, and proofs must be the account
+// proof as returned by AccountResult.  The slot and the block are ignored.
+// The VerifyProof function verifies the StorageProof returned by GetProof
+// instead.
+func (n *Native) VerifyProof(holder common.Address, stateRoot common.Hash,
+	proofs []ethstorageproof.StorageResult, islot int, targetBalance,
+	targetBlock *big.Int) error {
+	if len(proofs) != 1 {
+		return fmt.Errorf("invalid length of proofs %d", len(proofs))
+	}
+	if targetBalance == nil {
+		return fmt.Errorf("target balance is nil")
+	}
+	// The key may have lost its leading zeros, as it is encoded as a quantity
+	proof := proofs[0]
+	if len(proof.Key) > common.AddressLength || common.BytesToAddress(proof.Key) != holder {
+		return fmt.Errorf("%w: proof key and holder do not match (%x != %x)",
+			ethstorageproof.ErrBadKey, holder, proof.Key)
+	}
+	// A non existing account has no balance
+	if len(proof.Value) == 0 {
+		if err := ethstorageproof.VerifyAccountAbsence(stateRoot, holder,
+			proof.Proof); err != nil {
+			return fmt.Errorf("proof is not valid: %w", err)
+		}
+		if targetBalance.Sign() != 0 {
+			return fmt.Errorf("%w: account %s does not exist, its balance is 0, not %v",
+				ethstorageproof.ErrValueMismatch, holder.Hex(), targetBalance)
+		}
+		return nil
+	}
+	var acc types.StateAccount
+	if err := rlp.DecodeBytes(proof.Value, &acc); err != nil {
+		return fmt.Errorf("cannot decode account: %w", err)
+	}
+	if balance := acc.Balance.ToBig(); balance.Cmp(targetBalance) != 0 {
+		return fmt.Errorf("%w: proof balance and provided balance mismatch (%v != %v)",
+			ethstorageproof.ErrValueMismatch, balance, targetBalance)
+	}
+	ok, err := ethstorageproof.VerifyProof(stateRoot, holder.Bytes(), proof.Value, proof.Proof)
+	if err != nil {
+		return fmt.Errorf("proof is not valid: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("proof is not valid")
+	}
+	return nil
+}
+
+// AccountResult returns the account proof of sp as a StorageResult of the
+// state trie, to be verified by Native.VerifyProof against the state root:
+// the key is the account address, the value its RLP encoding, and the proof
+// the account proof.  The value is empty if the account does not exist.
+func AccountResult(sp *ethstorageproof.StorageProof) (ethstorageproof.StorageResult, error) {
+	result := ethstorageproof.StorageResult{
+		Key:   sp.Address.Bytes(),
+		Proof: sp.AccountProof,
+	}
+	if ethstorageproof.VerifyAccountAbsence(sp.StateRoot, sp.Address, sp.AccountProof) == nil {
+		return result, nil
+	}
+	if sp.Balance == nil {
+		return result, fmt.Errorf("account proof of %s has no balance", sp.Address.Hex())
+	}
+	value, err := rlp.EncodeToBytes([]interface{}{
+		sp.Nonce, sp.Balance.ToInt(), sp.StorageHash, sp.CodeHash,
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Value = value
+	return result, nil
+}
+
+// VerifyProof verifies that the account proof sp proves the balance of holder
+// is targetBalance, against the state root of sp.  The state root must be
+// verified against the block, as ethstorageproof.VerifyBlockHeader does.
+func VerifyProof(holder common.Address, sp *ethstorageproof.StorageProof,
+	targetBalance *big.Int) error {
+	if sp.Address != holder {
+		return fmt.Errorf("%w: proof account and holder do not match (%s != %s)",
+			ethstorageproof.ErrBadKey, sp.Address.Hex(), holder.Hex())
+	}
+	if targetBalance == nil {
+		return fmt.Errorf("target balance is nil")
+	}
+	if sp.Balance == nil || sp.Balance.ToInt().Cmp(targetBalance) != 0 {
+		return fmt.Errorf("%w: proof balance and provided balance mismatch (%v != %v)",
+			ethstorageproof.ErrValueMismatch, sp.Balance, targetBalance)
+	}
+	// The account proof proves the balance
+	if _, err := ethstorageproof.VerifyEIP1186(sp); err != nil {
+		return fmt.Errorf("proof is not valid: %w", err)
+	}
+	return nil
+}
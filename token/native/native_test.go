@@ -0,0 +1,98 @@
+package native
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/testchain"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/tokentest"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+)
+
+func TestNative(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	alice, bob := tokentest.Alice, tokentest.Bob
+	chain := testchain.New()
+	defer chain.Close()
+	tokentest.Play(chain, []tokentest.Step{
+		{Block: 1, Holder: alice, Value: 1e18},
+		{Block: 2, Holder: alice, Value: 3e18},
+	}, func(s tokentest.Step) {
+		chain.SetBalance(s.Holder, big.NewInt(s.Value))
+	})
+	src := source.NewRPC(chain.Client())
+
+	n, err := New(ctx, src)
+	c.Assert(err, qt.IsNil)
+	slot, balance, err := n.DiscoverSlot(ctx, alice, source.BlockNumber(big.NewInt(1)))
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, 0)
+	c.Assert(balance.Cmp(big.NewRat(1, 1)), qt.Equals, 0)
+
+	// As a token.Token, the account is verified against the state root
+	checker := &tokentest.Checker{Chain: chain, Token: n, Slot: slot}
+	checker.Proofs = func(c *qt.C,
+		sp *ethstorageproof.StorageProof) (common.Hash, []ethstorageproof.StorageResult) {
+		c.Assert(sp.StorageProof, qt.HasLen, 0)
+		result, err := AccountResult(sp)
+		c.Assert(err, qt.IsNil)
+		return sp.StateRoot, []ethstorageproof.StorageResult{result}
+	}
+	checker.Extra = func(c *qt.C, tc tokentest.Case, sp *ethstorageproof.StorageProof) {
+		balance := big.NewInt(tc.Balance)
+		c.Assert(VerifyProof(tc.Holder, sp, balance), qt.IsNil)
+		err := VerifyProof(tc.Holder, sp, new(big.Int).Add(balance, big.NewInt(1)))
+		c.Assert(errors.Is(err, ethstorageproof.ErrValueMismatch), qt.IsTrue)
+		err = VerifyProof(tokentest.Other(tc.Holder), sp, balance)
+		c.Assert(errors.Is(err, ethstorageproof.ErrBadKey), qt.IsTrue)
+		// The account is not proven against the storage root
+		_, proofs := checker.Proofs(c, sp)
+		err = n.VerifyProof(tc.Holder, sp.StorageHash, proofs, slot, balance, nil)
+		c.Assert(err, qt.Not(qt.IsNil))
+	}
+	checker.Check(c,
+		tokentest.Case{Holder: alice, Block: 1, Balance: 1e18},
+		tokentest.Case{Holder: alice, Block: 2, Balance: 3e18},
+		// bob has no account
+		tokentest.Case{Holder: bob, Block: 2, Balance: 0},
+	)
+}
+
+func TestNativeLeadingZeros(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	holder := common.HexToAddress("0x00ab000000000000000000000000000000000001")
+	other := common.HexToAddress("0xab00000000000000000000000000000000000001")
+	chain := testchain.New()
+	defer chain.Close()
+	chain.SetBalance(holder, big.NewInt(1e18))
+	chain.Commit()
+	src := source.NewRPC(chain.Client())
+
+	n, err := New(ctx, src)
+	c.Assert(err, qt.IsNil)
+	sp, err := n.GetProof(ctx, holder, source.Latest, 0)
+	c.Assert(err, qt.IsNil)
+	result, err := AccountResult(sp)
+	c.Assert(err, qt.IsNil)
+
+	// The key is encoded as a quantity, without its leading zero byte
+	data, err := json.Marshal([]ethstorageproof.StorageResult{result})
+	c.Assert(err, qt.IsNil)
+	var proofs []ethstorageproof.StorageResult
+	c.Assert(json.Unmarshal(data, &proofs), qt.IsNil)
+	c.Assert(proofs[0].Key, qt.HasLen, common.AddressLength-1)
+	c.Assert(n.VerifyProof(holder, sp.StateRoot, proofs, 0, big.NewInt(1e18), nil), qt.IsNil)
+	err = n.VerifyProof(other, sp.StateRoot, proofs, 0, big.NewInt(1e18), nil)
+	c.Assert(errors.Is(err, ethstorageproof.ErrBadKey), qt.IsTrue)
+	proofs[0].Key = append([]byte{1}, sp.Address.Bytes()...)
+	err = n.VerifyProof(holder, sp.StateRoot, proofs, 0, big.NewInt(1e18), nil)
+	c.Assert(errors.Is(err, ethstorageproof.ErrBadKey), qt.IsTrue)
+}
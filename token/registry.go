@@ -0,0 +1,233 @@
+package token
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+)
+
+// ErrRegistryTypeMismatch is returned when the token type requested for a
+// contract is not the one in the registry
+var ErrRegistryTypeMismatch = errors.New("token type does not match the registry")
+
+// defaultRegistry holds the known layouts of popular tokens.  It only holds
+// slots checked against mainnet proofs; the other tokens are discovered once
+// and saved to the registry file passed to LoadRegistry, as the
+// verify-top-50-erc20 example does for its list.
+//
+//go:embed registry.json
+var defaultRegistry []byte
+
+// RegistryEntry is the storage layout of a token contract on a chain
+type RegistryEntry struct {
+	ChainID uint64         `json:"chainId"`
+	Address common.Address `json:"address"`
+	Symbol  string         `json:"symbol,omitempty"`
+	Type    string         `json:"type"`
+	Slot    int            `json:"slot"`
+}
+
+// TokenType returns the token type of the entry
+func (e *RegistryEntry) TokenType() (int, error) {
+	return ParseTokenType(e.Type)
+}
+
+// registryKey identifies a contract on a chain
+type registryKey struct {
+	chainID uint64
+	address common.Address
+}
+
+// Registry maps a chain id and a contract address to the token type and the
+// index slot of the contract, so the slot does not need to be discovered
+// again.  The registry is stored as a JSON array of RegistryEntry.  It is safe
+// for concurrent use.
+type Registry struct {
+	lock    sync.RWMutex
+	entries map[registryKey]RegistryEntry
+	// path is the file the registry is saved to, if any
+	path string
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[registryKey]RegistryEntry)}
+}
+
+// DefaultRegistry creates a registry with the known layouts of popular
+// tokens, such as USDT, USDC, DAI, WETH or LINK.
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	if err := r.Load(defaultRegistry); err != nil {
+		panic(fmt.Sprintf("invalid default registry: %v", err))
+	}
+	return r
+}
+
+// LoadRegistry creates a registry with the default entries and the entries
+// of the JSON file at path, which does not need to exist.  The registry is
+// saved to path when it is updated by Resolve.
+func LoadRegistry(path string) (*Registry, error) {
+	r := DefaultRegistry()
+	r.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Load(data); err != nil {
+		return nil, fmt.Errorf("cannot load registry %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Load adds the entries of a JSON array of RegistryEntry to the registry,
+// replacing the existing entries of the same contracts.
+func (r *Registry) Load(data []byte) error {
+	var entries []RegistryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if ttype, err := e.TokenType(); err != nil || ttype == TokenTypeAuto {
+			return fmt.Errorf("contract %s: invalid token type %q", e.Address.Hex(), e.Type)
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, e := range entries {
+		r.entries[registryKey{e.ChainID, e.Address}] = e
+	}
+	return nil
+}
+
+// Get returns the entry of the contract address on the chain
+func (r *Registry) Get(chainID uint64, address common.Address) (RegistryEntry, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	e, ok := r.entries[registryKey{chainID, address}]
+	return e, ok
+}
+
+// Set adds or replaces the entry of a contract
+func (r *Registry) Set(entry RegistryEntry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries[registryKey{entry.ChainID, entry.Address}] = entry
+}
+
+// MarshalJSON returns the registry entries as a JSON array, sorted by chain
+// id and address.
+func (r *Registry) MarshalJSON() ([]byte, error) {
+	r.lock.RLock()
+	entries := make([]RegistryEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	r.lock.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].ChainID != entries[j].ChainID {
+			return entries[i].ChainID < entries[j].ChainID
+		}
+		return entries[i].Address.Cmp(entries[j].Address) < 0
+	})
+	return json.MarshalIndent(entries, "", "  ")
+}
+
+// Save writes the registry to the file it was loaded from.  It does nothing
+// if the registry has no file.
+func (r *Registry) Save() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := r.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so the registry is not left
+	// truncated on errors
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// Resolve returns the Token of the contract at address and its registry
+// entry, with the token type and index slot.  If the contract is in the
+// registry for the chain of src, the entry is used, unless tokenType is
+// neither TokenTypeAuto nor the registered type, which returns
+// ErrRegistryTypeMismatch.  Otherwise the slot is
+// discovered for tokenType (or the type is detected for TokenTypeAuto) with
+// the balance of holder at the block referred by ref, and the registry is
+// updated and saved.
+func (r *Registry) Resolve(ctx context.Context, src source.ProofSource, tokenType int,
+	address, holder common.Address, ref source.BlockRef) (Token, *RegistryEntry, error) {
+	chainID, err := src.ChainID(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot get chain id: %w", err)
+	}
+	if e, ok := r.Get(chainID.Uint64(), address); ok {
+		ttype, err := e.TokenType()
+		if err != nil {
+			return nil, nil, err
+		}
+		if tokenType != TokenTypeAuto && tokenType != ttype {
+			return nil, nil, fmt.Errorf("%w: %s is registered as %s, not %s",
+				ErrRegistryTypeMismatch, address.Hex(), e.Type, TokenTypeName(tokenType))
+		}
+		t, err := New(ctx, src, ttype, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		return t, &e, nil
+	}
+	var t Token
+	var slot int
+	if tokenType == TokenTypeAuto {
+		d, err := Detect(ctx, src, address, holder, ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		t, tokenType, slot = d.Token, d.Type, d.Slot
+	} else {
+		if t, err = New(ctx, src, tokenType, address); err != nil {
+			return nil, nil, err
+		}
+		if slot, _, err = t.DiscoverSlot(ctx, holder, ref); err != nil {
+			return nil, nil, err
+		}
+	}
+	e := RegistryEntry{
+		ChainID: chainID.Uint64(),
+		Address: address,
+		Type:    TokenTypeName(tokenType),
+		Slot:    slot,
+	}
+	r.Set(e)
+	if err := r.Save(); err != nil {
+		return nil, nil, fmt.Errorf("cannot save registry: %w", err)
+	}
+	return t, &e, nil
+}
@@ -0,0 +1,76 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/testchain"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/tokentest"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+)
+
+func TestDefaultRegistry(t *testing.T) {
+	c := qt.New(t)
+	r := DefaultRegistry()
+	e, ok := r.Get(1, common.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7"))
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(e.Symbol, qt.Equals, "USDT")
+	c.Assert(e.Slot, qt.Equals, 2)
+	ttype, err := e.TokenType()
+	c.Assert(err, qt.IsNil)
+	c.Assert(ttype, qt.Equals, TokenTypeMapbased)
+	_, ok = r.Get(5, common.HexToAddress("0xdac17f958d2ee523a2206206994597c13d831ec7"))
+	c.Assert(ok, qt.IsFalse)
+
+	c.Assert(r.Load([]byte(`[{"chainId":1,"address":"0x0000000000000000000000000000000000000001",
+		"type":"unknown","slot":1}]`)),
+		qt.ErrorMatches, ".*invalid token type \"unknown\"")
+}
+
+func TestRegistryResolve(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	chain := testchain.New()
+	defer chain.Close()
+	addr := chain.DeployMinime("Test MiniMe", "MMT", 18)
+	chain.SetMinimeBalance(addr, holders[0], ether(1))
+	chain.Commit()
+	src := source.NewRPC(chain.Client())
+
+	path := filepath.Join(c.TempDir(), "registry.json")
+	r, err := LoadRegistry(path)
+	c.Assert(err, qt.IsNil)
+	tk, e, err := r.Resolve(ctx, src, TokenTypeMinime, addr, holders[0], source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(e.Slot, qt.Equals, testchain.MinimeBalancesSlot)
+	checker(chain, tk, e.Slot).Check(c, tokentest.Case{Holder: holders[0], Block: 1, Balance: 1})
+
+	// The discovered slot is saved, and used without a holder balance to
+	// discover it
+	r, err = LoadRegistry(path)
+	c.Assert(err, qt.IsNil)
+	saved, ok := r.Get(1337, addr)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(saved, qt.DeepEquals, RegistryEntry{ChainID: 1337, Address: addr, Type: "minime",
+		Slot: testchain.MinimeBalancesSlot})
+	for _, ttype := range []int{TokenTypeMinime, TokenTypeAuto} {
+		tk, e, err = r.Resolve(ctx, src, ttype, addr, holders[1], source.Latest)
+		c.Assert(err, qt.IsNil)
+		c.Assert(*e, qt.DeepEquals, saved)
+		checker(chain, tk, e.Slot).Check(c, tokentest.Case{Holder: holders[0], Block: 1, Balance: 1})
+	}
+	// An explicit type does not silently change to the registered one
+	_, _, err = r.Resolve(ctx, src, TokenTypeMapbased, addr, holders[0], source.Latest)
+	c.Assert(errors.Is(err, ErrRegistryTypeMismatch), qt.IsTrue, qt.Commentf("%v", err))
+	_, ok = r.Get(1, common.HexToAddress("0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"))
+	c.Assert(ok, qt.IsTrue)
+
+	// A zero balance can not be used to discover the slot
+	_, _, err = NewRegistry().Resolve(ctx, src, TokenTypeMinime, addr, holders[1],
+		source.Latest)
+	c.Assert(err, qt.ErrorMatches, ".*holder has no balance.*")
+}
@@ -6,33 +6,116 @@ import (
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+	"github.com/vocdoni/storage-proofs-eth-go/token/comp"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc1155"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc20votes"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc721"
 	"github.com/vocdoni/storage-proofs-eth-go/token/mapbased"
 	"github.com/vocdoni/storage-proofs-eth-go/token/minime"
+	"github.com/vocdoni/storage-proofs-eth-go/token/native"
 )
 
 const (
 	TokenTypeMapbased = iota
 	TokenTypeMinime
+	// TokenTypeVyper is a map based token compiled with Vyper, which hashes
+	// the index slot of the balances map before the holder address, see
+	// helpers.CompilerLayout
+	TokenTypeVyper
+	// TokenTypeERC721 is a non fungible token, which balance is the number
+	// of tokens of the holder
+	TokenTypeERC721
+	// TokenTypeERC1155 is a multi token, which balance is the balance of a
+	// token id of the holder.  New returns the Token of the token id 0, use
+	// erc1155.New (or WithID) for other token ids.
+	TokenTypeERC1155
+	// TokenTypeERC20Votes is an OpenZeppelin ERC20Votes token, which balance
+	// is the voting power delegated to the holder
+	TokenTypeERC20Votes
+	// TokenTypeComp is a Compound COMP style governance token, which balance
+	// is the voting power delegated to the holder
+	TokenTypeComp
+	// TokenTypeNative is the native balance (ETH) of the holder account,
+	// proven with its account proof.  The token address is ignored.
+	TokenTypeNative
 )
 
+// TokenTypeAuto requests the token type to be detected with Detect.  It is
+// not accepted by New.
+const TokenTypeAuto = -1
+
+// Token discovers the storage layout of a token contract, and gets and
+// verifies storage proofs of the balance of a holder.  The block of the
+// storage reads is referred by a source.BlockRef.  VerifyProof verifies the
+// proofs against root, which is the storage root of the token contract, or
+// the state root of the block for TokenTypeNative, which proves the account
+// itself.
 type Token interface {
-	DiscoverSlot(ctx context.Context, holder common.Address) (int, *big.Rat, error)
-	GetProof(ctx context.Context, holder common.Address, block *big.Int,
+	DiscoverSlot(ctx context.Context, holder common.Address,
+		ref source.BlockRef) (int, *big.Rat, error)
+	GetProof(ctx context.Context, holder common.Address, ref source.BlockRef,
 		indexSlot int) (*ethstorageproof.StorageProof, error)
-	VerifyProof(holder common.Address, storageRoot common.Hash,
+	VerifyProof(holder common.Address, root common.Hash,
 		proofs []ethstorageproof.StorageResult, indexSlot int, targetBalance,
 		targetBlock *big.Int) error
 }
 
-func New(ctx context.Context, rpcCli *rpc.Client, tokenType int,
+// tokenTypeNames holds the names of the token types, as used in the registry
+// and the command line
+var tokenTypeNames = map[int]string{
+	TokenTypeMapbased:   "mapbased",
+	TokenTypeMinime:     "minime",
+	TokenTypeVyper:      "vyper",
+	TokenTypeERC721:     "erc721",
+	TokenTypeERC1155:    "erc1155",
+	TokenTypeERC20Votes: "erc20votes",
+	TokenTypeComp:       "comp",
+	TokenTypeNative:     "native",
+	TokenTypeAuto:       "auto",
+}
+
+// TokenTypeName returns the name of the token type
+func TokenTypeName(tokenType int) string {
+	if name, ok := tokenTypeNames[tokenType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", tokenType)
+}
+
+// ParseTokenType returns the token type with name
+func ParseTokenType(name string) (int, error) {
+	for tokenType, n := range tokenTypeNames {
+		if n == name {
+			return tokenType, nil
+		}
+	}
+	return -1, fmt.Errorf("token type %q unknown", name)
+}
+
+// New returns the Token implementation of tokenType for the contract at
+// address
+func New(ctx context.Context, src source.ProofSource, tokenType int,
 	address common.Address) (Token, error) {
 	switch tokenType {
 	case TokenTypeMapbased:
-		return mapbased.New(ctx, rpcCli, address)
+		return mapbased.New(ctx, src, address)
 	case TokenTypeMinime:
-		return minime.New(ctx, rpcCli, address)
+		return minime.New(ctx, src, address)
+	case TokenTypeVyper:
+		return mapbased.NewWithLayout(ctx, src, address, helpers.LayoutVyper)
+	case TokenTypeERC721:
+		return erc721.New(ctx, src, address)
+	case TokenTypeERC1155:
+		return erc1155.New(ctx, src, address, new(big.Int))
+	case TokenTypeERC20Votes:
+		return erc20votes.New(ctx, src, address)
+	case TokenTypeComp:
+		return comp.New(ctx, src, address)
+	case TokenTypeNative:
+		return native.New(ctx, src)
 	default:
 		return nil, fmt.Errorf("tokentype %d unknown", tokenType)
 	}
@@ -0,0 +1,426 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	qt "github.com/frankban/quicktest"
+	"github.com/vocdoni/storage-proofs-eth-go/ethstorageproof"
+	"github.com/vocdoni/storage-proofs-eth-go/helpers"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/testchain"
+	"github.com/vocdoni/storage-proofs-eth-go/internal/tokentest"
+	"github.com/vocdoni/storage-proofs-eth-go/source"
+	"github.com/vocdoni/storage-proofs-eth-go/token/erc20"
+	"github.com/vocdoni/storage-proofs-eth-go/token/mapbased"
+	"github.com/vocdoni/storage-proofs-eth-go/token/minime"
+)
+
+var holders = []common.Address{
+	common.HexToAddress("0x5041ed759dd4afc3a72b8192c143f72f4724081a"),
+	common.HexToAddress("0x00000000219ab540356cbb839cbe05303d7705fa"),
+	common.HexToAddress("0x0000000000000000000000000000000000000001"),
+}
+
+// ether returns n tokens of 18 decimals
+func ether(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e18))
+}
+
+// checker returns the checker of the proofs of tk at slot, which balances
+// are whole tokens of 18 decimals
+func checker(chain *testchain.Chain, tk Token, slot int) *tokentest.Checker {
+	ch := &tokentest.Checker{Chain: chain, Token: tk, Slot: slot, Amount: ether}
+	// The checkpoints of the other holder are on another array
+	if _, ok := tk.(*minime.Minime); ok {
+		ch.ErrOtherHolder = ethstorageproof.ErrKeyOffsetOverflow
+	}
+	return ch
+}
+
+func TestMapbased(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	chain := testchain.New()
+	defer chain.Close()
+	addr := chain.DeployERC20("Test Token", "TST", 18)
+	for i, h := range holders {
+		chain.SetERC20Balance(addr, h, ether(int64(i+1)))
+	}
+	chain.Commit()
+	chain.SetERC20Balance(addr, holders[0], ether(5))
+	chain.Commit()
+
+	src := source.NewRPC(chain.Client())
+	ts, err := erc20.New(ctx, src, addr)
+	c.Assert(err, qt.IsNil)
+	td, err := ts.GetTokenData(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(td.Name, qt.Equals, "Test Token")
+	c.Assert(td.Symbol, qt.Equals, "TST")
+	c.Assert(td.Decimals, qt.Equals, uint8(18))
+	c.Assert(td.TotalSupply.Cmp(ether(10)), qt.Equals, 0)
+
+	tk, err := New(ctx, src, TokenTypeMapbased, addr)
+	c.Assert(err, qt.IsNil)
+	slot, amount, err := tk.DiscoverSlot(ctx, holders[0], source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, testchain.ERC20BalancesSlot)
+	c.Assert(amount.Cmp(big.NewRat(5, 1)), qt.Equals, 0)
+
+	checker(chain, tk, slot).Check(c,
+		tokentest.Case{Holder: holders[0], Block: 1, Balance: 1},
+		tokentest.Case{Holder: holders[0], Block: 2, Balance: 5},
+		tokentest.Case{Holder: holders[1], Block: 2, Balance: 2},
+	)
+
+	// A wrong balance is not accepted
+	sp, err := tk.GetProof(ctx, holders[1], source.BlockNumber(big.NewInt(2)), slot)
+	c.Assert(err, qt.IsNil)
+	c.Assert(tk.VerifyProof(holders[1], sp.StorageHash, sp.StorageProof, slot,
+		ether(1), big.NewInt(2)), qt.ErrorMatches, ".*balance mismatch.*")
+}
+
+func TestWithoutDecimals(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	chain := testchain.New()
+	defer chain.Close()
+	addr := chain.DeployStandardToken()
+	chain.SetERC20Balance(addr, holders[0], big.NewInt(1500))
+	chain.Commit()
+	src := source.NewRPC(chain.Client())
+
+	// decimals is optional, the balance is returned in base units
+	tk, err := New(ctx, src, TokenTypeMapbased, addr)
+	c.Assert(err, qt.IsNil)
+	slot, amount, err := tk.DiscoverSlot(ctx, holders[0], source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, testchain.StandardTokenBalancesSlot)
+	c.Assert(amount.Cmp(big.NewRat(1500, 1)), qt.Equals, 0)
+	ch := &tokentest.Checker{Chain: chain, Token: tk, Slot: slot}
+	ch.Check(c, tokentest.Case{Holder: holders[0], Block: 1, Balance: 1500})
+
+	// Only a reverted call is taken as a token without decimals
+	tk, err = New(ctx, failingDecimalsSource{src}, TokenTypeMapbased, addr)
+	c.Assert(err, qt.IsNil)
+	_, _, err = tk.DiscoverSlot(ctx, holders[0], source.Latest)
+	c.Assert(errors.Is(err, errCallFailed), qt.IsTrue)
+}
+
+// errCallFailed is returned by failingDecimalsSource
+var errCallFailed = errors.New("call failed")
+
+// failingDecimalsSource is a ProofSource which fails the contract calls
+// without arguments, such as decimals
+type failingDecimalsSource struct {
+	source.ProofSource
+}
+
+func (s failingDecimalsSource) CallContractAtHash(ctx context.Context, msg ethereum.CallMsg,
+	blockHash common.Hash) ([]byte, error) {
+	if len(msg.Data) == 4 {
+		return nil, errCallFailed
+	}
+	return s.ProofSource.CallContractAtHash(ctx, msg, blockHash)
+}
+
+func TestSampleToken(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	chain := testchain.New()
+	defer chain.Close()
+	addr := chain.DeploySampleToken("Sample Token", "SMP", 18, ether(1000))
+	chain.Commit()
+	chain.TransferSampleToken(addr, holders[0], ether(250))
+	chain.Commit()
+	chain.TransferSampleToken(addr, holders[0], ether(50))
+	chain.TransferSampleToken(addr, holders[1], ether(1))
+	chain.Commit()
+	src := source.NewRPC(chain.Client())
+
+	// The balances are changed by the transfers of the compiled contract
+	tk, err := New(ctx, src, TokenTypeMapbased, addr)
+	c.Assert(err, qt.IsNil)
+	slot, amount, err := tk.DiscoverSlot(ctx, holders[0], source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, testchain.SampleTokenBalancesSlot)
+	c.Assert(amount.Cmp(big.NewRat(300, 1)), qt.Equals, 0)
+	checker(chain, tk, slot).Check(c,
+		tokentest.Case{Holder: holders[0], Block: 2, Balance: 250},
+		tokentest.Case{Holder: holders[0], Block: 3, Balance: 300},
+		tokentest.Case{Holder: holders[1], Block: 3, Balance: 1},
+		tokentest.Case{Holder: holders[1], Block: 2, Balance: 0},
+	)
+}
+
+func TestMinime(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	chain := testchain.New()
+	defer chain.Close()
+	addr := chain.DeployMinime("Test MiniMe", "MMT", 18)
+	chain.Commit()
+
+	// holders[0] gets checkpoints on blocks 2, 4 and 6
+	// holders[1] gets a single checkpoint on block 3
+	balances := map[int64]int64{}
+	for block := int64(2); block <= 8; block++ {
+		switch block {
+		case 2, 4, 6:
+			chain.SetMinimeBalance(addr, holders[0], ether(block))
+		case 3:
+			chain.SetMinimeBalance(addr, holders[1], ether(7))
+		}
+		chain.Commit()
+		switch {
+		case block >= 6:
+			balances[block] = 6
+		case block >= 4:
+			balances[block] = 4
+		default:
+			balances[block] = 2
+		}
+	}
+
+	src := source.NewRPC(chain.Client())
+	tk, err := New(ctx, src, TokenTypeMinime, addr)
+	c.Assert(err, qt.IsNil)
+	slot, amount, err := tk.DiscoverSlot(ctx, holders[0], source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, testchain.MinimeBalancesSlot)
+	c.Assert(amount.Cmp(big.NewRat(6, 1)), qt.Equals, 0)
+
+	var cases []tokentest.Case
+	for block := int64(2); block <= 8; block++ {
+		cases = append(cases, tokentest.Case{Holder: holders[0], Block: uint64(block),
+			Balance: balances[block]})
+	}
+	cases = append(cases,
+		tokentest.Case{Holder: holders[1], Block: 3, Balance: 7},
+		tokentest.Case{Holder: holders[1], Block: 8, Balance: 7},
+	)
+	checker(chain, tk, slot).Check(c, cases...)
+
+	// The slot is discovered and the proof taken at the finalized block, the
+	// block 32 once the next one is sealed
+	for chain.Head().Number.Int64() < 32 {
+		chain.Commit()
+	}
+	chain.SetMinimeBalance(addr, holders[0], ether(9))
+	chain.Commit()
+	c.Assert(chain.Finalized().Number.Int64(), qt.Equals, int64(32))
+	slot, amount, err = tk.DiscoverSlot(ctx, holders[0], source.Finalized)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, testchain.MinimeBalancesSlot)
+	c.Assert(amount.Cmp(big.NewRat(6, 1)), qt.Equals, 0)
+	sp, err := tk.GetProof(ctx, holders[0], source.Finalized, slot)
+	c.Assert(err, qt.IsNil)
+	c.Assert(sp.Height.Int64(), qt.Equals, int64(32))
+	c.Assert(sp.BlockHash, qt.Equals, chain.Header(32).Hash())
+	c.Assert(tk.VerifyProof(holders[0], sp.StorageHash, sp.StorageProof, slot, ether(6),
+		sp.Height), qt.IsNil)
+}
+
+func TestVyper(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	chain := testchain.New()
+	defer chain.Close()
+	addr := chain.DeployERC20WithLayout("Vyper Token", "VYP", 18, 2, helpers.LayoutVyper)
+	chain.Commit()
+	chain.SetERC20Balance(addr, holders[0], ether(7))
+	chain.Commit()
+	src := source.NewRPC(chain.Client())
+
+	tk, err := New(ctx, src, TokenTypeVyper, addr)
+	c.Assert(err, qt.IsNil)
+	slot, amount, err := tk.DiscoverSlot(ctx, holders[0], source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, 2)
+	c.Assert(amount.Cmp(big.NewRat(7, 1)), qt.Equals, 0)
+	checker(chain, tk, slot).Check(c, tokentest.Case{Holder: holders[0], Block: 2, Balance: 7})
+	sp, err := tk.GetProof(ctx, holders[0], source.BlockNumber(big.NewInt(2)), slot)
+	c.Assert(err, qt.IsNil)
+
+	// A token with the Solidity layout does not verify the Vyper balances
+	// map, until the discovery reports and switches to the Vyper layout
+	tk, err = New(ctx, src, TokenTypeMapbased, addr)
+	c.Assert(err, qt.IsNil)
+	err = tk.VerifyProof(holders[0], sp.StorageHash, sp.StorageProof, slot, ether(7), nil)
+	c.Assert(errors.Is(err, ethstorageproof.ErrBadKey), qt.IsTrue, qt.Commentf("%v", err))
+	m := tk.(*mapbased.Mapbased)
+	slot, layout, amount, err := m.DiscoverLayoutSlot(ctx, holders[0], source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, 2)
+	c.Assert(layout, qt.Equals, helpers.LayoutVyper)
+	c.Assert(amount.Cmp(big.NewRat(7, 1)), qt.Equals, 0)
+	c.Assert(m.Layout(), qt.Equals, helpers.LayoutSolidity)
+	_, _, err = tk.DiscoverSlot(ctx, holders[0], source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(m.Layout(), qt.Equals, helpers.LayoutVyper)
+	checker(chain, tk, slot).Check(c, tokentest.Case{Holder: holders[0], Block: 2, Balance: 7})
+
+	// A Vyper token discovers the balances map of a Solidity token
+	solAddr := chain.DeployERC20("Test Token", "TST", 18)
+	chain.Commit()
+	chain.SetERC20Balance(solAddr, holders[0], ether(3))
+	chain.Commit()
+	tk, err = New(ctx, src, TokenTypeVyper, solAddr)
+	c.Assert(err, qt.IsNil)
+	slot, _, err = tk.DiscoverSlot(ctx, holders[0], source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, testchain.ERC20BalancesSlot)
+	c.Assert(tk.(*mapbased.Mapbased).Layout(), qt.Equals, helpers.LayoutSolidity)
+	checker(chain, tk, slot).Check(c, tokentest.Case{Holder: holders[0], Block: 4, Balance: 3})
+}
+
+func TestDiscoverSlotHolder(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	chain := testchain.New()
+	defer chain.Close()
+	erc20Addr := chain.DeployERC20("Test Token", "TST", 18)
+	minimeAddr := chain.DeployMinime("Test MiniMe", "MMT", 18)
+	emptyAddr := chain.DeployERC20("Empty Token", "EMP", 18)
+	chain.Commit()
+	chain.SetERC20Balance(erc20Addr, holders[0], ether(1))
+	chain.SetMinimeBalance(minimeAddr, holders[0], ether(1))
+	chain.Commit()
+	// The most recent recipient has no balance anymore
+	chain.SetERC20Balance(erc20Addr, holders[1], ether(2))
+	chain.SetMinimeBalance(minimeAddr, holders[1], ether(2))
+	chain.Commit()
+	chain.SetERC20Balance(erc20Addr, holders[1], big.NewInt(0))
+	chain.SetMinimeBalance(minimeAddr, holders[1], big.NewInt(0))
+	chain.Commit()
+	src := source.NewRPC(chain.Client())
+
+	for _, tc := range []struct {
+		ttype int
+		addr  common.Address
+		slot  int
+	}{
+		{TokenTypeMapbased, erc20Addr, testchain.ERC20BalancesSlot},
+		{TokenTypeMinime, minimeAddr, testchain.MinimeBalancesSlot},
+	} {
+		tk, err := New(ctx, src, tc.ttype, tc.addr)
+		c.Assert(err, qt.IsNil)
+		// A zero balance would match any empty slot
+		_, _, err = tk.DiscoverSlot(ctx, holders[1], source.Latest)
+		c.Assert(errors.Is(err, erc20.ErrZeroBalance), qt.IsTrue, qt.Commentf("%v", err))
+		// The holder is picked from the Transfer events
+		slot, amount, err := tk.DiscoverSlot(ctx, common.Address{}, source.Latest)
+		c.Assert(err, qt.IsNil)
+		c.Assert(slot, qt.Equals, tc.slot)
+		c.Assert(amount.Cmp(big.NewRat(1, 1)), qt.Equals, 0)
+	}
+
+	tk, err := New(ctx, src, TokenTypeMapbased, emptyAddr)
+	c.Assert(err, qt.IsNil)
+	_, _, err = tk.DiscoverSlot(ctx, common.Address{}, source.Latest)
+	c.Assert(errors.Is(err, erc20.ErrHolderNotFound), qt.IsTrue, qt.Commentf("%v", err))
+}
+
+// untracedSource is a ProofSource which does not implement
+// source.StorageTracer
+type untracedSource struct {
+	source.ProofSource
+}
+
+func TestDiscoverSlotTraced(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	chain := testchain.New()
+	defer chain.Close()
+	// The balances map is beyond the brute forced index slots
+	farAddr := chain.DeployERC20AtSlot("Far Token", "FAR", 18, 60)
+	proxyAddr := chain.DeployProxy(chain.DeployERC20AtSlot("Proxied Token", "PRX", 18, 40))
+	minimeProxyAddr := chain.DeployProxy(chain.DeployMinime("Test MiniMe", "MMT", 18))
+	chain.Commit()
+	chain.SetERC20Balance(farAddr, holders[0], ether(1))
+	chain.SetERC20Balance(proxyAddr, holders[0], ether(2))
+	chain.SetMinimeBalance(minimeProxyAddr, holders[0], ether(3))
+	chain.Commit()
+	src := source.NewRPC(chain.Client())
+
+	for _, tc := range []struct {
+		ttype   int
+		addr    common.Address
+		slot    int
+		balance int64
+	}{
+		{TokenTypeMapbased, farAddr, 60, 1},
+		{TokenTypeMapbased, proxyAddr, 40, 2},
+		{TokenTypeMinime, minimeProxyAddr, testchain.MinimeBalancesSlot, 3},
+	} {
+		tk, err := New(ctx, src, tc.ttype, tc.addr)
+		c.Assert(err, qt.IsNil)
+		slot, amount, err := tk.DiscoverSlot(ctx, holders[0], source.Latest)
+		c.Assert(err, qt.IsNil)
+		c.Assert(slot, qt.Equals, tc.slot)
+		c.Assert(amount.Cmp(big.NewRat(tc.balance, 1)), qt.Equals, 0)
+		checker(chain, tk, slot).Check(c,
+			tokentest.Case{Holder: holders[0], Block: 2, Balance: tc.balance})
+	}
+
+	// Without tracing, only the first index slots are brute forced
+	for _, addr := range []common.Address{farAddr, proxyAddr} {
+		tk, err := New(ctx, untracedSource{src}, TokenTypeMapbased, addr)
+		c.Assert(err, qt.IsNil)
+		_, _, err = tk.DiscoverSlot(ctx, holders[0], source.Latest)
+		c.Assert(err, qt.Equals, mapbased.ErrSlotNotFound)
+	}
+}
+
+// advancingSource is a ProofSource which seals a new block, changing the
+// balance of holder, each time a block header is requested.
+type advancingSource struct {
+	*source.RPC
+	chain  *testchain.Chain
+	token  common.Address
+	holder common.Address
+}
+
+func (s *advancingSource) BlockHeader(ctx context.Context,
+	ref source.BlockRef) (*ethstorageproof.BlockHeader, error) {
+	header, err := s.RPC.BlockHeader(ctx, ref)
+	s.chain.SetMinimeBalance(s.token, s.holder, ether(s.chain.Head().Number.Int64()))
+	s.chain.Commit()
+	return header, err
+}
+
+func TestMinimeHeadAdvances(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	chain := testchain.New()
+	defer chain.Close()
+	addr := chain.DeployMinime("Test MiniMe", "MMT", 18)
+	chain.SetMinimeBalance(addr, holders[0], ether(1))
+	chain.Commit()
+
+	src := &advancingSource{RPC: source.NewRPC(chain.Client()), chain: chain,
+		token: addr, holder: holders[0]}
+	tk, err := New(ctx, src, TokenTypeMinime, addr)
+	c.Assert(err, qt.IsNil)
+	slot, amount, err := tk.DiscoverSlot(ctx, holders[0], source.Latest)
+	c.Assert(err, qt.IsNil)
+	c.Assert(slot, qt.Equals, testchain.MinimeBalancesSlot)
+	c.Assert(amount.Cmp(big.NewRat(1, 1)), qt.Equals, 0)
+
+	// The proof of the latest block is taken at the head when the request
+	// started, even if the head advances while building it.
+	head := chain.Head()
+	sp, err := tk.GetProof(ctx, holders[0], source.Latest, slot)
+	c.Assert(err, qt.IsNil)
+	c.Assert(sp.BlockHash, qt.Equals, head.Hash())
+	c.Assert(chain.Head().Number.Cmp(head.Number), qt.Equals, 1)
+	ok, err := ethstorageproof.VerifyEIP1186WithBlockHash(sp, head.Hash())
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(tk.VerifyProof(holders[0], sp.StorageHash, sp.StorageProof, slot,
+		ether(head.Number.Int64()-1), head.Number), qt.IsNil)
+}